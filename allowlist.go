@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// getAllowedNetworks parses allowed_cidrs, single addresses are allowed
+// too and mean network of one host.
+func getAllowedNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("allowed_cidrs: invalid address %q", cidr)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+
+			cidr = fmt.Sprintf("%s/%d", ip, bits)
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("allowed_cidrs: %s", err)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// getClientIP returns address of the caller. If snobs is behind proxy, the
+// last address of X-Forwarded-For is used, because it's the one added by
+// the proxy itself, while addresses before it are given by the client and
+// can be forged.
+func getClientIP(request *http.Request, trustProxy bool) net.IP {
+	if trustProxy {
+		forwarded := request.Header.Values("X-Forwarded-For")
+		if len(forwarded) > 0 {
+			hops := strings.Split(forwarded[len(forwarded)-1], ",")
+
+			ip := net.ParseIP(strings.TrimSpace(hops[len(hops)-1]))
+			if ip != nil {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		host = request.RemoteAddr
+	}
+
+	return net.ParseIP(host)
+}
+
+// isAllowedClient checks caller against allowed_cidrs, anybody is allowed
+// if it's not configured.
+func (server *SnobServer) isAllowedClient(request *http.Request) bool {
+	if len(server.allowlist) == 0 {
+		return true
+	}
+
+	ip := getClientIP(request, server.config.TrustProxy)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range server.allowlist {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
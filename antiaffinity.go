@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+const SkipNeverTogether = "never_together"
+
+func validateNeverTogether(rules [][]string) ConfigErrors {
+	errs := ConfigErrors{}
+
+	for index, users := range rules {
+		if len(NewUserSet(users...)) < 2 {
+			errs = append(errs, fmt.Sprintf(
+				"never_together[%d]: at least two users are required", index,
+			))
+		}
+	}
+
+	return errs
+}
+
+// isNeverTogether reports whether user can't review together with any of
+// given users.
+func (server *SnobServer) isNeverTogether(user string, users []string) bool {
+	for _, rule := range server.config.NeverTogether {
+		set := NewUserSet(rule...)
+		if !set.Contains(user) {
+			continue
+		}
+
+		for _, other := range set.Intersect(users) {
+			if !strings.EqualFold(other, user) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// applyNeverTogether drops selected users who can't review together with
+// current reviewers or with users selected before them, dropped users are
+// replaced by random candidates which don't conflict with anybody.
+func (server *SnobServer) applyNeverTogether(
+	selection *Selection, users []string, candidates []string,
+) []string {
+	if len(server.config.NeverTogether) == 0 {
+		return users
+	}
+
+	var (
+		reviewers = selection.getReviewers()
+		kept      = []string{}
+		dropped   = []string{}
+	)
+
+	for _, user := range users {
+		if server.isNeverTogether(user, mergeUsers(reviewers, kept)) {
+			dropped = append(dropped, user)
+			continue
+		}
+
+		kept = append(kept, user)
+	}
+
+	if len(dropped) == 0 {
+		return users
+	}
+
+	selection.skip(dropped, dropped, SkipNeverTogether)
+
+	candidates = excludeUsers(candidates, users)
+
+	for _, index := range rand.Perm(len(candidates)) {
+		if len(kept) >= len(users) {
+			break
+		}
+
+		user := candidates[index]
+		if server.isNeverTogether(user, mergeUsers(reviewers, kept)) {
+			continue
+		}
+
+		kept = append(kept, user)
+	}
+
+	return kept
+}
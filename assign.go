@@ -0,0 +1,406 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Assignment is the result of adding reviewers to a single pull request.
+type Assignment struct {
+	Project     string
+	Repository  string
+	PullRequest string
+	Group       string
+	Info        *ResponsePullRequest
+	Reviewers   []string
+
+	// SkippedUsers are candidates who were not added, with reasons.
+	SkippedUsers []SkippedUser
+
+	// Skipped assignment didn't change the pull request: author asked not
+	// to add reviewers, nobody was selected or everybody selected is
+	// already a reviewer.
+	Skipped bool
+
+	// Queued assignment will be done when maintenance window ends.
+	Queued bool
+
+	// DryRun assignment has reviewers selected, but not added.
+	DryRun bool
+}
+
+// AssignOptions tune single assignment, zero value means defaults.
+type AssignOptions struct {
+	// Excluded users are never selected.
+	Excluded []string
+
+	// Count overrides number of reviewers from config and author
+	// directive.
+	Count int
+
+	// Union and ExcludeGroups override union and exclude_groups from
+	// config, nil means no override.
+	Union         []string
+	ExcludeGroups []string
+
+	// RequestID of HTTP request which asked for assignment, it's added to
+	// log records.
+	RequestID string
+
+	// DryRun selects reviewers without adding them, dry_run from config
+	// forces it.
+	DryRun bool
+}
+
+// AssignReviewers selects reviewers from the group and adds them to the
+// pull request, key is nil if caller is not authenticated by API key.
+func (server *SnobServer) AssignReviewers(
+	key *APIKey, usergroup string, pullRequestURL string,
+	options AssignOptions,
+) (*Assignment, error) {
+	if instance := server.getInstance(pullRequestURL); instance != server {
+		return instance.AssignReviewers(key, usergroup, pullRequestURL, options)
+	}
+
+	project, repository, pullRequest, err := server.backend.ParsePullRequestURL(
+		pullRequestURL,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return server.assignPullRequest(
+		key, usergroup, project, repository, pullRequest, options,
+	)
+}
+
+// assignPullRequest does the same as AssignReviewers for the pull request
+// given by coordinates.
+func (server *SnobServer) assignPullRequest(
+	key *APIKey, usergroup string,
+	project string, repository string, pullRequest string,
+	options AssignOptions,
+) (assignment *Assignment, err error) {
+	defer func() {
+		server.statsd.Count(
+			"assignments", 1, "outcome:"+getOutcome(assignment, err),
+		)
+
+		// nothing happened to the pull request, so there is nothing to
+		// notify about
+		if err == nil && assignment.DryRun {
+			return
+		}
+
+		server.events.PublishAssignment(
+			project, repository, pullRequest, assignment, err,
+		)
+
+		server.notifyWebhooks(project, repository, pullRequest, assignment, err)
+	}()
+
+	dryRun := options.DryRun || server.config.DryRun
+
+	if !dryRun {
+		assignment, err = server.queueAssignment(
+			key, usergroup, project, repository, pullRequest, options,
+		)
+		if err != nil || assignment != nil {
+			return assignment, err
+		}
+	}
+
+	assignment, directives, err := server.preparePullRequest(
+		key, usergroup, project, repository, pullRequest, options,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if assignment.Skipped {
+		return assignment, nil
+	}
+
+	log := options.getLogger(project, repository, pullRequest)
+
+	info := assignment.Info
+
+	if !dryRun {
+		wait, err := server.limiter.TakeGroup(assignment.Group)
+		if err != nil {
+			return nil, err
+		}
+
+		if wait > 0 {
+			err := NewError(
+				ErrorRateLimited,
+				"assignment limit for group %s is exceeded", assignment.Group,
+			)
+			err.RetryAfter = wait
+
+			return nil, err
+		}
+	}
+
+	selection := server.NewSelection(project, repository, pullRequest, info)
+	selection.Exclude(options.Excluded)
+	selection.Union = options.Union
+	selection.ExcludeGroups = options.ExcludeGroups
+	selection.DryRun = dryRun
+
+	users, err := server.SelectReviewers(
+		selection, assignment.Group,
+		server.getReviewersCount(options, directives, selection.Rule),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	assignment.SkippedUsers = selection.SkippedUsers
+
+	if len(users) == 0 {
+		log.Infof("no reviewers to add")
+
+		assignment.Skipped = true
+		return assignment, nil
+	}
+
+	current := []string{}
+	for _, reviewer := range info.Reviewers {
+		current = append(current, reviewer.User.Name)
+	}
+
+	// pull request is not updated at all if selected reviewers are already
+	// there, so repeated assignment is no-op
+	users = selection.skip(users, current, SkipAlreadyReviewer)
+	assignment.SkippedUsers = selection.SkippedUsers
+
+	if len(users) == 0 {
+		log.Infof("selected reviewers are already added")
+
+		assignment.Skipped = true
+		return assignment, nil
+	}
+
+	if dryRun {
+		log.Infof("dry run, would add reviewers %s", strings.Join(users, ", "))
+
+		assignment.Reviewers = users
+		assignment.DryRun = true
+		return assignment, nil
+	}
+
+	err = server.AddReviewers(project, repository, pullRequest, info, users)
+	if err != nil {
+		return nil, err
+	}
+
+	assignment.Reviewers = users
+
+	log.With(LogFields{"group": assignment.Group}).Infof(
+		"added reviewers %s", strings.Join(users, ", "),
+	)
+
+	entry := HistoryEntry{
+		Time:        time.Now(),
+		Project:     project,
+		Repository:  repository,
+		PullRequest: pullRequest,
+		Author:      info.Author.User.Name,
+		Group:       assignment.Group,
+		Reviewers:   users,
+	}
+
+	err = server.history.Add(entry)
+	if err != nil {
+		log.Errorf("can't record assignment to history: %s", err)
+	}
+
+	err = server.commentAssignment(assignment)
+	if err != nil {
+		log.Errorf("can't comment assignment: %s", err)
+	}
+
+	err = server.notifyAssignment(assignment)
+	if err != nil {
+		log.Errorf("can't queue assignment notification: %s", err)
+	}
+
+	err = server.notifyEmail(assignment)
+	if err != nil {
+		log.Errorf("can't queue assignment email: %s", err)
+	}
+
+	return assignment, nil
+}
+
+// preparePullRequest checks that caller can modify the pull request and
+// applies author directives, assignment is marked as skipped if author
+// asked not to add reviewers.
+func (server *SnobServer) preparePullRequest(
+	key *APIKey, usergroup string,
+	project string, repository string, pullRequest string,
+	options AssignOptions,
+) (*Assignment, Directives, error) {
+	var directives Directives
+
+	log := options.getLogger(project, repository, pullRequest)
+
+	err := server.checkPullRequestAccess(key, project, repository)
+	if err != nil {
+		return nil, directives, err
+	}
+
+	info, err := server.GetPullRequestInfo(project, repository, pullRequest)
+	if err != nil {
+		return nil, directives, err
+	}
+
+	assignment := &Assignment{
+		Project:     project,
+		Repository:  repository,
+		PullRequest: pullRequest,
+		Group:       usergroup,
+		Info:        info,
+	}
+
+	server.applyRuleGroup(assignment)
+
+	directives = parseDirectives(info.Description)
+	if directives.Skip {
+		log.Infof("skipped by author directive")
+
+		assignment.Skipped = true
+		return assignment, directives, nil
+	}
+
+	if directives.Group != "" {
+		log.Infof("using group %s from author directive", directives.Group)
+
+		assignment.Group = directives.Group
+	}
+
+	err = checkGroupAccess(key, assignment.Group)
+	if err != nil {
+		return nil, directives, err
+	}
+
+	return assignment, directives, nil
+}
+
+// checkPullRequestAccess checks that repository is served at all and that
+// caller key, if any, is allowed to modify it.
+func (server *SnobServer) checkPullRequestAccess(
+	key *APIKey, project string, repository string,
+) error {
+	err := server.checkRepositoryAccess(project, repository)
+	if err != nil {
+		return err
+	}
+
+	if key != nil && !key.AllowsRepository(project, repository) {
+		return NewError(
+			ErrorForbidden,
+			"key %s is not allowed to modify repository %s/%s",
+			key.Name, project, repository,
+		)
+	}
+
+	return nil
+}
+
+// getLogger returns logger which marks records with the pull request and
+// request id if assignment is requested over HTTP.
+func (options AssignOptions) getLogger(
+	project string, repository string, pullRequest string,
+) *Logger {
+	log := logger.WithPullRequest(project, repository, pullRequest)
+	if options.RequestID != "" {
+		log = log.With(LogFields{"request_id": options.RequestID})
+	}
+
+	return log
+}
+
+// getReviewersCount returns number of reviewers requested by caller, by
+// author directive, by matching rule or configured by max_reviewers, in
+// that order.
+func (server *SnobServer) getReviewersCount(
+	options AssignOptions, directives Directives, rule RuleConfig,
+) int {
+	if options.Count > 0 {
+		return options.Count
+	}
+
+	if directives.Count > 0 {
+		return directives.Count
+	}
+
+	if rule.MaxReviewers > 0 {
+		return rule.MaxReviewers
+	}
+
+	return server.config.MaxReviewers
+}
+
+// parseAssignOptions reads options from query parameters of assignment
+// request: ?count=N, ?union=a,b, ?exclude_groups=c,d and ?dry_run=1.
+func parseAssignOptions(request *http.Request) (AssignOptions, error) {
+	options := AssignOptions{RequestID: getRequestID(request)}
+
+	query := request.URL.Query()
+
+	options.DryRun = query.Get("dry_run") == "1"
+
+	if _, ok := query["union"]; ok {
+		options.Union = getQueryList(query.Get("union"))
+	}
+
+	if _, ok := query["exclude_groups"]; ok {
+		options.ExcludeGroups = getQueryList(query.Get("exclude_groups"))
+	}
+
+	if raw := query.Get("count"); raw != "" {
+		count, err := strconv.Atoi(raw)
+		if err != nil || count <= 0 {
+			return options, NewError(
+				ErrorBadRequest, "invalid count %q, expected positive number", raw,
+			)
+		}
+
+		options.Count = count
+	}
+
+	return options, nil
+}
+
+// Changed reports whether reviewers were actually added.
+func (assignment *Assignment) Changed() bool {
+	return !assignment.Skipped && !assignment.Queued && !assignment.DryRun
+}
+
+func (assignment *Assignment) AuditEntry() AuditEntry {
+	return AuditEntry{
+		Action:      AuditAddReviewers,
+		Project:     assignment.Project,
+		Repository:  assignment.Repository,
+		PullRequest: assignment.PullRequest,
+		Group:       assignment.Group,
+		Reviewers:   assignment.Reviewers,
+	}
+}
+
+// getQueryList splits comma-separated query parameter, empty value gives
+// empty list, so config value can be overridden with nothing.
+func getQueryList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
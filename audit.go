@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	AuditAddReviewers   = "add_reviewers"
+	AuditRemove         = "remove_reviewers"
+	AuditImportSnapshot = "import_snapshot"
+	AuditAvailability   = "availability"
+	AuditUndo           = "undo"
+	AuditReroll         = "reroll"
+	AuditInvalidate     = "invalidate_cache"
+	AuditSyncDefaults   = "sync_default_reviewers"
+)
+
+type AuditEntry struct {
+	Time        time.Time `json:"time"`
+	Action      string    `json:"action"`
+	Caller      string    `json:"caller,omitempty"`
+	Remote      string    `json:"remote,omitempty"`
+	Project     string    `json:"project,omitempty"`
+	Repository  string    `json:"repository,omitempty"`
+	PullRequest string    `json:"pull_request,omitempty"`
+	Group       string    `json:"group,omitempty"`
+	Reviewers   []string  `json:"reviewers,omitempty"`
+	Details     string    `json:"details,omitempty"`
+
+	// Result is ok or category of error the action failed with, Error
+	// keeps its message, including response of Stash.
+	Result string `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// auditRecord is a line of audit log. Entry is kept as raw JSON, so hash
+// is verified against exactly the bytes which were written. Hash covers
+// hash of previous record, so any edit, removal or reordering of records
+// breaks the chain.
+type auditRecord struct {
+	Previous string          `json:"previous"`
+	Hash     string          `json:"hash"`
+	Entry    json.RawMessage `json:"entry"`
+}
+
+// AuditLog is append-only log of mutations. If key is given, records are
+// chained with HMAC instead of plain SHA-256, so the chain can't be
+// recomputed after editing without knowing the key. The file is shared
+// with command line runs and with new process during upgrade, so it's
+// locked and the chain head is read from it on every append.
+type AuditLog struct {
+	path  string
+	key   []byte
+	mutex sync.Mutex
+}
+
+func OpenAuditLog(path string, key string) (*AuditLog, error) {
+	return &AuditLog{
+		path: path,
+		key:  []byte(key),
+	}, nil
+}
+
+func (audit *AuditLog) Record(entry AuditEntry) error {
+	if audit.path == "" {
+		return nil
+	}
+
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	audit.mutex.Lock()
+	defer audit.mutex.Unlock()
+
+	file, err := os.OpenFile(
+		audit.path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600,
+	)
+	if err != nil {
+		return err
+	}
+
+	// lock is released when file is closed
+	defer file.Close()
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+
+	last, err := getLastAuditHash(file)
+	if err != nil {
+		return err
+	}
+
+	record := auditRecord{
+		Previous: last,
+		Hash:     getAuditHash(audit.key, last, raw),
+		Entry:    raw,
+	}
+
+	err = json.NewEncoder(file).Encode(record)
+	if err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+// getLastAuditHash returns hash of the last record, file is read backwards
+// until the start of the last line.
+func getLastAuditHash(file *os.File) (string, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		offset = info.Size()
+		tail   = []byte{}
+		line   []byte
+	)
+
+	for offset > 0 && line == nil {
+		size := int64(4096)
+		if offset < size {
+			size = offset
+		}
+
+		offset -= size
+
+		chunk := make([]byte, size)
+
+		_, err := file.ReadAt(chunk, offset)
+		if err != nil {
+			return "", err
+		}
+
+		tail = append(chunk, tail...)
+
+		trimmed := bytes.TrimRight(tail, "\n")
+		if index := bytes.LastIndexByte(trimmed, '\n'); index >= 0 {
+			line = trimmed[index+1:]
+		} else if offset == 0 {
+			line = trimmed
+		}
+	}
+
+	if len(line) == 0 {
+		return "", nil
+	}
+
+	var record auditRecord
+
+	err = json.Unmarshal(line, &record)
+	if err != nil {
+		return "", fmt.Errorf("invalid last record: %s", err)
+	}
+
+	return record.Hash, nil
+}
+
+// VerifyAuditLog checks the whole chain and returns number of valid
+// records.
+func VerifyAuditLog(path string, key string) (int, error) {
+	var (
+		count    = 0
+		previous = ""
+	)
+
+	err := readAuditLog(path, func(record auditRecord) error {
+		if record.Previous != previous {
+			return fmt.Errorf(
+				"record %d: previous hash mismatch, "+
+					"records were removed or reordered",
+				count+1,
+			)
+		}
+
+		expected := getAuditHash([]byte(key), previous, record.Entry)
+		if !hmac.Equal([]byte(expected), []byte(record.Hash)) {
+			return fmt.Errorf(
+				"record %d: hash mismatch, record was modified", count+1,
+			)
+		}
+
+		previous = record.Hash
+		count++
+
+		return nil
+	})
+
+	return count, err
+}
+
+func readAuditLog(path string, handle func(auditRecord) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	// writers hold exclusive lock, so the last line is complete
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_SH)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		var record auditRecord
+
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			return fmt.Errorf("line %d: invalid record: %s", line, err)
+		}
+
+		err = handle(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+func getAuditHash(key []byte, previous string, entry []byte) string {
+	var digest hash.Hash
+	if len(key) > 0 {
+		digest = hmac.New(sha256.New, key)
+	} else {
+		digest = sha256.New()
+	}
+
+	digest.Write([]byte(previous))
+	digest.Write([]byte{'\n'})
+	digest.Write(entry)
+
+	return hex.EncodeToString(digest.Sum(nil))
+}
+
+// audit records entry with caller of the request, request is nil for
+// command line runs.
+func (server *SnobServer) audit(request *http.Request, entry AuditEntry) {
+	entry.Time = time.Now()
+
+	if entry.Result == "" {
+		entry.Result = "ok"
+	}
+
+	if request == nil {
+		// background jobs keep remote address of request which queued them
+		if entry.Caller == "" && entry.Remote == "" {
+			entry.Caller = "cli"
+		}
+	} else {
+		entry.Remote = request.RemoteAddr
+
+		key := getRequestAPIKey(request)
+		if key != nil && entry.Caller == "" {
+			entry.Caller = key.Name
+		}
+	}
+
+	err := server.auditLog.Record(entry)
+	if err != nil {
+		logger.Errorf("can't write audit log: %s", err)
+	}
+}
+
+// auditFailure records action which failed with given error, pull request
+// is taken from its URL if entry doesn't specify it.
+func (server *SnobServer) auditFailure(
+	request *http.Request, entry AuditEntry, url string, err error,
+) {
+	if entry.Project == "" && url != "" {
+		var parseErr error
+
+		entry.Project, entry.Repository, entry.PullRequest, parseErr =
+			server.backend.ParsePullRequestURL(url)
+		if parseErr != nil {
+			entry.Details = url
+		}
+	}
+
+	entry.Result = getErrorCategory(err)
+	entry.Error = err.Error()
+
+	server.audit(request, entry)
+}
+
+type AuditPage struct {
+	Total   int          `json:"total"`
+	Offset  int          `json:"offset"`
+	Limit   int          `json:"limit"`
+	Entries []AuditEntry `json:"entries"`
+}
+
+// Query returns page of entries matching query, newest first. Repository
+// of query may be given as PROJ/repo, audit entries have no author.
+func (audit *AuditLog) Query(
+	query HistoryQuery, action string,
+) (AuditPage, error) {
+	page := AuditPage{
+		Offset:  query.Offset,
+		Limit:   query.Limit,
+		Entries: []AuditEntry{},
+	}
+
+	if audit.path == "" {
+		return page, nil
+	}
+
+	query.Author = ""
+
+	if query.Project == "" && strings.Contains(query.Repository, "/") {
+		query.Project, query.Repository = splitRepositoryName(query.Repository)
+	}
+
+	matched := []AuditEntry{}
+
+	err := readAuditLog(audit.path, func(record auditRecord) error {
+		var entry AuditEntry
+
+		err := json.Unmarshal(record.Entry, &entry)
+		if err != nil {
+			return err
+		}
+
+		if query.matches(HistoryEntry{
+			Time:        entry.Time,
+			Project:     entry.Project,
+			Repository:  entry.Repository,
+			PullRequest: entry.PullRequest,
+			Group:       entry.Group,
+			Reviewers:   entry.Reviewers,
+		}) && (action == "" || action == entry.Action) {
+			matched = append(matched, entry)
+		}
+
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return page, err
+	}
+
+	page.Total = len(matched)
+
+	for index := len(matched) - 1 - query.Offset; index >= 0; index-- {
+		if len(page.Entries) >= query.Limit {
+			break
+		}
+
+		page.Entries = append(page.Entries, matched[index])
+	}
+
+	return page, nil
+}
+
+// handleAudit serves GET /audit with filters of history API and ?action=.
+func (server *SnobServer) handleAudit(
+	response http.ResponseWriter, request *http.Request,
+) {
+	if request.Method != "GET" {
+		server.reportError(
+			response,
+			NewError(ErrorBadRequest, "method %s is not allowed", request.Method),
+			http.StatusMethodNotAllowed,
+		)
+		return
+	}
+
+	request, ok := server.authorize(response, request, OperationConfig)
+	if !ok {
+		return
+	}
+
+	if server.config.AuditFile == "" {
+		server.reportError(
+			response,
+			NewError(ErrorBadRequest, "audit_file is not configured"),
+			http.StatusBadRequest,
+		)
+		return
+	}
+
+	query, err := parseHistoryQuery(request.URL.Query())
+	if err != nil {
+		server.reportError(response, err, getErrorStatus(err))
+		return
+	}
+
+	if key := getRequestAPIKey(request); key != nil {
+		query.Allowed = key.AllowsRepository
+	}
+
+	page, err := server.auditLog.Query(query, request.URL.Query().Get("action"))
+	if err != nil {
+		server.reportError(response, err, http.StatusInternalServerError)
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(response).Encode(page)
+}
+
+func verifyAudit(path string, key string) {
+	if path == "" {
+		log.Fatal("audit_file is not configured")
+	}
+
+	count, err := VerifyAuditLog(path, key)
+	if err != nil {
+		log.Fatalf("audit log %s is corrupted: %s", path, err)
+	}
+
+	fmt.Printf("audit log %s is valid: %d records\n", path, count)
+}
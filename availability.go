@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AvailabilityConfig lists users who are unavailable until given time, in
+// addition to pauses set through API or optout page.
+type AvailabilityConfig struct {
+	Unavailable map[string]time.Time `toml:"unavailable"`
+}
+
+// AvailabilityRequest is body of PUT /availability/%user%, only given
+// fields are changed, empty until resumes assignments.
+type AvailabilityRequest struct {
+	Until    *string `json:"until"`
+	Capacity *int    `json:"capacity"`
+}
+
+type Availability struct {
+	Until time.Time `json:"until"`
+
+	// Capacity is maximum number of assignments per week, zero means
+	// unlimited.
+	Capacity int `json:"capacity,omitempty"`
+}
+
+// AvailabilityStore keeps users who paused their assignments or limited
+// their capacity, it's saved to the JSON file as a whole on every change
+// if path is given. Pauses from config are kept apart and never saved.
+type AvailabilityStore struct {
+	path       string
+	mutex      sync.RWMutex
+	users      map[string]Availability
+	configured map[string]time.Time
+}
+
+func OpenAvailabilityStore(path string) (*AvailabilityStore, error) {
+	store := &AvailabilityStore{
+		path:       path,
+		users:      map[string]Availability{},
+		configured: map[string]time.Time{},
+	}
+
+	if path == "" {
+		return store, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return store, nil
+		}
+
+		return nil, err
+	}
+
+	users := map[string]Availability{}
+
+	err = json.Unmarshal(data, &users)
+	if err != nil {
+		return nil, err
+	}
+
+	for user, availability := range users {
+		store.users[normalizeUser(user)] = availability
+	}
+
+	return store, nil
+}
+
+// Get returns availability of the user, pause from config is used if it
+// lasts longer than pause set by user.
+func (store *AvailabilityStore) Get(user string) Availability {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	availability := store.users[normalizeUser(user)]
+
+	until := store.configured[normalizeUser(user)]
+	if until.After(availability.Until) {
+		availability.Until = until
+	}
+
+	return availability
+}
+
+// SetConfigured replaces pauses listed in [availability] section.
+func (store *AvailabilityStore) SetConfigured(users map[string]time.Time) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.configured = map[string]time.Time{}
+	for user, until := range users {
+		store.configured[normalizeUser(user)] = until
+	}
+}
+
+func (store *AvailabilityStore) IsAvailable(user string) bool {
+	return !store.Get(user).IsPaused()
+}
+
+func (availability Availability) IsPaused() bool {
+	return availability.Until.After(time.Now())
+}
+
+// Filter returns only users which are available for assignment now.
+func (store *AvailabilityStore) Filter(users []string) []string {
+	available := []string{}
+	for _, user := range users {
+		if store.IsAvailable(user) {
+			available = append(available, user)
+		}
+	}
+
+	return available
+}
+
+// FilterCapacity returns only users which have not reached their capacity
+// according to given number of assignments during the last week, keyed by
+// normalized username.
+func (store *AvailabilityStore) FilterCapacity(
+	users []string, assignments map[string]int,
+) []string {
+	result := []string{}
+	for _, user := range users {
+		capacity := store.Get(user).Capacity
+		if capacity > 0 && assignments[normalizeUser(user)] >= capacity {
+			continue
+		}
+
+		result = append(result, user)
+	}
+
+	return result
+}
+
+func (store *AvailabilityStore) SetUnavailable(user string, until time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	user = normalizeUser(user)
+
+	availability := store.users[user]
+	availability.Until = until
+	store.users[user] = availability
+
+	return store.save()
+}
+
+func (store *AvailabilityStore) SetAvailable(user string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	user = normalizeUser(user)
+
+	availability := store.users[user]
+	availability.Until = time.Time{}
+	store.users[user] = availability
+
+	return store.save()
+}
+
+func (store *AvailabilityStore) SetCapacity(user string, capacity int) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	user = normalizeUser(user)
+
+	availability := store.users[user]
+	availability.Capacity = capacity
+	store.users[user] = availability
+
+	return store.save()
+}
+
+func (store *AvailabilityStore) save() error {
+	for user, availability := range store.users {
+		if !availability.IsPaused() && availability.Capacity == 0 {
+			delete(store.users, user)
+		}
+	}
+
+	if store.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(store.users, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	temporary := store.path + ".tmp"
+
+	err = ioutil.WriteFile(temporary, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temporary, store.path)
+}
+
+// handleAvailability serves GET, PUT and DELETE /availability/<user>, PUT
+// pauses assignments until given date and sets weekly capacity, DELETE
+// resumes assignments. Pauses from config can't be lifted this way.
+func (server *SnobServer) handleAvailability(
+	response http.ResponseWriter, request *http.Request,
+) {
+	operation := OperationAvailability
+	if request.Method == "GET" {
+		operation = OperationGroups
+	}
+
+	request, ok := server.authorize(response, request, operation)
+	if !ok {
+		return
+	}
+
+	user := strings.TrimPrefix(request.URL.Path, "/availability/")
+	if user == "" || strings.Contains(user, "/") {
+		server.reportError(
+			response, NewError(ErrorBadURL, "wrong url"), http.StatusBadRequest,
+		)
+		return
+	}
+
+	var err error
+
+	switch request.Method {
+	case "GET":
+
+	case "PUT":
+		var body AvailabilityRequest
+
+		err = json.NewDecoder(request.Body).Decode(&body)
+		if err != nil {
+			server.reportError(
+				response,
+				NewError(ErrorBadRequest, "invalid request body: %s", err),
+				http.StatusBadRequest,
+			)
+			return
+		}
+
+		err = server.setAvailability(user, body)
+
+	case "DELETE":
+		err = server.availability.SetAvailable(user)
+
+	default:
+		server.reportError(
+			response,
+			NewError(ErrorBadRequest, "method %s is not allowed", request.Method),
+			http.StatusMethodNotAllowed,
+		)
+		return
+	}
+
+	if err != nil {
+		server.reportError(response, err, getErrorStatus(err))
+		return
+	}
+
+	status := server.getAvailabilityStatus(user)
+
+	if request.Method != "GET" {
+		logger.Infof(
+			"availability of %s changed: paused until %s, capacity %d",
+			user, status.Until, status.Capacity,
+		)
+
+		server.audit(request, AuditEntry{
+			Action: AuditAvailability,
+			Details: fmt.Sprintf(
+				"user: %q, until: %q, capacity: %d",
+				user, status.Until.Format(time.RFC3339), status.Capacity,
+			),
+		})
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(response).Encode(status)
+}
+
+func (server *SnobServer) setAvailability(
+	user string, body AvailabilityRequest,
+) error {
+	if body.Until == nil && body.Capacity == nil {
+		return NewError(ErrorBadRequest, "until or capacity is required")
+	}
+
+	if body.Capacity != nil && *body.Capacity < 0 {
+		return NewError(
+			ErrorBadRequest, "invalid capacity %d, expected number",
+			*body.Capacity,
+		)
+	}
+
+	var until time.Time
+
+	if body.Until != nil && strings.TrimSpace(*body.Until) != "" {
+		var err error
+
+		until, err = parseUntil(strings.TrimSpace(*body.Until))
+		if err != nil {
+			return err
+		}
+	}
+
+	if body.Capacity != nil {
+		err := server.availability.SetCapacity(user, *body.Capacity)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case body.Until == nil:
+		return nil
+
+	case until.IsZero():
+		return server.availability.SetAvailable(user)
+	}
+
+	return server.availability.SetUnavailable(user, until)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+)
+
+const (
+	BackendStash     = "stash"
+	BackendGitHub    = "github"
+	BackendGitLab    = "gitlab"
+	BackendGerrit    = "gerrit"
+	BackendBitbucket = "bitbucket"
+)
+
+// Backend is code hosting service where pull requests and groups live.
+// Pull requests are described in Stash terms: project is owner of the
+// repository and reviewers are users requested for review.
+type Backend interface {
+	// ParsePullRequestURL returns project, repository and id of the pull
+	// request given by its web URL.
+	ParsePullRequestURL(url string) (string, string, string, error)
+
+	GetGroupMembers(group string) ([]string, error)
+
+	GetPullRequest(
+		project string, repository string, pullRequest string,
+	) (*ResponsePullRequest, error)
+
+	// GetPullRequestChanges returns paths of files changed by the pull
+	// request.
+	GetPullRequestChanges(
+		project string, repository string, pullRequest string,
+	) ([]string, error)
+
+	// AddReviewers adds users to reviewers of the pull request, author and
+	// service account are never added.
+	AddReviewers(
+		project string, repository string, pullRequest string,
+		info *ResponsePullRequest, users []string,
+	) error
+
+	// RemoveReviewers removes users from reviewers of the pull request.
+	RemoveReviewers(
+		project string, repository string, pullRequest string,
+		info *ResponsePullRequest, users []string,
+	) error
+}
+
+func (server *SnobServer) getBackend() (Backend, error) {
+	switch server.config.Backend {
+	case BackendStash:
+		return &StashBackend{server: server}, nil
+
+	case BackendGitHub:
+		return NewGitHubBackend(
+			server.config.GitHub, server.config.StashTimeout.Duration,
+		), nil
+
+	case BackendGitLab:
+		return NewGitLabBackend(
+			server.config.GitLab, server.config.StashTimeout.Duration,
+		), nil
+
+	case BackendGerrit:
+		return NewGerritBackend(
+			server.config.Gerrit, server.config.StashTimeout.Duration,
+		), nil
+
+	case BackendBitbucket:
+		return NewBitbucketBackend(
+			server.config.Bitbucket, server.config.StashTimeout.Duration,
+		), nil
+	}
+
+	return nil, fmt.Errorf("unknown backend %q", server.config.Backend)
+}
+
+// isStash reports whether pull requests are served by Stash, some features
+// like withdrawn reviewers, commit activity and open reviews are available
+// only there.
+func (server *SnobServer) isStash() bool {
+	_, ok := server.backend.(*StashBackend)
+	return ok
+}
+
+// StashBackend serves pull requests and groups from Stash or Bitbucket
+// Server.
+type StashBackend struct {
+	server *SnobServer
+}
+
+func (backend *StashBackend) ParsePullRequestURL(
+	url string,
+) (string, string, string, error) {
+	return ParsePullRequestURL(url)
+}
+
+func (backend *StashBackend) GetGroupMembers(group string) ([]string, error) {
+	return backend.server.GetStashUsers(group)
+}
+
+func (backend *StashBackend) GetPullRequest(
+	project string, repository string, pullRequest string,
+) (*ResponsePullRequest, error) {
+	return backend.server.getStashPullRequest(project, repository, pullRequest)
+}
+
+func (backend *StashBackend) GetPullRequestChanges(
+	project string, repository string, pullRequest string,
+) ([]string, error) {
+	return backend.server.getStashPullRequestChanges(
+		project, repository, pullRequest,
+	)
+}
+
+func (backend *StashBackend) AddReviewers(
+	project string, repository string, pullRequest string,
+	info *ResponsePullRequest, users []string,
+) error {
+	return backend.server.addStashReviewers(
+		project, repository, pullRequest, info, users,
+	)
+}
+
+func (backend *StashBackend) RemoveReviewers(
+	project string, repository string, pullRequest string,
+	info *ResponsePullRequest, users []string,
+) error {
+	return backend.server.removeStashReviewers(
+		project, repository, pullRequest, info, users,
+	)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// batchLimit is maximum number of pull requests handled by single batch
+// request.
+const batchLimit = 500
+
+// BatchRequest is body of POST /batch, pull requests are given by URLs or
+// by repository and state of its pull requests.
+type BatchRequest struct {
+	Group      string   `json:"group"`
+	URLs       []string `json:"urls"`
+	Project    string   `json:"project"`
+	Repository string   `json:"repository"`
+	State      string   `json:"state"`
+}
+
+type BatchResult struct {
+	URL         string      `json:"url,omitempty"`
+	PullRequest string      `json:"pull_request,omitempty"`
+	Result      APIResponse `json:"result"`
+}
+
+type BatchResponse struct {
+	Success bool          `json:"success"`
+	Results []BatchResult `json:"results"`
+}
+
+// handleBatch serves POST /batch, it assigns reviewers to every given pull
+// request, batch_parallelism of them at once, and reports result of each.
+// Query parameters are the same as for single assignment.
+func (server *SnobServer) handleBatch(
+	response http.ResponseWriter, request *http.Request,
+) {
+	if request.Method != "POST" {
+		server.reportError(
+			response,
+			NewError(ErrorBadRequest, "method %s is not allowed", request.Method),
+			http.StatusMethodNotAllowed,
+		)
+		return
+	}
+
+	request, ok := server.authorize(response, request, OperationReviewers)
+	if !ok {
+		return
+	}
+
+	var body BatchRequest
+
+	err := json.NewDecoder(request.Body).Decode(&body)
+	if err != nil {
+		server.reportError(
+			response,
+			NewError(ErrorBadRequest, "invalid request body: %s", err),
+			http.StatusBadRequest,
+		)
+		return
+	}
+
+	options, err := parseAssignOptions(request)
+	if err != nil {
+		server.reportError(response, err, getErrorStatus(err))
+		return
+	}
+
+	results, err := server.getBatchItems(body)
+	if err != nil {
+		server.reportError(response, err, getErrorStatus(err))
+		return
+	}
+
+	var run errgroup.Group
+	run.SetLimit(server.config.BatchParallel)
+
+	for index := range results {
+		item := &results[index]
+
+		run.Go(func() error {
+			item.Result = server.assignBatchItem(
+				request, body, item, options,
+			)
+
+			return nil
+		})
+	}
+
+	run.Wait()
+
+	batch := BatchResponse{Success: true, Results: results}
+	for _, item := range results {
+		if !item.Result.Success {
+			batch.Success = false
+		}
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(response).Encode(batch)
+}
+
+// getBatchItems returns result placeholder for every pull request of the
+// batch.
+func (server *SnobServer) getBatchItems(body BatchRequest) ([]BatchResult, error) {
+	if body.Group == "" {
+		return nil, NewError(ErrorBadRequest, "group is required")
+	}
+
+	results := []BatchResult{}
+
+	switch {
+	case len(body.URLs) > 0:
+		if body.Project != "" || body.Repository != "" {
+			return nil, NewError(
+				ErrorBadRequest,
+				"either urls or project and repository should be given, "+
+					"not both",
+			)
+		}
+
+		for _, url := range body.URLs {
+			results = append(results, BatchResult{URL: url})
+		}
+
+	case body.Project != "" && body.Repository != "":
+		if !server.isStash() {
+			return nil, NewError(
+				ErrorBadRequest,
+				"listing pull requests is supported only by stash backend",
+			)
+		}
+
+		state := strings.ToUpper(body.State)
+		if state == "" {
+			state = "OPEN"
+		}
+
+		pullRequests, err := server.GetPullRequests(
+			body.Project, body.Repository, state,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pullRequest := range pullRequests {
+			results = append(results, BatchResult{PullRequest: pullRequest})
+		}
+
+	default:
+		return nil, NewError(
+			ErrorBadRequest, "urls or project and repository are required",
+		)
+	}
+
+	if len(results) > batchLimit {
+		return nil, NewError(
+			ErrorBadRequest, "%d pull requests exceed batch limit of %d",
+			len(results), batchLimit,
+		)
+	}
+
+	return results, nil
+}
+
+func (server *SnobServer) assignBatchItem(
+	request *http.Request, body BatchRequest, item *BatchResult,
+	options AssignOptions,
+) APIResponse {
+	var (
+		key        = getRequestAPIKey(request)
+		assignment *Assignment
+		err        error
+	)
+
+	if item.URL != "" {
+		assignment, err = server.AssignReviewers(
+			key, body.Group, item.URL, options,
+		)
+	} else {
+		assignment, err = server.assignPullRequest(
+			key, body.Group, body.Project, body.Repository, item.PullRequest,
+			options,
+		)
+	}
+	if err != nil {
+		return APIResponse{
+			Error: &APIError{Code: getErrorCategory(err), Message: err.Error()},
+		}
+	}
+
+	if assignment.Changed() {
+		entry := assignment.AuditEntry()
+		entry.Details = "batch"
+
+		server.audit(request, entry)
+	}
+
+	_, result := getAssignmentResponse(assignment)
+
+	return result
+}
+
+// GetPullRequests returns ids of pull requests of the repository in given
+// state: OPEN, MERGED, DECLINED or ALL.
+func (server *SnobServer) GetPullRequests(
+	project string, repository string, state string,
+) ([]string, error) {
+	pullRequests, err := server.listPullRequests(project, repository, state)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, pullRequest := range pullRequests {
+		ids = append(ids, strconv.FormatInt(pullRequest.ID, 10))
+	}
+
+	return ids, nil
+}
+
+// listPullRequests returns pull requests of the repository in given state
+// as listed by Stash, including reviewers.
+func (server *SnobServer) listPullRequests(
+	project string, repository string, state string,
+) ([]ResponsePullRequest, error) {
+	result := []ResponsePullRequest{}
+	start := 0
+
+	for {
+		request, err := server.repositoryResource(project, repository).
+			Res("pull-requests", &ResponsePullRequests{}).
+			Get(map[string]string{
+				"state": state,
+				"start": strconv.Itoa(start),
+				"limit": "500",
+			})
+
+		err = checkStashResponse(request, err)
+		if err != nil {
+			return nil, err
+		}
+
+		pullRequests := request.Response.(*ResponsePullRequests)
+		result = append(result, pullRequests.Values...)
+
+		if pullRequests.IsLastPage || len(pullRequests.Values) == 0 {
+			return result, nil
+		}
+
+		start = pullRequests.NextPageStart
+	}
+}
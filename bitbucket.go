@@ -0,0 +1,395 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bndr/gopencils"
+)
+
+const (
+	defaultBitbucketURL = "https://api.bitbucket.org/2.0"
+
+	bitbucketPageSize = 100
+)
+
+var (
+	reBitbucketURL = regexp.MustCompile(
+		`https?://[^/]+/([^/]+)/([^/]+)/pull-requests/(\d+)`,
+	)
+)
+
+type BitbucketConfig struct {
+	URL string `toml:"url"`
+
+	// User and app password are used for basic auth, token is OAuth2
+	// access token, which takes precedence if set.
+	User        string `toml:"user"`
+	AppPassword string `toml:"app_password"`
+	Token       string `toml:"token"`
+}
+
+// BitbucketBackend serves pull requests from Bitbucket Cloud, workspace
+// members are used as groups, so group is workspace slug. Users are
+// identified by nickname.
+type BitbucketBackend struct {
+	api *gopencils.Resource
+
+	// uuids of users are needed to set reviewers
+	mutex sync.Mutex
+	uuids map[string]string
+}
+
+type ResponseBitbucketUser struct {
+	UUID     string `json:"uuid"`
+	Nickname string `json:"nickname"`
+}
+
+type ResponseBitbucketPullRequest struct {
+	State       string                `json:"state"`
+	Title       string                `json:"title"`
+	Description string                `json:"description"`
+	Author      ResponseBitbucketUser `json:"author"`
+	Source      struct {
+		Branch struct {
+			Name string `json:"name"`
+		} `json:"branch"`
+	} `json:"source"`
+	Reviewers    []ResponseBitbucketUser `json:"reviewers"`
+	Participants []struct {
+		User     ResponseBitbucketUser `json:"user"`
+		Role     string                `json:"role"`
+		Approved bool                  `json:"approved"`
+		State    string                `json:"state"`
+	} `json:"participants"`
+	Links struct {
+		HTML ResponseLink `json:"html"`
+	} `json:"links"`
+}
+
+type ResponseBitbucketMembers struct {
+	Values []struct {
+		User ResponseBitbucketUser `json:"user"`
+	} `json:"values"`
+	Next string `json:"next"`
+}
+
+type ResponseBitbucketDiffstat struct {
+	Values []struct {
+		Old *struct {
+			Path string `json:"path"`
+		} `json:"old"`
+		New *struct {
+			Path string `json:"path"`
+		} `json:"new"`
+	} `json:"values"`
+	Next string `json:"next"`
+}
+
+func NewBitbucketBackend(
+	config BitbucketConfig, timeout time.Duration,
+) *BitbucketBackend {
+	url := config.URL
+	if url == "" {
+		url = defaultBitbucketURL
+	}
+
+	client := &http.Client{Timeout: timeout}
+	options := []interface{}{client}
+
+	if config.Token != "" {
+		client.Transport = &headerTransport{
+			header: "Authorization",
+			value:  "Bearer " + config.Token,
+			next:   http.DefaultTransport,
+		}
+	} else {
+		options = append(
+			options, &gopencils.BasicAuth{config.User, config.AppPassword},
+		)
+	}
+
+	return &BitbucketBackend{
+		api:   gopencils.Api(strings.TrimRight(url, "/"), options...),
+		uuids: map[string]string{},
+	}
+}
+
+func (backend *BitbucketBackend) ParsePullRequestURL(
+	url string,
+) (string, string, string, error) {
+	matches := reBitbucketURL.FindStringSubmatch(url)
+	if len(matches) == 0 {
+		return "", "", "", NewError(ErrorBadURL, "wrong url")
+	}
+
+	return matches[1], matches[2], matches[3], nil
+}
+
+func (backend *BitbucketBackend) GetGroupMembers(group string) ([]string, error) {
+	users := []string{}
+
+	for page := 1; ; page++ {
+		request, err := backend.api.Res("workspaces").Res(group).
+			Res("members", &ResponseBitbucketMembers{}).
+			Get(getBitbucketPage(page))
+
+		err = checkBitbucketResponse(request, err)
+		if err != nil {
+			if getErrorCategory(err) == ErrorPullRequestNotFound {
+				return []string{}, NewError(
+					ErrorGroupNotFound, "workspace %q not found", group,
+				)
+			}
+
+			return []string{}, err
+		}
+
+		members := request.Response.(*ResponseBitbucketMembers)
+
+		for _, member := range members.Values {
+			backend.remember(member.User)
+			users = append(users, member.User.Nickname)
+		}
+
+		if members.Next == "" {
+			return users, nil
+		}
+	}
+}
+
+func (backend *BitbucketBackend) GetPullRequest(
+	project string, repository string, pullRequest string,
+) (*ResponsePullRequest, error) {
+	pull, err := backend.getPullRequest(project, repository, pullRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ResponsePullRequest{
+		State:       pull.State,
+		Title:       pull.Title,
+		Description: pull.Description,
+	}
+
+	info.Author.User.Name = pull.Author.Nickname
+	info.FromRef.DisplayID = pull.Source.Branch.Name
+	info.Links.Self = []ResponseLink{pull.Links.HTML}
+
+	approved := map[string]bool{}
+	for _, participant := range pull.Participants {
+		approved[participant.User.Nickname] = participant.Approved
+
+		item := ResponseParticipant{
+			Role:     participant.Role,
+			Approved: participant.Approved,
+			Status:   strings.ToUpper(participant.State),
+		}
+		item.User.Name = participant.User.Nickname
+
+		info.Participants = append(info.Participants, item)
+	}
+
+	for _, reviewer := range pull.Reviewers {
+		participant := ResponseParticipant{
+			Role:     "REVIEWER",
+			Approved: approved[reviewer.Nickname],
+		}
+		participant.User.Name = reviewer.Nickname
+
+		info.Reviewers = append(info.Reviewers, participant)
+	}
+
+	return info, nil
+}
+
+func (backend *BitbucketBackend) GetPullRequestChanges(
+	project string, repository string, pullRequest string,
+) ([]string, error) {
+	paths := []string{}
+
+	for page := 1; ; page++ {
+		request, err := backend.pullRequestsResource(project, repository).
+			Res(pullRequest).Res("diffstat", &ResponseBitbucketDiffstat{}).
+			Get(getBitbucketPage(page))
+
+		err = checkBitbucketResponse(request, err)
+		if err != nil {
+			return nil, err
+		}
+
+		diffstat := request.Response.(*ResponseBitbucketDiffstat)
+
+		for _, value := range diffstat.Values {
+			switch {
+			case value.New != nil:
+				paths = append(paths, value.New.Path)
+			case value.Old != nil:
+				paths = append(paths, value.Old.Path)
+			}
+		}
+
+		if diffstat.Next == "" {
+			return paths, nil
+		}
+	}
+}
+
+func (backend *BitbucketBackend) AddReviewers(
+	project string, repository string, pullRequest string,
+	info *ResponsePullRequest, users []string,
+) error {
+	users = excludeUsers(users, []string{info.Author.User.Name})
+	if len(users) == 0 {
+		return nil
+	}
+
+	return backend.updateReviewers(
+		project, repository, pullRequest,
+		func(current []string) []string {
+			return mergeUsers(current, users)
+		},
+	)
+}
+
+func (backend *BitbucketBackend) RemoveReviewers(
+	project string, repository string, pullRequest string,
+	info *ResponsePullRequest, users []string,
+) error {
+	return backend.updateReviewers(
+		project, repository, pullRequest,
+		func(current []string) []string {
+			return excludeUsers(current, users)
+		},
+	)
+}
+
+// updateReviewers updates pull request with the whole list of reviewers,
+// which is read right before update, so concurrent changes are not lost.
+func (backend *BitbucketBackend) updateReviewers(
+	project string, repository string, pullRequest string,
+	update func([]string) []string,
+) error {
+	pull, err := backend.getPullRequest(project, repository, pullRequest)
+	if err != nil {
+		return err
+	}
+
+	current := []string{}
+	for _, reviewer := range pull.Reviewers {
+		backend.remember(reviewer)
+		current = append(current, reviewer.Nickname)
+	}
+
+	reviewers := []map[string]string{}
+	for _, user := range update(current) {
+		backend.mutex.Lock()
+		uuid, ok := backend.uuids[user]
+		backend.mutex.Unlock()
+
+		if !ok {
+			return NewError(
+				ErrorBadRequest,
+				"bitbucket user %q is not a member of known workspace", user,
+			)
+		}
+
+		reviewers = append(reviewers, map[string]string{"uuid": uuid})
+	}
+
+	request, err := backend.pullRequestsResource(project, repository).
+		Res(pullRequest, &map[string]interface{}{}).
+		Put(map[string]interface{}{
+			"title":     pull.Title,
+			"reviewers": reviewers,
+		})
+
+	return checkBitbucketResponse(request, err)
+}
+
+func (backend *BitbucketBackend) getPullRequest(
+	project string, repository string, pullRequest string,
+) (*ResponseBitbucketPullRequest, error) {
+	request, err := backend.pullRequestsResource(project, repository).
+		Res(pullRequest, &ResponseBitbucketPullRequest{}).
+		Get()
+
+	err = checkBitbucketResponse(request, err)
+	if err != nil {
+		return nil, err
+	}
+
+	pull := request.Response.(*ResponseBitbucketPullRequest)
+
+	backend.remember(pull.Author)
+
+	return pull, nil
+}
+
+func (backend *BitbucketBackend) remember(user ResponseBitbucketUser) {
+	if user.Nickname == "" || user.UUID == "" {
+		return
+	}
+
+	backend.mutex.Lock()
+	backend.uuids[user.Nickname] = user.UUID
+	backend.mutex.Unlock()
+}
+
+func (backend *BitbucketBackend) pullRequestsResource(
+	project string, repository string,
+) *gopencils.Resource {
+	return backend.api.Res("repositories").Res(project).Res(repository).
+		Res("pullrequests")
+}
+
+func getBitbucketPage(page int) map[string]string {
+	return map[string]string{
+		"pagelen": strconv.Itoa(bitbucketPageSize),
+		"page":    strconv.Itoa(page),
+	}
+}
+
+func checkBitbucketResponse(resource *gopencils.Resource, err error) error {
+	if resource == nil || resource.Raw == nil || resource.Raw.StatusCode < 400 {
+		return err
+	}
+
+	defer resource.Raw.Body.Close()
+
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	bitbucketError := &StashError{
+		Service:    "bitbucket",
+		StatusCode: resource.Raw.StatusCode,
+		Status:     resource.Raw.Status,
+	}
+
+	if json.NewDecoder(resource.Raw.Body).Decode(&body) == nil &&
+		body.Error.Message != "" {
+		bitbucketError.Messages = []string{body.Error.Message}
+	}
+
+	return bitbucketError
+}
+
+func validateBitbucket(config BitbucketConfig) error {
+	if config.Token == "" && (config.User == "" || config.AppPassword == "") {
+		return fmt.Errorf(
+			"bitbucket.token or bitbucket.user and bitbucket.app_password " +
+				"are required for bitbucket backend",
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const SignalTouches = "touches"
+
+type BlameConfig struct {
+	Window Duration `toml:"window"`
+
+	// MaxFiles limits number of changed files inspected, one Stash
+	// request is made per file.
+	MaxFiles int `toml:"max_files"`
+}
+
+// BlameStrategy prefers candidates who recently committed to files changed
+// by the pull request, candidates without such commits complete the
+// selection in random order, so the configured group is used as is when
+// there is no history at all.
+type BlameStrategy struct {
+	Window   time.Duration
+	MaxFiles int
+}
+
+func (strategy BlameStrategy) Select(
+	selection *Selection, users []string, count int,
+) ([]string, error) {
+	var (
+		server  = selection.server
+		since   = time.Now().Add(-strategy.Window)
+		touches = map[string]int{}
+	)
+
+	changes, err := selection.GetChanges()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(changes) > strategy.MaxFiles {
+		changes = changes[:strategy.MaxFiles]
+	}
+
+	for _, path := range changes {
+		authors, err := server.GetCommitAuthors(
+			selection.Project, selection.Repository, path, since,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, user := range users {
+			touches[user] += authors[normalizeUser(user)]
+		}
+	}
+
+	ranked := append([]string{}, users...)
+	rand.Shuffle(len(ranked), func(i, j int) {
+		ranked[i], ranked[j] = ranked[j], ranked[i]
+	})
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return touches[ranked[i]] > touches[ranked[j]]
+	})
+
+	for _, user := range ranked {
+		selection.Scores[user] = float64(touches[user])
+		selection.Signals[user] = map[string]float64{
+			SignalTouches: float64(touches[user]),
+		}
+
+		logger.Debugf(
+			"[blame] %s: %d commits to changed files", user, touches[user],
+		)
+	}
+
+	return selectRankedUsers(ranked, count, selection.Required), nil
+}
+
+// isStashStrategy reports whether strategy needs Stash specific APIs.
+func isStashStrategy(name string) bool {
+	switch name {
+	case StrategyScore, StrategyWorkload, StrategyBlame:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type CachedGroup struct {
+	Users   []string  `json:"users"`
+	Updated time.Time `json:"updated"`
+}
+
+// GroupCache keeps members of groups, groups which are older than TTL are
+// still served but refreshed in background, so requests never wait for
+// Stash once group is cached. Zero TTL means that groups never expire.
+// Concurrent fetches of the same group are collapsed into single call.
+type GroupCache struct {
+	mutex  sync.RWMutex
+	ttl    time.Duration
+	groups map[string]CachedGroup
+
+	// changed wakes up refresher when TTL is changed by reload.
+	changed chan struct{}
+
+	fetchMutex sync.Mutex
+	fetches    map[string]*groupFetch
+}
+
+// groupFetch is fetch of group in progress, other callers wait for it
+// instead of fetching the same group again.
+type groupFetch struct {
+	done  chan struct{}
+	users []string
+	err   error
+}
+
+type GroupSnapshot struct {
+	Created time.Time              `json:"created"`
+	Groups  map[string]CachedGroup `json:"groups"`
+}
+
+func NewGroupCache() *GroupCache {
+	return &GroupCache{
+		groups:  map[string]CachedGroup{},
+		fetches: map[string]*groupFetch{},
+		changed: make(chan struct{}, 1),
+	}
+}
+
+func (cache *GroupCache) Get(group string) ([]string, bool) {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	cached, ok := cache.groups[group]
+
+	return cached.Users, ok
+}
+
+func (cache *GroupCache) Set(group string, users []string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.groups[group] = CachedGroup{
+		Users:   users,
+		Updated: time.Now(),
+	}
+}
+
+func (cache *GroupCache) IsStale(group string, now time.Time) bool {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	return cache.isStale(group, now)
+}
+
+func (cache *GroupCache) isStale(group string, now time.Time) bool {
+	cached, ok := cache.groups[group]
+
+	return ok && cache.ttl > 0 && now.Sub(cached.Updated) >= cache.ttl
+}
+
+func (cache *GroupCache) SetTTL(ttl time.Duration) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if cache.ttl == ttl {
+		return
+	}
+
+	cache.ttl = ttl
+
+	select {
+	case cache.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (cache *GroupCache) TTL() time.Duration {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	return cache.ttl
+}
+
+// Stale returns groups which are older than TTL.
+func (cache *GroupCache) Stale(now time.Time) []string {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	stale := []string{}
+	for group := range cache.groups {
+		if cache.isStale(group, now) {
+			stale = append(stale, group)
+		}
+	}
+
+	return stale
+}
+
+// Fetch calls fetch for the group and caches non-empty result, callers
+// which ask for the same group while fetch is in progress get its result.
+func (cache *GroupCache) Fetch(
+	group string, fetch func(string) ([]string, error),
+) ([]string, error) {
+	cache.fetchMutex.Lock()
+	if call, ok := cache.fetches[group]; ok {
+		cache.fetchMutex.Unlock()
+
+		<-call.done
+
+		return call.users, call.err
+	}
+
+	call := &groupFetch{done: make(chan struct{})}
+	cache.fetches[group] = call
+	cache.fetchMutex.Unlock()
+
+	call.users, call.err = fetch(group)
+	if call.err == nil && len(call.users) > 0 {
+		cache.Set(group, call.users)
+	}
+
+	cache.fetchMutex.Lock()
+	delete(cache.fetches, group)
+	cache.fetchMutex.Unlock()
+
+	close(call.done)
+
+	return call.users, call.err
+}
+
+// Invalidate drops group from cache and returns false if it was not cached.
+func (cache *GroupCache) Invalidate(group string) bool {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	_, ok := cache.groups[group]
+	delete(cache.groups, group)
+
+	return ok
+}
+
+// Clear drops all groups and returns their number.
+func (cache *GroupCache) Clear() int {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	count := len(cache.groups)
+	cache.groups = map[string]CachedGroup{}
+
+	return count
+}
+
+func (cache *GroupCache) Snapshot() GroupSnapshot {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	snapshot := GroupSnapshot{
+		Created: time.Now(),
+		Groups:  map[string]CachedGroup{},
+	}
+
+	for group, cached := range cache.groups {
+		snapshot.Groups[group] = cached
+	}
+
+	return snapshot
+}
+
+// Load puts groups from snapshot into cache, existing groups are dropped
+// if replace is set.
+func (cache *GroupCache) Load(snapshot GroupSnapshot, replace bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if replace {
+		cache.groups = map[string]CachedGroup{}
+	}
+
+	for group, cached := range snapshot.Groups {
+		cache.groups[group] = cached
+	}
+}
+
+func (server *SnobServer) handleSnapshot(
+	response http.ResponseWriter, request *http.Request,
+) {
+	var ok bool
+
+	switch request.Method {
+	case "GET":
+		request, ok = server.authorize(response, request, OperationGroups)
+		if !ok {
+			return
+		}
+
+		response.Header().Set("Content-Type", "application/json")
+		response.Header().Set(
+			"Content-Disposition", `attachment; filename="snobs-groups.json"`,
+		)
+
+		err := json.NewEncoder(response).Encode(server.cache.Snapshot())
+		if err != nil {
+			server.reportError(response, err, http.StatusInternalServerError)
+		}
+
+	case "POST":
+		request, ok = server.authorize(response, request, OperationCache)
+		if !ok {
+			return
+		}
+
+		var snapshot GroupSnapshot
+
+		err := json.NewDecoder(request.Body).Decode(&snapshot)
+		if err != nil {
+			server.reportError(
+				response,
+				NewError(ErrorBadRequest, "invalid snapshot: %s", err),
+				http.StatusBadRequest,
+			)
+			return
+		}
+
+		replace := request.URL.Query().Get("replace") == "1"
+
+		server.cache.Load(snapshot, replace)
+
+		server.audit(request, AuditEntry{
+			Action: AuditImportSnapshot,
+			Details: fmt.Sprintf(
+				"%d groups, replace: %t", len(snapshot.Groups), replace,
+			),
+		})
+
+		writeResponse(response, http.StatusOK, APIResponse{Success: true})
+
+	default:
+		server.reportError(
+			response,
+			NewError(ErrorBadRequest, "method %s is not allowed", request.Method),
+			http.StatusMethodNotAllowed,
+		)
+	}
+}
+
+// getCachedUsers returns members of the group from cache, group is fetched
+// if it's not cached yet and refreshed in background if it's stale.
+func (server *SnobServer) getCachedUsers(group string) ([]string, error) {
+	users, ok := server.cache.Get(group)
+	if !ok {
+		return server.cache.Fetch(group, server.GetUsers)
+	}
+
+	if server.cache.IsStale(group, time.Now()) {
+		go server.refreshGroup(group)
+	}
+
+	return users, nil
+}
+
+// refreshGroup fetches group from Stash and updates cache, stale members
+// are kept if Stash is not available.
+func (server *SnobServer) refreshGroup(group string) {
+	defer server.hold()()
+
+	_, err := server.cache.Fetch(group, server.GetUsers)
+	if err != nil {
+		logger.Errorf("can't refresh cached group %s: %s", group, err)
+	}
+}
+
+// RunCacheRefresh refreshes stale groups in background until process
+// exits, it waits while cache TTL is not set and follows TTL changed by
+// reload.
+func (server *SnobServer) RunCacheRefresh() {
+	for {
+		ttl := server.cache.TTL()
+		if ttl <= 0 {
+			<-server.cache.changed
+			continue
+		}
+
+		interval := ttl / 2
+		if interval < time.Second {
+			interval = time.Second
+		}
+
+		select {
+		case <-server.cache.changed:
+			continue
+
+		case <-time.After(interval):
+		}
+
+		for _, group := range server.cache.Stale(time.Now()) {
+			server.refreshGroup(group)
+		}
+	}
+}
+
+// handleInvalidateCache serves DELETE /cache, which drops every cached
+// group, and DELETE /cache/<group>.
+func (server *SnobServer) handleInvalidateCache(
+	response http.ResponseWriter, request *http.Request,
+) {
+	request, ok := server.authorize(response, request, OperationCache)
+	if !ok {
+		return
+	}
+
+	group := strings.TrimPrefix(
+		strings.TrimPrefix(request.URL.Path, "/cache"), "/",
+	)
+
+	var count int
+	if group == "" {
+		count = server.cache.Clear()
+
+		logger.Infof("cache cleared, %d groups dropped", count)
+	} else {
+		if server.cache.Invalidate(group) {
+			count = 1
+		}
+
+		logger.Infof("cached group %s invalidated", group)
+	}
+
+	server.audit(request, AuditEntry{
+		Action:  AuditInvalidate,
+		Group:   group,
+		Details: fmt.Sprintf("%d groups dropped", count),
+	})
+
+	writeResponse(response, http.StatusOK, APIResponse{
+		Success: true,
+		Dropped: count,
+		Changed: count > 0,
+	})
+}
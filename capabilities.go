@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+var apiVersions = []string{"v1", "v2"}
+
+var strategies = []string{
+	StrategyRandom, StrategyScore, StrategyExternal, StrategyRoundRobin,
+	StrategyWorkload,
+	StrategyBlame,
+	StrategyTiered,
+	StrategyFair,
+}
+
+type Capabilities struct {
+	Version     string          `json:"version"`
+	APIVersions []string        `json:"api_versions"`
+	Providers   []string        `json:"providers"`
+	Strategies  []string        `json:"strategies"`
+	Strategy    string          `json:"strategy"`
+	Features    map[string]bool `json:"features"`
+	Roles       []string        `json:"roles"`
+}
+
+// GetCapabilities describes what this instance is configured to do, so
+// clients can adapt to it instead of probing endpoints.
+func (server *SnobServer) GetCapabilities() Capabilities {
+	_, redis := server.limiter.buckets.(*redisBuckets)
+	config := server.config
+
+	roles := []string{}
+	for role := range roleOperations {
+		roles = append(roles, role)
+	}
+
+	sort.Strings(roles)
+
+	return Capabilities{
+		Version:     version,
+		APIVersions: apiVersions,
+		Providers:   []string{config.Backend},
+		Strategies:  strategies,
+		Strategy:    config.Strategy,
+		Roles:       roles,
+		Features: map[string]bool{
+			"api_keys":          len(server.keys) > 0,
+			"oidc":              server.oidc != nil,
+			"jira":              server.jira != nil,
+			"org_chart":         server.org != nil,
+			"experts":           len(server.experts) > 0,
+			"static_groups":     len(server.staticGroups) > 0,
+			"directives":        true,
+			"optout":            true,
+			"snapshots":         true,
+			"chunked_reviewers": config.ReviewersChunkSize > 0,
+			"reviewers_limit":   config.ReviewersLimit > 0,
+			"rate_limit_stash":  server.limiter.stash != nil,
+			"rate_limit_groups": server.limiter.groups != nil,
+			"rate_limit_client": server.limiter.clients != nil,
+			"rate_limit_redis":  redis,
+			"repository_acl": len(config.AllowRepositories) > 0 ||
+				len(config.DenyRepositories) > 0,
+			"digest":       config.Digest != nil,
+			"history":      config.HistoryFile != "",
+			"availability": config.AvailabilityFile != "",
+			"audit":        config.AuditFile != "",
+			"pushgateway":  config.Pushgateway.URL != "",
+			"outbox":       config.Outbox.File != "",
+			"async_jobs":   true,
+			"tenants":      len(server.tenants) > 0,
+			"instances":    len(server.instances) > 0,
+			"maintenance":  len(config.Maintenance.Windows) > 0,
+			"tls":          config.TLSCert != "",
+			"mtls":         config.ClientCA != "",
+			"dry_run":      config.DryRun,
+			"stats":        config.HistoryFile != "",
+		},
+	}
+}
+
+func (server *SnobServer) handleCapabilities(
+	response http.ResponseWriter, request *http.Request,
+) {
+	request, ok := server.authorize(response, request, OperationGroups)
+	if !ok {
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(response).Encode(server.GetCapabilities())
+	if err != nil {
+		server.reportError(response, err, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// runAdd adds reviewers to single pull request without starting server,
+// for use from CI jobs and scripts.
+func runAdd(config *Config, pullRequestURL string, group string) error {
+	started := time.Now()
+
+	server, err := NewSnobServer(config)
+	if err != nil {
+		return err
+	}
+
+	run := RunMetrics{Outcome: OutcomeError}
+
+	assignment, err := server.AssignReviewers(
+		nil, group, pullRequestURL, AssignOptions{},
+	)
+	if err != nil {
+		server.metrics.Errors.Inc(getErrorCategory(err))
+	} else {
+		switch {
+		case assignment.Skipped:
+			run.Outcome = OutcomeSkipped
+
+			fmt.Println("no reviewers added")
+
+		case assignment.Queued:
+			run.Outcome = OutcomeQueued
+
+			fmt.Println("queued until maintenance ends")
+
+		case assignment.DryRun:
+			run.Outcome = OutcomeDryRun
+
+			fmt.Println("dry run, reviewers are not added:")
+			fmt.Println(strings.Join(assignment.Reviewers, "\n"))
+
+		default:
+			run.Outcome = OutcomeSuccess
+			run.Reviewers = len(assignment.Reviewers)
+
+			server.audit(nil, assignment.AuditEntry())
+
+			fmt.Println(strings.Join(assignment.Reviewers, "\n"))
+		}
+	}
+
+	// there is no outbox worker in this process, notifications which can't
+	// be delivered now are left in outbox file for daemon
+	server.outbox.Flush()
+
+	run.Finished = time.Now()
+	run.Duration = run.Finished.Sub(started)
+
+	pushErr := pushRunMetrics(server.config.Pushgateway, server.metrics, run)
+	if pushErr != nil {
+		logger.Errorf("can't push metrics: %s", pushErr)
+	}
+
+	return err
+}
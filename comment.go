@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// TemplateData is passed to comment and notification templates, Mentions
+// is the list of reviewers as @name, ready to be put into text as is.
+type TemplateData struct {
+	Project     string
+	Repository  string
+	PullRequest string
+	Title       string
+	URL         string
+	Author      string
+	Group       string
+	Reviewers   []string
+	Mentions    string
+}
+
+func parseTemplate(name string, text string) (*template.Template, error) {
+	return template.New(name).
+		Funcs(template.FuncMap{"join": strings.Join}).
+		Parse(text)
+}
+
+// renderTemplate executes template with data of the assignment.
+func renderTemplate(
+	name string, text string, assignment *Assignment,
+) (string, error) {
+	parsed, err := parseTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+
+	mentions := []string{}
+	for _, reviewer := range assignment.Reviewers {
+		mentions = append(mentions, "@"+reviewer)
+	}
+
+	buffer := &bytes.Buffer{}
+
+	err = parsed.Execute(buffer, TemplateData{
+		Project:     assignment.Project,
+		Repository:  assignment.Repository,
+		PullRequest: assignment.PullRequest,
+		Title:       assignment.Info.Title,
+		URL:         assignment.Info.GetURL(),
+		Author:      assignment.Info.Author.User.Name,
+		Group:       assignment.Group,
+		Reviewers:   assignment.Reviewers,
+		Mentions:    strings.Join(mentions, " "),
+	})
+	if err != nil {
+		return "", fmt.Errorf("can't render %s: %s", name, err)
+	}
+
+	return buffer.String(), nil
+}
+
+func validateComments(config *Config) ConfigErrors {
+	errs := ConfigErrors{}
+
+	names := []string{"comment"}
+	templates := map[string]string{"comment": config.Comment}
+
+	patterns := []string{}
+	for pattern := range config.Rules {
+		patterns = append(patterns, pattern)
+	}
+
+	sort.Strings(patterns)
+
+	for _, pattern := range patterns {
+		name := fmt.Sprintf("rules.%q.comment", pattern)
+
+		names = append(names, name)
+		templates[name] = config.Rules[pattern].Comment
+	}
+
+	for _, name := range names {
+		text := templates[name]
+		if text == "" {
+			continue
+		}
+
+		if config.Backend != BackendStash {
+			errs = append(errs, fmt.Sprintf(
+				"%s is supported only by stash backend", name,
+			))
+			continue
+		}
+
+		_, err := parseTemplate(name, text)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+
+	return errs
+}
+
+// getCommentTemplate returns comment template of matching rule or global
+// one, empty string means no comment is posted.
+func (server *SnobServer) getCommentTemplate(
+	project string, repository string,
+) string {
+	_, rule := server.getRule(project, repository)
+	if rule.Comment != "" {
+		return rule.Comment
+	}
+
+	return server.config.Comment
+}
+
+// commentAssignment posts comment about added reviewers to the pull
+// request if comment template is configured for the repository.
+func (server *SnobServer) commentAssignment(assignment *Assignment) error {
+	text := server.getCommentTemplate(assignment.Project, assignment.Repository)
+	if text == "" {
+		return nil
+	}
+
+	comment, err := renderTemplate("comment", text, assignment)
+	if err != nil {
+		return err
+	}
+
+	return server.AddComment(
+		assignment.Project, assignment.Repository, assignment.PullRequest,
+		comment,
+	)
+}
+
+func (server *SnobServer) AddComment(
+	project string, repository string, pullRequest string, text string,
+) error {
+	request, err := server.repositoryResource(project, repository).
+		Res("pull-requests").Res(pullRequest).
+		Res("comments", &map[string]interface{}{}).
+		Post(map[string]interface{}{"text": text})
+
+	return checkStashResponse(request, err)
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+type Config struct {
+	Listen               string   `toml:"listen"`
+	TLSCert              string   `toml:"tls_cert"`
+	TLSKey               string   `toml:"tls_key"`
+	ClientCA             string   `toml:"client_ca"`
+	Backend              string   `toml:"backend"`
+	Stash                string   `toml:"stash"`
+	User                 string   `toml:"user"`
+	Pass                 string   `toml:"pass"`
+	Token                string   `toml:"token"`
+	LogLevel             string   `toml:"log_level"`
+	LogFormat            string   `toml:"log_format"`
+	Intersect            []string `toml:"intersect"`
+	Union                []string `toml:"union"`
+	ExcludeGroups        []string `toml:"exclude_groups"`
+	ExcludeUsers         []string `toml:"exclude_users"`
+	StashTimeout         Duration `toml:"stash_timeout"`
+	StashCAFile          string   `toml:"stash_ca_file"`
+	StashInsecure        bool     `toml:"stash_insecure_skip_verify"`
+	CacheTTL             Duration `toml:"cache_ttl"`
+	ShutdownTimeout      Duration `toml:"shutdown_timeout"`
+	HistoryFile          string   `toml:"history_file"`
+	HistoryRetention     Duration `toml:"history_retention"`
+	AvailabilityFile     string   `toml:"availability_file"`
+	RotationFile         string   `toml:"rotation_file"`
+	AuditFile            string   `toml:"audit_file"`
+	AuditKey             string   `toml:"audit_key"`
+	GroupsFile           string   `toml:"groups_file"`
+	Strategy             string   `toml:"strategy"`
+	MaxReviewers         int      `toml:"max_reviewers"`
+	ReviewersChunkSize   int      `toml:"reviewers_chunk_size"`
+	GroupFetchParallel   int      `toml:"group_fetch_parallelism"`
+	ConflictRetries      int      `toml:"version_conflict_retries"`
+	BatchParallel        int      `toml:"batch_parallelism"`
+	DryRun               bool     `toml:"dry_run"`
+	ReviewersLimit       int      `toml:"reviewers_limit"`
+	ReviewersLimitAction string   `toml:"reviewers_limit_action"`
+	OnEmpty              string   `toml:"on_empty"`
+	DefaultGroup         string   `toml:"default_group"`
+	AllowRepositories    []string `toml:"allow_repositories"`
+	DenyRepositories     []string `toml:"deny_repositories"`
+	AllowedCIDRs         []string `toml:"allowed_cidrs"`
+	TrustProxy           bool     `toml:"trust_proxy"`
+	CheckPermission      bool     `toml:"check_permission"`
+	Comment              string   `toml:"comment"`
+
+	Keys        map[string]KeyConfig    `toml:"keys"`
+	RateLimit   RateLimitConfig         `toml:"ratelimit"`
+	Jira        JiraConfig              `toml:"jira"`
+	SMTP        SMTPConfig              `toml:"smtp"`
+	Digest      *DigestConfig           `toml:"digest"`
+	LDAP        LDAPConfig              `toml:"ldap"`
+	Org         OrgConfig               `toml:"org"`
+	Experts     map[string]ExpertConfig `toml:"experts"`
+	Routes      []RouteConfig           `toml:"routes"`
+	Scoring     ScoringConfig           `toml:"scoring"`
+	Workload    WorkloadConfig          `toml:"workload"`
+	Timezones   TimezoneConfig          `toml:"timezones"`
+	Fairness    FairnessConfig          `toml:"fairness"`
+	Stats       StatsConfig             `toml:"stats"`
+	Blame       BlameConfig             `toml:"blame"`
+	External    ExternalConfig          `toml:"external"`
+	OIDC        OIDCConfig              `toml:"oidc"`
+	Pushgateway PushgatewayConfig       `toml:"pushgateway"`
+	StatsD      StatsDConfig            `toml:"statsd"`
+	Events      EventsConfig            `toml:"events"`
+	Outbox      OutboxConfig            `toml:"outbox"`
+	Jobs        JobsConfig              `toml:"jobs"`
+	Sweep       SweepConfig             `toml:"sweep"`
+	Notify      NotifyConfig            `toml:"notify"`
+	Maintenance MaintenanceConfig       `toml:"maintenance"`
+	Webhook     WebhookConfig           `toml:"webhook"`
+	GitHub      GitHubConfig            `toml:"github"`
+	GitLab      GitLabConfig            `toml:"gitlab"`
+	Gerrit      GerritConfig            `toml:"gerrit"`
+	Bitbucket   BitbucketConfig         `toml:"bitbucket"`
+
+	// Availability pauses assignments of users until given time.
+	Availability AvailabilityConfig `toml:"availability"`
+
+	// NeverTogether lists sets of users, no two of which are assigned to
+	// the same pull request.
+	NeverTogether [][]string `toml:"never_together"`
+
+	// Seniors lists senior members per group for tiered strategy.
+	Seniors map[string][]string `toml:"seniors"`
+
+	// DefaultReviewers syncs candidates into default reviewers settings.
+	DefaultReviewers DefaultReviewersConfig `toml:"default_reviewers"`
+
+	// Rules override policy per repository, keyed by PROJECT/repo glob.
+	Rules map[string]RuleConfig `toml:"rules"`
+
+	// Instances are additional Stash servers, pull requests are routed to
+	// them by host of URL.
+	Instances map[string]InstanceConfig `toml:"instances"`
+
+	// Tenants maps tenant name to its configuration file.
+	Tenants map[string]string `toml:"tenants"`
+
+	tenants map[string]*Config
+}
+
+// Duration is time.Duration written as string like "30s" in config.
+type Duration struct {
+	time.Duration
+}
+
+func (duration *Duration) UnmarshalText(text []byte) error {
+	var err error
+
+	duration.Duration, err = time.ParseDuration(string(text))
+
+	return err
+}
+
+func (duration Duration) MarshalText() ([]byte, error) {
+	return []byte(duration.String()), nil
+}
+
+// ConfigErrors lists every problem found in configuration, so all of them
+// can be fixed at once.
+type ConfigErrors []string
+
+func (errs ConfigErrors) Error() string {
+	return "invalid configuration:\n    " + strings.Join(errs, "\n    ")
+}
+
+func getDefaultConfig() *Config {
+	return &Config{
+		Backend:              BackendStash,
+		LogLevel:             LogLevelInfo,
+		LogFormat:            LogFormatText,
+		StashTimeout:         Duration{30 * time.Second},
+		ShutdownTimeout:      Duration{30 * time.Second},
+		HistoryRetention:     Duration{90 * 24 * time.Hour},
+		GroupFetchParallel:   4,
+		ConflictRetries:      3,
+		BatchParallel:        4,
+		Strategy:             StrategyRandom,
+		ReviewersLimitAction: "fail",
+		OnEmpty:              EmptyError,
+		CheckPermission:      true,
+		RateLimit: RateLimitConfig{
+			RedisPrefix: "snobs",
+		},
+		LDAP: LDAPConfig{
+			UserFilter:       "(uid=%s)",
+			UserAttribute:    "uid",
+			ManagerAttribute: "manager",
+		},
+		Scoring: ScoringConfig{
+			Activity:    1,
+			Load:        -1,
+			Assignments: -1,
+			Ownership:   2,
+			Window:      Duration{30 * 24 * time.Hour},
+		},
+		Workload: WorkloadConfig{
+			Window: Duration{30 * 24 * time.Hour},
+		},
+		Stats: StatsConfig{
+			Windows: []Duration{
+				{24 * time.Hour}, {7 * 24 * time.Hour}, {30 * 24 * time.Hour},
+			},
+		},
+		Fairness: FairnessConfig{
+			Recent: 5,
+		},
+		Timezones: TimezoneConfig{
+			WorkStart: 9,
+			WorkEnd:   18,
+		},
+		Blame: BlameConfig{
+			Window:   Duration{90 * 24 * time.Hour},
+			MaxFiles: scoringMaxFiles,
+		},
+		External: ExternalConfig{
+			Timeout:  Duration{5 * time.Second},
+			Fallback: StrategyRandom,
+		},
+		OIDC: OIDCConfig{
+			Scopes:      []string{"openid", "profile", "groups"},
+			UserClaim:   "preferred_username",
+			GroupsClaim: "groups",
+			SessionTTL:  Duration{8 * time.Hour},
+		},
+		Pushgateway: PushgatewayConfig{
+			Job:     "snobs",
+			Timeout: Duration{10 * time.Second},
+		},
+		StatsD: StatsDConfig{
+			Prefix: "snobs.",
+		},
+		Outbox: OutboxConfig{
+			MaxAttempts: 10,
+			Backoff:     Duration{30 * time.Second},
+			MaxBackoff:  Duration{time.Hour},
+			MaxDead:     1000,
+		},
+		Jobs: JobsConfig{
+			Workers:    4,
+			Retention:  Duration{24 * time.Hour},
+			Backoff:    Duration{30 * time.Second},
+			MaxBackoff: Duration{15 * time.Minute},
+			MaxAge:     Duration{24 * time.Hour},
+		},
+	}
+}
+
+// getConfig decodes config file over defaults, keys which are not known
+// are reported together with validation errors.
+func getConfig(path string) (*Config, error) {
+	config := getDefaultConfig()
+
+	metadata, err := toml.DecodeFile(path, config)
+	if err != nil {
+		return nil, err
+	}
+
+	errs := ConfigErrors{}
+	for _, key := range metadata.Undecoded() {
+		errs = append(errs, fmt.Sprintf("unknown key %s", key))
+	}
+
+	errs = append(errs, config.Validate()...)
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	config.tenants, err = getTenantConfigs(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// Validate checks values which can't be checked by decoding, it returns
+// nil if configuration is valid.
+func (config *Config) Validate() ConfigErrors {
+	errs := ConfigErrors{}
+
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	for _, required := range []struct {
+		key   string
+		value string
+	}{
+		{"listen", config.Listen},
+		{"stash", config.Stash},
+		{"user", config.User},
+		{"pass", config.Pass},
+	} {
+		if required.key != "listen" && config.Backend != BackendStash {
+			continue
+		}
+
+		// personal access token replaces password
+		if required.key == "pass" && config.Token != "" {
+			continue
+		}
+
+		if required.value == "" {
+			errs = append(errs, fmt.Sprintf("%s is required", required.key))
+		}
+	}
+
+	switch config.Backend {
+	case BackendStash:
+
+	case BackendGitHub:
+		check(validateGitHub(config.GitHub))
+
+	case BackendGitLab:
+		check(validateGitLab(config.GitLab))
+
+	case BackendGerrit:
+		check(validateGerrit(config.Gerrit))
+
+	case BackendBitbucket:
+		check(validateBitbucket(config.Bitbucket))
+
+	default:
+		errs = append(errs, fmt.Sprintf("unknown backend %q", config.Backend))
+	}
+
+	if config.Backend != BackendStash && isStashStrategy(config.Strategy) {
+		errs = append(errs, fmt.Sprintf(
+			"%s strategy is supported only by stash backend", config.Strategy,
+		))
+	}
+
+	if len(config.Intersect) == 0 && len(config.Union) == 0 {
+		errs = append(errs, "intersect or union is required")
+	}
+
+	if config.StashTimeout.Duration <= 0 {
+		errs = append(errs, "stash_timeout should be positive")
+	}
+
+	if config.ShutdownTimeout.Duration <= 0 {
+		errs = append(errs, "shutdown_timeout should be positive")
+	}
+
+	if config.GroupFetchParallel <= 0 {
+		errs = append(errs, "group_fetch_parallelism should be positive")
+	}
+
+	if config.BatchParallel <= 0 {
+		errs = append(errs, "batch_parallelism should be positive")
+	}
+
+	if config.ConflictRetries < 0 {
+		errs = append(errs, "version_conflict_retries should not be negative")
+	}
+
+	if config.CacheTTL.Duration < 0 {
+		errs = append(errs, "cache_ttl should not be negative")
+	}
+
+	check(validateTLS(config))
+
+	check(validateLogging(config))
+
+	_, err := getStashURL(config.Stash)
+	check(err)
+
+	_, err = getStashTransport(config)
+	check(err)
+
+	check(validateInstances(config))
+
+	_, err = getAllowedNetworks(config.AllowedCIDRs)
+	check(err)
+
+	err = validateRepositoryPatterns(config.AllowRepositories)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("allow_repositories: %s", err))
+	}
+
+	err = validateRepositoryPatterns(config.DenyRepositories)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("deny_repositories: %s", err))
+	}
+
+	if config.ReviewersLimitAction != "fail" &&
+		config.ReviewersLimitAction != "truncate" {
+		errs = append(errs, fmt.Sprintf(
+			"reviewers_limit_action should be fail or truncate, got %q",
+			config.ReviewersLimitAction,
+		))
+	}
+
+	switch config.OnEmpty {
+	case EmptyError, EmptySkip, EmptyRawGroup:
+
+	case EmptyDefaultGroup:
+		if config.DefaultGroup == "" {
+			errs = append(errs, "default_group is required for on_empty")
+		}
+
+	default:
+		errs = append(errs, fmt.Sprintf(
+			"unknown on_empty action %q", config.OnEmpty,
+		))
+	}
+
+	_, err = getAPIKeys(config.Keys)
+	check(err)
+
+	_, err = getRateLimit("ratelimit.stash", config.RateLimit.Stash)
+	check(err)
+
+	_, err = getRateLimit("ratelimit.groups", config.RateLimit.Groups)
+	check(err)
+
+	_, err = getRateLimit("ratelimit.clients", config.RateLimit.Clients)
+	check(err)
+
+	_, err = getDigestSchedule(config.Digest)
+	check(err)
+
+	_, err = getExpertRules(config.Experts)
+	check(err)
+
+	_, err = getPathRoutes(config.Routes)
+	check(err)
+
+	_, err = getStrategy(config, config.Strategy)
+	check(err)
+
+	errs = append(errs, validateRules(config)...)
+
+	errs = append(errs, validateComments(config)...)
+
+	errs = append(errs, validateNotify(config.Notify)...)
+
+	errs = append(errs, validateTimezones(config.Timezones)...)
+
+	errs = append(errs, validateNeverTogether(config.NeverTogether)...)
+
+	errs = append(
+		errs, validateEmailNotify(config.Notify.Email, config.SMTP)...,
+	)
+
+	_, err = NewOIDCProvider(config.OIDC, config.StashTimeout.Duration)
+	check(err)
+
+	for index, window := range config.Maintenance.Windows {
+		if !window.End.After(window.Start) {
+			errs = append(errs, fmt.Sprintf(
+				"maintenance.windows[%d]: end should be after start", index,
+			))
+		}
+	}
+
+	check(validateJobs(config.Jobs))
+
+	for _, window := range config.Stats.Windows {
+		if window.Duration <= 0 {
+			errs = append(errs, "stats.windows should be positive")
+			break
+		}
+	}
+
+	if retention := config.HistoryRetention.Duration; retention != 0 &&
+		(retention < config.Scoring.Window.Duration ||
+			retention < config.Workload.Window.Duration) {
+		errs = append(errs, "history_retention should not be shorter than "+
+			"scoring.window and workload.window")
+	}
+
+	check(validateSweep(config.Sweep, config.Backend, config.DefaultGroup))
+
+	check(validateDefaultReviewers(
+		config.DefaultReviewers, config.Backend, config.DefaultGroup,
+	))
+
+	if config.Outbox.MaxAttempts <= 0 {
+		errs = append(errs, "outbox.max_attempts should be positive")
+	}
+
+	if config.Outbox.Backoff.Duration <= 0 ||
+		config.Outbox.MaxBackoff.Duration < config.Outbox.Backoff.Duration {
+		errs = append(errs, "outbox.backoff should be positive and "+
+			"not greater than outbox.max_backoff")
+	}
+
+	if config.Outbox.MaxDead <= 0 {
+		errs = append(errs, "outbox.max_dead should be positive")
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
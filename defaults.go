@@ -0,0 +1,401 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bndr/gopencils"
+)
+
+const anyRefMatcher = "ANY_REF"
+
+// DefaultReviewersConfig pushes candidates of the group into default
+// reviewers settings of repositories, so Stash adds them to new pull
+// requests even when snobs is not invoked.
+type DefaultReviewersConfig struct {
+	Interval     Duration `toml:"interval"`
+	Repositories []string `toml:"repositories"`
+
+	// Group reviewers are taken from, default_group if not set.
+	Group string `toml:"group"`
+
+	RequiredApprovals int `toml:"required_approvals"`
+}
+
+// DefaultReviewersResult is outcome of sync of single repository.
+type DefaultReviewersResult struct {
+	Repository string   `json:"repository"`
+	Reviewers  []string `json:"reviewers"`
+	Changed    bool     `json:"changed"`
+	Error      string   `json:"error,omitempty"`
+
+	err error
+}
+
+// ResponseReviewerCondition is default reviewers condition of repository,
+// snobs manages the condition which matches any source and target branch.
+type ResponseReviewerCondition struct {
+	ID               int64                  `json:"id"`
+	SourceRefMatcher ResponseRefMatcher     `json:"sourceRefMatcher"`
+	TargetRefMatcher ResponseRefMatcher     `json:"targetRefMatcher"`
+	Reviewers        []ResponseReviewerUser `json:"reviewers"`
+	Required         int                    `json:"requiredApprovals"`
+}
+
+type ResponseRefMatcher struct {
+	ID   string `json:"id"`
+	Type struct {
+		ID string `json:"id"`
+	} `json:"type"`
+}
+
+type ResponseReviewerUser struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+func validateDefaultReviewers(
+	config DefaultReviewersConfig, backend string, defaultGroup string,
+) error {
+	if config.Interval.Duration < 0 {
+		return fmt.Errorf("default_reviewers.interval should not be negative")
+	}
+
+	if len(config.Repositories) == 0 {
+		return nil
+	}
+
+	if backend != BackendStash {
+		return fmt.Errorf("default_reviewers is supported only by stash backend")
+	}
+
+	for _, name := range config.Repositories {
+		if strings.Count(name, "/") != 1 || strings.ContainsAny(name, "*?[") {
+			return fmt.Errorf(
+				"default_reviewers repository %q should look like PROJECT/repo",
+				name,
+			)
+		}
+	}
+
+	if config.Group == "" && defaultGroup == "" {
+		return fmt.Errorf("default_reviewers.group or default_group is required")
+	}
+
+	if config.RequiredApprovals < 0 {
+		return fmt.Errorf(
+			"default_reviewers.required_approvals should not be negative",
+		)
+	}
+
+	return nil
+}
+
+// RunDefaultReviewersSync syncs default reviewers on start and then every
+// interval until process exits, nothing is done if interval is not set.
+func (server *SnobServer) RunDefaultReviewersSync() {
+	release := server.hold()
+	interval := server.config.DefaultReviewers.Interval.Duration
+	release()
+
+	if interval <= 0 {
+		return
+	}
+
+	for {
+		release := server.hold()
+		server.SyncDefaultReviewers()
+		release()
+
+		time.Sleep(interval)
+	}
+}
+
+// SyncDefaultReviewers updates default reviewers of every configured
+// repository, failures are reported per repository.
+func (server *SnobServer) SyncDefaultReviewers() []DefaultReviewersResult {
+	group := server.getDefaultReviewersGroup()
+
+	results := []DefaultReviewersResult{}
+
+	for _, name := range server.config.DefaultReviewers.Repositories {
+		project, repository := splitRepositoryName(name)
+
+		result := DefaultReviewersResult{Repository: name}
+
+		reviewers, changed, err := server.syncDefaultReviewers(
+			group, project, repository,
+		)
+		if err != nil {
+			logger.Errorf("can't sync default reviewers of %s: %s", name, err)
+
+			result.Error = err.Error()
+			result.err = err
+		} else {
+			result.Reviewers = reviewers
+			result.Changed = changed
+
+			if changed {
+				logger.Infof(
+					"default reviewers of %s are set to %s",
+					name, strings.Join(reviewers, ", "),
+				)
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// syncDefaultReviewers replaces reviewers of the condition which matches
+// any branch with candidates of the group, the condition is created if
+// it's missing. Conditions made by hand for specific branches are kept.
+func (server *SnobServer) syncDefaultReviewers(
+	group string, project string, repository string,
+) ([]string, bool, error) {
+	_, rule := server.getRule(project, repository)
+
+	users, err := server.GetCandidateUsers(
+		server.getGroupQuery(&Selection{Rule: rule}, group), nil,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+
+	users = excludeUsers(users, append(
+		[]string{server.config.User}, server.config.ExcludeUsers...,
+	))
+	if len(users) == 0 {
+		return nil, false, NewError(
+			ErrorNoCandidates, "group %s has no candidates for %s/%s",
+			group, project, repository,
+		)
+	}
+
+	sort.Strings(users)
+
+	required := server.config.DefaultReviewers.RequiredApprovals
+
+	conditions := []ResponseReviewerCondition{}
+
+	request, err := server.defaultReviewersResource(project, repository).
+		Res("conditions", &conditions).Get()
+	err = checkStashResponse(request, err)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var current *ResponseReviewerCondition
+	for index := range conditions {
+		if conditions[index].isAnyRef() {
+			current = &conditions[index]
+			break
+		}
+	}
+
+	if current != nil && current.Required == required &&
+		current.hasReviewers(users) {
+		return users, false, nil
+	}
+
+	reviewers := []map[string]interface{}{}
+	for _, user := range users {
+		id, err := server.getStashUserID(user)
+		if err != nil {
+			return nil, false, fmt.Errorf("can't get id of %s: %s", user, err)
+		}
+
+		reviewers = append(reviewers, map[string]interface{}{"id": id})
+	}
+
+	payload := map[string]interface{}{
+		"sourceMatcher":     getAnyRefMatcher(),
+		"targetMatcher":     getAnyRefMatcher(),
+		"reviewers":         reviewers,
+		"requiredApprovals": required,
+	}
+
+	resource := server.defaultReviewersResource(project, repository)
+	if current == nil {
+		request, err = resource.Res("condition", &map[string]interface{}{}).
+			Post(payload)
+	} else {
+		request, err = resource.Res("condition").
+			Res(fmt.Sprint(current.ID), &map[string]interface{}{}).
+			Put(payload)
+	}
+
+	err = checkStashResponse(request, err)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return users, true, nil
+}
+
+func (server *SnobServer) getDefaultReviewersGroup() string {
+	if server.config.DefaultReviewers.Group != "" {
+		return server.config.DefaultReviewers.Group
+	}
+
+	return server.config.DefaultGroup
+}
+
+func (server *SnobServer) defaultReviewersResource(
+	project string, repository string,
+) *gopencils.Resource {
+	return server.settingsAPI.Res("projects").Res(project).
+		Res("repos").Res(repository)
+}
+
+func (condition *ResponseReviewerCondition) isAnyRef() bool {
+	return condition.SourceRefMatcher.Type.ID == anyRefMatcher &&
+		condition.TargetRefMatcher.Type.ID == anyRefMatcher
+}
+
+func (condition *ResponseReviewerCondition) hasReviewers(users []string) bool {
+	names := []string{}
+	for _, reviewer := range condition.Reviewers {
+		names = append(names, reviewer.Name)
+	}
+
+	return len(names) == len(users) &&
+		len(NewUserSet(names...).Intersect(users)) == len(users)
+}
+
+func getAnyRefMatcher() map[string]interface{} {
+	return map[string]interface{}{
+		"id":   anyRefMatcher + "_MATCHER_ID",
+		"type": map[string]interface{}{"id": anyRefMatcher},
+	}
+}
+
+func (server *SnobServer) getStashUserID(user string) (int64, error) {
+	var response ResponseReviewerUser
+
+	request, err := server.api.Res("users").Res(user, &response).Get()
+	err = checkStashResponse(request, err)
+	if err != nil {
+		return 0, err
+	}
+
+	return response.ID, nil
+}
+
+// handleDefaultReviewers serves POST /v1/default-reviewers, which syncs
+// default reviewers of configured repositories right away.
+func (server *SnobServer) handleDefaultReviewers(
+	response http.ResponseWriter, request *http.Request,
+) {
+	if request.Method != "POST" {
+		server.reportError(
+			response,
+			NewError(ErrorBadRequest, "method %s is not allowed", request.Method),
+			http.StatusMethodNotAllowed,
+		)
+		return
+	}
+
+	request, ok := server.authorize(response, request, OperationConfig)
+	if !ok {
+		return
+	}
+
+	if len(server.config.DefaultReviewers.Repositories) == 0 {
+		server.reportError(
+			response,
+			NewError(
+				ErrorBadRequest,
+				"default_reviewers.repositories is not configured",
+			),
+			http.StatusBadRequest,
+		)
+		return
+	}
+
+	results := server.SyncDefaultReviewers()
+
+	server.auditDefaultReviewers(request, results)
+
+	changed := false
+	for _, result := range results {
+		changed = changed || result.Changed
+	}
+
+	writeResponse(response, http.StatusOK, APIResponse{
+		Success:      true,
+		Changed:      changed,
+		Repositories: results,
+	})
+}
+
+func (server *SnobServer) auditDefaultReviewers(
+	request *http.Request, results []DefaultReviewersResult,
+) {
+	for _, result := range results {
+		if !result.Changed && result.err == nil {
+			continue
+		}
+
+		project, repository := splitRepositoryName(result.Repository)
+
+		entry := AuditEntry{
+			Action:     AuditSyncDefaults,
+			Project:    project,
+			Repository: repository,
+			Group:      server.getDefaultReviewersGroup(),
+			Reviewers:  result.Reviewers,
+		}
+
+		if result.err != nil {
+			entry.Result = getErrorCategory(result.err)
+			entry.Error = result.Error
+		}
+
+		server.audit(request, entry)
+	}
+}
+
+// runSyncReviewers syncs default reviewers once without starting server.
+func runSyncReviewers(config *Config) error {
+	server, err := NewSnobServer(config)
+	if err != nil {
+		return err
+	}
+
+	results := server.SyncDefaultReviewers()
+
+	server.auditDefaultReviewers(nil, results)
+
+	failed := 0
+	for _, result := range results {
+		switch {
+		case result.err != nil:
+			failed++
+
+			fmt.Printf("%s: error: %s\n", result.Repository, result.Error)
+
+		case result.Changed:
+			fmt.Printf(
+				"%s: %s\n",
+				result.Repository, strings.Join(result.Reviewers, ", "),
+			)
+
+		default:
+			fmt.Printf("%s: up to date\n", result.Repository)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf(
+			"default reviewers of %d repositories are not synced", failed,
+		)
+	}
+
+	return nil
+}
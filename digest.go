@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// digestOutstandingWindow limits how old assignments are checked for
+	// outstanding reviews, so digest doesn't query Stash for every pull
+	// request ever assigned.
+	digestOutstandingWindow = 30 * 24 * time.Hour
+
+	defaultDigestSLA = 48 * time.Hour
+)
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+type DigestConfig struct {
+	DigestDestination
+
+	Weekday string   `toml:"weekday"`
+	Hour    *int     `toml:"hour"`
+	SLA     Duration `toml:"sla"`
+
+	// Teams override destination of digest for particular groups.
+	Teams map[string]DigestDestination `toml:"teams"`
+}
+
+type DigestSchedule struct {
+	Weekday time.Weekday
+	Hour    int
+	SLA     time.Duration
+}
+
+type DigestDestination struct {
+	SlackURL     string   `toml:"slack_url"`
+	SlackChannel string   `toml:"slack_channel"`
+	Email        []string `toml:"email"`
+}
+
+// getDigestSchedule returns nil schedule if digest is not configured.
+func getDigestSchedule(config *DigestConfig) (*DigestSchedule, error) {
+	if config == nil {
+		return nil, nil
+	}
+
+	schedule := &DigestSchedule{
+		Weekday: time.Monday,
+		Hour:    9,
+		SLA:     defaultDigestSLA,
+	}
+
+	if config.Weekday != "" {
+		weekday, ok := weekdays[strings.ToLower(config.Weekday)]
+		if !ok {
+			return nil, fmt.Errorf("invalid digest.weekday: %q", config.Weekday)
+		}
+
+		schedule.Weekday = weekday
+	}
+
+	if config.Hour != nil {
+		if *config.Hour < 0 || *config.Hour > 23 {
+			return nil, fmt.Errorf("invalid digest.hour: %d", *config.Hour)
+		}
+
+		schedule.Hour = *config.Hour
+	}
+
+	if config.SLA.Duration > 0 {
+		schedule.SLA = config.SLA.Duration
+	}
+
+	return schedule, nil
+}
+
+func (schedule *DigestSchedule) Next(now time.Time) time.Time {
+	next := time.Date(
+		now.Year(), now.Month(), now.Day(), schedule.Hour, 0, 0, 0,
+		now.Location(),
+	)
+
+	for next.Weekday() != schedule.Weekday || !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+
+	return next
+}
+
+func (server *SnobServer) RunDigests() {
+	for {
+		release := server.hold()
+		schedule, _ := getDigestSchedule(server.config.Digest)
+		release()
+
+		if schedule == nil {
+			return
+		}
+
+		next := schedule.Next(time.Now())
+
+		logger.Infof("next digest will be sent at %s", next)
+
+		time.Sleep(next.Sub(time.Now()))
+
+		release = server.hold()
+		server.SendDigests(schedule, next.AddDate(0, 0, -7))
+		release()
+	}
+}
+
+func (server *SnobServer) SendDigests(schedule *DigestSchedule, since time.Time) {
+	var (
+		now     = time.Now()
+		entries = server.history.Since(now.Add(-digestOutstandingWindow))
+		groups  = map[string][]HistoryEntry{}
+	)
+
+	for _, entry := range entries {
+		groups[entry.Group] = append(groups[entry.Group], entry)
+	}
+
+	names := []string{}
+	for name := range groups {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	pullRequests := map[string]*ResponsePullRequest{}
+
+	for _, name := range names {
+		destination := server.getDigestDestination(name)
+		if destination.SlackURL == "" && len(destination.Email) == 0 {
+			continue
+		}
+
+		text := server.formatDigest(
+			schedule, name, groups[name], since, now, pullRequests,
+		)
+
+		if destination.SlackURL != "" {
+			err := server.outbox.Enqueue(Notification{
+				Kind:    NotificationSlack,
+				URL:     destination.SlackURL,
+				Channel: destination.SlackChannel,
+				Text:    text,
+			})
+			if err != nil {
+				logger.Errorf("can't queue digest for %s to slack: %s", name, err)
+			}
+		}
+
+		if len(destination.Email) > 0 {
+			err := server.outbox.Enqueue(Notification{
+				Kind:    NotificationEmail,
+				To:      destination.Email,
+				Subject: "Weekly review digest: " + name,
+				Text:    text,
+			})
+			if err != nil {
+				logger.Errorf("can't queue digest for %s by email: %s", name, err)
+			}
+		}
+	}
+}
+
+func (server *SnobServer) getDigestDestination(group string) DigestDestination {
+	config := server.config.Digest
+	if config == nil {
+		return DigestDestination{}
+	}
+
+	if destination, ok := config.Teams[group]; ok {
+		return destination
+	}
+
+	return config.DigestDestination
+}
+
+func (server *SnobServer) formatDigest(
+	schedule *DigestSchedule, group string, entries []HistoryEntry,
+	since time.Time, now time.Time,
+	pullRequests map[string]*ResponsePullRequest,
+) string {
+	var (
+		text        = &bytes.Buffer{}
+		assignments = []HistoryEntry{}
+		counts      = map[string]int{}
+		outstanding = []string{}
+		checked     = map[string]bool{}
+	)
+
+	for _, entry := range entries {
+		if !entry.Time.Before(since) {
+			assignments = append(assignments, entry)
+
+			for _, reviewer := range entry.Reviewers {
+				counts[normalizeUser(reviewer)]++
+			}
+		}
+	}
+
+	// newest entries go first, so each pull request is checked against its
+	// most recent assignment only
+	for index := len(entries) - 1; index >= 0; index-- {
+		entry := entries[index]
+		name := fmt.Sprintf(
+			"%s/%s#%s", entry.Project, entry.Repository, entry.PullRequest,
+		)
+
+		if checked[name] || now.Sub(entry.Time) < schedule.SLA {
+			continue
+		}
+
+		checked[name] = true
+
+		info, ok := pullRequests[name]
+		if !ok {
+			var err error
+			info, err = server.GetPullRequestInfo(
+				entry.Project, entry.Repository, entry.PullRequest,
+			)
+			if err != nil {
+				logger.Errorf("can't get %s for digest: %s", name, err)
+				continue
+			}
+
+			pullRequests[name] = info
+		}
+
+		if info.State != "OPEN" {
+			continue
+		}
+
+		pending := []string{}
+		for _, reviewer := range info.Reviewers {
+			if reviewer.Approved {
+				continue
+			}
+
+			for _, user := range entry.Reviewers {
+				if strings.EqualFold(user, reviewer.User.Name) {
+					pending = append(pending, user)
+				}
+			}
+		}
+
+		if len(pending) > 0 {
+			outstanding = append(outstanding, fmt.Sprintf(
+				"  %s by %s: %s (assigned %s ago)",
+				name, entry.Author, strings.Join(pending, ", "),
+				now.Sub(entry.Time)/time.Hour*time.Hour,
+			))
+		}
+	}
+
+	fmt.Fprintf(
+		text, "Weekly review digest for %s since %s\n\n",
+		group, since.Format("2006-01-02"),
+	)
+
+	fmt.Fprintf(text, "Assignments: %d\n", len(assignments))
+	for _, entry := range assignments {
+		fmt.Fprintf(
+			text, "  %s/%s#%s by %s: %s\n",
+			entry.Project, entry.Repository, entry.PullRequest,
+			entry.Author, strings.Join(entry.Reviewers, ", "),
+		)
+	}
+
+	fmt.Fprintf(
+		text, "\nOutstanding reviews past SLA (%s): %d\n",
+		schedule.SLA, len(outstanding),
+	)
+	for _, line := range outstanding {
+		fmt.Fprintln(text, line)
+	}
+
+	reviewers := []string{}
+	for reviewer := range counts {
+		reviewers = append(reviewers, reviewer)
+	}
+
+	sort.Slice(reviewers, func(i, j int) bool {
+		if counts[reviewers[i]] != counts[reviewers[j]] {
+			return counts[reviewers[i]] > counts[reviewers[j]]
+		}
+
+		return reviewers[i] < reviewers[j]
+	})
+
+	// capacity is personal, so it's checked against assignments from all
+	// groups
+	weekly := server.history.CountAssignments(now.AddDate(0, 0, -7))
+
+	fmt.Fprintf(text, "\nAssignments per reviewer:\n")
+	for _, reviewer := range reviewers {
+		capacity := server.availability.Get(reviewer).Capacity
+		if capacity == 0 {
+			fmt.Fprintf(text, "  %s: %d\n", reviewer, counts[reviewer])
+			continue
+		}
+
+		mark := ""
+		if weekly[reviewer] >= capacity {
+			mark = ", at capacity"
+		}
+
+		fmt.Fprintf(
+			text, "  %s: %d (capacity %d%s)\n",
+			reviewer, counts[reviewer], capacity, mark,
+		)
+	}
+
+	return text.String()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	reDirective = regexp.MustCompile(`(?im)^\s*snobs:\s*(.*?)\s*$`)
+)
+
+// Directives are written by pull request author in the description, like:
+//
+//	snobs: team-backend count=2
+//	snobs: skip
+type Directives struct {
+	Skip  bool
+	Group string
+	Count int
+}
+
+func parseDirectives(description string) Directives {
+	directives := Directives{}
+
+	for _, match := range reDirective.FindAllStringSubmatch(description, -1) {
+		for _, token := range strings.Fields(match[1]) {
+			name, value := token, ""
+			if index := strings.Index(token, "="); index >= 0 {
+				name, value = token[:index], token[index+1:]
+			}
+
+			switch {
+			case name == "skip" && value == "":
+				directives.Skip = true
+
+			case name == "count":
+				count, err := strconv.Atoi(value)
+				if err != nil || count <= 0 {
+					logger.Warnf("ignoring invalid directive %q", token)
+					continue
+				}
+
+				directives.Count = count
+
+			case value == "":
+				directives.Group = name
+
+			default:
+				logger.Warnf("ignoring unknown directive %q", token)
+			}
+		}
+	}
+
+	return directives
+}
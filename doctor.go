@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// doctorCheck is single line of doctor report.
+type doctorCheck struct {
+	Name string
+	Err  error
+}
+
+// runDoctor checks configuration and everything snobs needs from Stash
+// and prints report, it returns error if any check failed. Sample
+// repository is PROJECT/repo, write permission is checked on it if given.
+func runDoctor(configPath string, sampleRepository string) error {
+	checks := []doctorCheck{}
+
+	report := func(name string, err error) {
+		checks = append(checks, doctorCheck{Name: name, Err: err})
+	}
+
+	defer func() {
+		for _, check := range checks {
+			if check.Err != nil {
+				fmt.Printf("[FAIL] %s: %s\n", check.Name, check.Err)
+			} else {
+				fmt.Printf("[PASS] %s\n", check.Name)
+			}
+		}
+	}()
+
+	config, err := getConfig(configPath)
+	report("configuration "+configPath, err)
+	if err != nil {
+		return fmt.Errorf("configuration is invalid")
+	}
+
+	server, err := NewSnobServer(config)
+	report("initialization", err)
+	if err != nil {
+		return fmt.Errorf("can't initialize server")
+	}
+
+	result, err := server.api.Res("users").
+		Res(config.User, &map[string]interface{}{}).Get()
+	err = checkStashResponse(result, err)
+	report("stash "+config.Stash+" as "+config.User, err)
+	if err != nil {
+		return fmt.Errorf("can't connect to stash")
+	}
+
+	failed := false
+
+	for _, group := range getDoctorGroups(config) {
+		name := "group " + group
+
+		if _, ok := server.staticGroups[group]; !ok {
+			// Members of Stash groups are listed by admin API, so this
+			// check fails if service account is not an admin.
+			name += " (group admin read)"
+		}
+
+		users, err := server.GetUsers(group)
+		if err == nil && len(users) == 0 {
+			err = fmt.Errorf("group is empty")
+		}
+
+		report(name, err)
+
+		failed = failed || err != nil
+	}
+
+	if sampleRepository != "" {
+		err := server.checkRepositoryWrite(sampleRepository)
+		report("pull request write on "+sampleRepository, err)
+
+		failed = failed || err != nil
+	}
+
+	if failed {
+		return fmt.Errorf("some checks failed")
+	}
+
+	return nil
+}
+
+// getDoctorGroups returns every group referenced by configuration.
+func getDoctorGroups(config *Config) []string {
+	groups := map[string]bool{}
+	for _, list := range [][]string{
+		config.Intersect, config.Union, config.ExcludeGroups,
+	} {
+		for _, group := range list {
+			groups[group] = true
+		}
+	}
+
+	if config.DefaultGroup != "" {
+		groups[config.DefaultGroup] = true
+	}
+
+	for _, route := range config.Routes {
+		groups[route.Group] = true
+	}
+
+	for _, expert := range config.Experts {
+		for _, group := range expert.Groups {
+			groups[group] = true
+		}
+	}
+
+	names := []string{}
+	for group := range groups {
+		names = append(names, group)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// checkRepositoryWrite checks that service account has write permission,
+// which is required to modify pull requests, on repository PROJECT/repo.
+func (server *SnobServer) checkRepositoryWrite(repository string) error {
+	parts := strings.SplitN(repository, "/", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("expected PROJECT/repo, got %q", repository)
+	}
+
+	var response struct {
+		Values []struct {
+			Slug    string `json:"slug"`
+			Project struct {
+				Key string `json:"key"`
+			} `json:"project"`
+		} `json:"values"`
+	}
+
+	request, err := server.api.Res("repos", &response).Get(map[string]string{
+		"projectname": parts[0],
+		"name":        parts[1],
+		"permission":  "REPO_WRITE",
+	})
+
+	err = checkStashResponse(request, err)
+	if err != nil {
+		return err
+	}
+
+	for _, value := range response.Values {
+		if strings.EqualFold(value.Project.Key, parts[0]) &&
+			strings.EqualFold(value.Slug, parts[1]) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf(
+		"%s has no write permission or repository doesn't exist",
+		server.config.User,
+	)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/BurntSushi/toml"
+)
+
+const redacted = "<redacted>"
+
+// Redacted returns copy of configuration with passwords, keys and webhook
+// URLs, which embed tokens, replaced by placeholder.
+func (config *Config) Redacted() *Config {
+	copied := *config
+
+	redact := func(value *string) {
+		if *value != "" {
+			*value = redacted
+		}
+	}
+
+	redact(&copied.Pass)
+	redact(&copied.Token)
+	redact(&copied.AuditKey)
+	redact(&copied.Jira.Pass)
+	redact(&copied.SMTP.Pass)
+	redact(&copied.LDAP.BindPass)
+	redact(&copied.OIDC.ClientSecret)
+	redact(&copied.OIDC.SessionKey)
+	redact(&copied.Webhook.Secret)
+	redact(&copied.Notify.Slack.URL)
+	redact(&copied.GitHub.Token)
+	redact(&copied.GitLab.Token)
+	redact(&copied.Gerrit.Pass)
+	redact(&copied.Bitbucket.AppPassword)
+	redact(&copied.Bitbucket.Token)
+
+	copied.Notify.Webhooks = []WebhookNotifyConfig{}
+	for _, webhook := range config.Notify.Webhooks {
+		redact(&webhook.URL)
+		copied.Notify.Webhooks = append(copied.Notify.Webhooks, webhook)
+	}
+
+	copied.Keys = map[string]KeyConfig{}
+	for name, key := range config.Keys {
+		redact(&key.Key)
+		copied.Keys[name] = key
+	}
+
+	if config.Instances != nil {
+		copied.Instances = map[string]InstanceConfig{}
+		for name, instance := range config.Instances {
+			redact(&instance.Pass)
+			redact(&instance.Token)
+			copied.Instances[name] = instance
+		}
+	}
+
+	if config.Digest != nil {
+		digest := *config.Digest
+		redact(&digest.SlackURL)
+
+		digest.Teams = map[string]DigestDestination{}
+		for name, destination := range config.Digest.Teams {
+			redact(&destination.SlackURL)
+			digest.Teams[name] = destination
+		}
+
+		copied.Digest = &digest
+	}
+
+	return &copied
+}
+
+// handleConfig serves GET /v1/config with configuration which running
+// process actually uses: defaults merged with the configuration file and
+// with changes applied at runtime, secrets are redacted.
+func (server *SnobServer) handleConfig(
+	response http.ResponseWriter, request *http.Request,
+) {
+	if request.Method != "GET" {
+		server.reportError(
+			response,
+			NewError(ErrorBadRequest, "method %s is not allowed", request.Method),
+			http.StatusMethodNotAllowed,
+		)
+		return
+	}
+
+	request, ok := server.authorize(response, request, OperationConfig)
+	if !ok {
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/toml; charset=utf-8")
+
+	err := toml.NewEncoder(response).Encode(server.config.Redacted())
+	if err != nil {
+		server.reportError(response, err, http.StatusInternalServerError)
+	}
+}
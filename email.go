@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultEmailTemplate = `{{.Author}} asked you to review pull request
+{{.Project}}/{{.Repository}}#{{.PullRequest}}: {{.Title}}
+
+{{.URL}}
+
+Reviewers from group {{.Group}}: {{join .Reviewers ", "}}
+`
+
+// EmailNotifyConfig configures mail sent through [smtp]: every assignment
+// to the chosen reviewers and daily digest of assignments.
+type EmailNotifyConfig struct {
+	Assignments bool   `toml:"assignments"`
+	Template    string `toml:"template"`
+
+	// Domain gives address user@domain of reviewer, unless it's listed in
+	// addresses.
+	Domain    string            `toml:"domain"`
+	Addresses map[string]string `toml:"addresses"`
+
+	Digest     []string `toml:"digest"`
+	DigestHour *int     `toml:"digest_hour"`
+}
+
+func validateEmailNotify(
+	config EmailNotifyConfig, smtp SMTPConfig,
+) ConfigErrors {
+	errs := ConfigErrors{}
+
+	if !config.Assignments && len(config.Digest) == 0 {
+		return errs
+	}
+
+	if smtp.Address == "" || smtp.From == "" {
+		errs = append(errs, "smtp.address and smtp.from are required "+
+			"by notify.email")
+	}
+
+	if config.Assignments {
+		if config.Domain == "" && len(config.Addresses) == 0 {
+			errs = append(errs, "notify.email.domain or "+
+				"notify.email.addresses is required by assignments")
+		}
+
+		_, err := parseTemplate("notify.email.template", config.Template)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("notify.email.template: %s", err))
+		}
+	}
+
+	if config.DigestHour != nil &&
+		(*config.DigestHour < 0 || *config.DigestHour > 23) {
+		errs = append(errs, fmt.Sprintf(
+			"invalid notify.email.digest_hour: %d", *config.DigestHour,
+		))
+	}
+
+	return errs
+}
+
+// getAddress returns mail address of the user, empty if it's unknown.
+func (config EmailNotifyConfig) getAddress(user string) string {
+	for name, address := range config.Addresses {
+		if strings.EqualFold(name, user) {
+			return address
+		}
+	}
+
+	if config.Domain == "" {
+		return ""
+	}
+
+	return user + "@" + config.Domain
+}
+
+// notifyEmail queues mail about the assignment to added reviewers.
+func (server *SnobServer) notifyEmail(assignment *Assignment) error {
+	config := server.config.Notify.Email
+	if !config.Assignments {
+		return nil
+	}
+
+	to := []string{}
+	for _, reviewer := range assignment.Reviewers {
+		address := config.getAddress(reviewer)
+		if address == "" {
+			logger.Warnf("mail address of %s is unknown", reviewer)
+			continue
+		}
+
+		to = append(to, address)
+	}
+
+	if len(to) == 0 {
+		return nil
+	}
+
+	template := config.Template
+	if template == "" {
+		template = defaultEmailTemplate
+	}
+
+	text, err := renderTemplate("notify.email.template", template, assignment)
+	if err != nil {
+		return err
+	}
+
+	return server.outbox.Enqueue(Notification{
+		Kind: NotificationEmail,
+		To:   to,
+		Subject: fmt.Sprintf(
+			"Review requested: %s/%s#%s %s",
+			assignment.Project, assignment.Repository, assignment.PullRequest,
+			assignment.Info.Title,
+		),
+		Text: text,
+	})
+}
+
+// RunEmailDigest sends daily digest of assignments until process exits,
+// nothing is done if digest recipients are not configured.
+func (server *SnobServer) RunEmailDigest() {
+	for {
+		release := server.hold()
+		config := server.config.Notify.Email
+		release()
+
+		if len(config.Digest) == 0 {
+			return
+		}
+
+		hour := 9
+		if config.DigestHour != nil {
+			hour = *config.DigestHour
+		}
+
+		now := time.Now()
+		next := time.Date(
+			now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, now.Location(),
+		)
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+
+		logger.Infof("next email digest will be sent at %s", next)
+
+		time.Sleep(next.Sub(now))
+
+		release = server.hold()
+		server.SendEmailDigest(next.AddDate(0, 0, -1), next)
+		release()
+	}
+}
+
+func (server *SnobServer) SendEmailDigest(since time.Time, until time.Time) {
+	text := formatEmailDigest(server.history.Since(since), since, until)
+
+	err := server.outbox.Enqueue(Notification{
+		Kind:    NotificationEmail,
+		To:      server.config.Notify.Email.Digest,
+		Subject: "Daily review digest: " + since.Format("2006-01-02"),
+		Text:    text,
+	})
+	if err != nil {
+		logger.Errorf("can't queue email digest: %s", err)
+	}
+}
+
+func formatEmailDigest(
+	entries []HistoryEntry, since time.Time, until time.Time,
+) string {
+	var (
+		text   = &bytes.Buffer{}
+		counts = map[string]int{}
+		lines  = []string{}
+	)
+
+	for _, entry := range entries {
+		if !entry.Time.Before(until) {
+			continue
+		}
+
+		lines = append(lines, fmt.Sprintf(
+			"  %s %s/%s#%s by %s (%s): %s",
+			entry.Time.Format("15:04"),
+			entry.Project, entry.Repository, entry.PullRequest,
+			entry.Author, entry.Group, strings.Join(entry.Reviewers, ", "),
+		))
+
+		for _, reviewer := range entry.Reviewers {
+			counts[normalizeUser(reviewer)]++
+		}
+	}
+
+	fmt.Fprintf(
+		text, "Review assignments since %s\n\n",
+		since.Format("2006-01-02 15:04"),
+	)
+
+	fmt.Fprintf(text, "Assignments: %d\n", len(lines))
+	for _, line := range lines {
+		fmt.Fprintln(text, line)
+	}
+
+	reviewers := []string{}
+	for reviewer := range counts {
+		reviewers = append(reviewers, reviewer)
+	}
+
+	sort.Strings(reviewers)
+
+	fmt.Fprintf(text, "\nAssignments per reviewer:\n")
+	for _, reviewer := range reviewers {
+		fmt.Fprintf(text, "  %s: %d\n", reviewer, counts[reviewer])
+	}
+
+	return text.String()
+}
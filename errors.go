@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bndr/gopencils"
+)
+
+const (
+	ErrorStashAuth           = "stash_auth"
+	ErrorStashTimeout        = "stash_timeout"
+	ErrorStashUnreachable    = "stash_unreachable"
+	ErrorStash               = "stash_error"
+	ErrorPullRequestNotFound = "pr_not_found"
+	ErrorVersionConflict     = "version_conflict"
+	ErrorBadRequest          = "bad_request"
+	ErrorBadURL              = "bad_url"
+	ErrorGroupNotFound       = "group_not_found"
+	ErrorForbidden           = "forbidden"
+	ErrorUnauthorized        = "unauthorized"
+	ErrorQuotaExceeded       = "quota_exceeded"
+	ErrorRateLimited         = "rate_limited"
+	ErrorTooManyReviewers    = "too_many_reviewers"
+	ErrorNoCandidates        = "no_candidates"
+	ErrorTenantNotFound      = "tenant_not_found"
+	ErrorJobNotFound         = "job_not_found"
+	ErrorMaintenance         = "maintenance"
+	ErrorInternal            = "internal"
+)
+
+var errorCategories = []string{
+	ErrorStashAuth,
+	ErrorStashTimeout,
+	ErrorStashUnreachable,
+	ErrorStash,
+	ErrorPullRequestNotFound,
+	ErrorVersionConflict,
+	ErrorBadRequest,
+	ErrorBadURL,
+	ErrorGroupNotFound,
+	ErrorForbidden,
+	ErrorUnauthorized,
+	ErrorQuotaExceeded,
+	ErrorRateLimited,
+	ErrorTooManyReviewers,
+	ErrorNoCandidates,
+	ErrorTenantNotFound,
+	ErrorJobNotFound,
+	ErrorMaintenance,
+	ErrorInternal,
+}
+
+type Error struct {
+	Category string
+	Message  string
+
+	// RetryAfter is reported to client in Retry-After header.
+	RetryAfter time.Duration
+}
+
+func NewError(category string, format string, args ...interface{}) *Error {
+	return &Error{
+		Category: category,
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
+
+func (err *Error) Error() string {
+	return err.Message
+}
+
+type StashError struct {
+	// Service is name of responded service, stash if empty.
+	Service    string
+	StatusCode int
+	Status     string
+	Messages   []string
+}
+
+func (err *StashError) Error() string {
+	service := err.Service
+	if service == "" {
+		service = "stash"
+	}
+
+	if len(err.Messages) == 0 {
+		return fmt.Sprintf("%s responded with %s", service, err.Status)
+	}
+
+	return fmt.Sprintf(
+		"%s responded with %s: %s",
+		service, err.Status, strings.Join(err.Messages, "; "),
+	)
+}
+
+// checkStashResponse turns HTTP error statuses, which gopencils does not
+// report as errors, into StashError.
+func checkStashResponse(resource *gopencils.Resource, err error) error {
+	if resource == nil || resource.Raw == nil || resource.Raw.StatusCode < 400 {
+		return err
+	}
+
+	defer resource.Raw.Body.Close()
+
+	var body struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+
+	stashError := &StashError{
+		StatusCode: resource.Raw.StatusCode,
+		Status:     resource.Raw.Status,
+	}
+
+	if json.NewDecoder(resource.Raw.Body).Decode(&body) == nil {
+		for _, item := range body.Errors {
+			stashError.Messages = append(stashError.Messages, item.Message)
+		}
+	}
+
+	return stashError
+}
+
+// isVersionConflict reports whether Stash rejected update because pull
+// request was changed since its version was read.
+func isVersionConflict(err error) bool {
+	stashError, ok := err.(*StashError)
+
+	return ok && stashError.StatusCode == http.StatusConflict
+}
+
+func getErrorStatus(err error) int {
+	switch getErrorCategory(err) {
+	case ErrorBadRequest, ErrorBadURL:
+		return http.StatusBadRequest
+
+	case ErrorUnauthorized:
+		return http.StatusUnauthorized
+
+	case ErrorForbidden:
+		return http.StatusForbidden
+
+	case ErrorPullRequestNotFound, ErrorTenantNotFound, ErrorGroupNotFound,
+		ErrorJobNotFound:
+		return http.StatusNotFound
+
+	case ErrorVersionConflict:
+		return http.StatusConflict
+
+	case ErrorTooManyReviewers, ErrorNoCandidates:
+		return http.StatusUnprocessableEntity
+
+	case ErrorQuotaExceeded, ErrorRateLimited:
+		return http.StatusTooManyRequests
+
+	case ErrorStashTimeout:
+		return http.StatusGatewayTimeout
+
+	case ErrorMaintenance:
+		return http.StatusServiceUnavailable
+
+	case ErrorStashAuth, ErrorStashUnreachable, ErrorStash:
+		return http.StatusBadGateway
+	}
+
+	return http.StatusInternalServerError
+}
+
+func getErrorCategory(err error) string {
+	switch err := err.(type) {
+	case *Error:
+		return err.Category
+
+	case *StashError:
+		switch {
+		case err.StatusCode == http.StatusUnauthorized,
+			err.StatusCode == http.StatusForbidden:
+			return ErrorStashAuth
+
+		case err.StatusCode == http.StatusNotFound:
+			return ErrorPullRequestNotFound
+
+		case err.StatusCode == http.StatusConflict:
+			return ErrorVersionConflict
+
+		case err.StatusCode >= 500:
+			return ErrorStashUnreachable
+		}
+
+		return ErrorStash
+
+	case net.Error:
+		if err.Timeout() {
+			return ErrorStashTimeout
+		}
+
+		return ErrorStashUnreachable
+	}
+
+	return ErrorInternal
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/nats-io/go-nats"
+)
+
+const (
+	EventAssignment = "assignment"
+	EventUndo       = "undo"
+	EventRemove     = "remove"
+	EventError      = "error"
+)
+
+type EventsConfig struct {
+	Kafka struct {
+		Brokers []string `toml:"brokers"`
+		Topic   string   `toml:"topic"`
+	} `toml:"kafka"`
+
+	NATS struct {
+		URL     string `toml:"url"`
+		Subject string `toml:"subject"`
+	} `toml:"nats"`
+}
+
+// Event is published for every assignment attempt, so review analytics can
+// be built downstream without polling the API.
+type Event struct {
+	Type        string    `json:"type"`
+	Time        time.Time `json:"time"`
+	Project     string    `json:"project"`
+	Repository  string    `json:"repository"`
+	PullRequest string    `json:"pull_request"`
+	URL         string    `json:"url,omitempty"`
+	Author      string    `json:"author,omitempty"`
+	Group       string    `json:"group,omitempty"`
+	Reviewers   []string  `json:"reviewers,omitempty"`
+	Outcome     string    `json:"outcome,omitempty"`
+	Category    string    `json:"category,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Events publishes events to Kafka topic and NATS subject if configured,
+// publishing is asynchronous and failures are only logged. All methods are
+// no-op on nil Events.
+type Events struct {
+	kafka      sarama.AsyncProducer
+	kafkaTopic string
+
+	nats        *nats.Conn
+	natsSubject string
+}
+
+// NewEvents returns nil if neither Kafka nor NATS is configured.
+func NewEvents(config EventsConfig) (*Events, error) {
+	if len(config.Kafka.Brokers) == 0 && config.NATS.URL == "" {
+		return nil, nil
+	}
+
+	events := &Events{}
+
+	if len(config.Kafka.Brokers) > 0 {
+		if config.Kafka.Topic == "" {
+			return nil, fmt.Errorf("events.kafka.topic is required")
+		}
+
+		kafkaConfig := sarama.NewConfig()
+		kafkaConfig.ClientID = "snobs"
+		kafkaConfig.Producer.RequiredAcks = sarama.WaitForLocal
+		kafkaConfig.Producer.Return.Errors = true
+
+		producer, err := sarama.NewAsyncProducer(
+			config.Kafka.Brokers, kafkaConfig,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("can't connect to kafka: %s", err)
+		}
+
+		go func() {
+			for err := range producer.Errors() {
+				logger.Errorf("can't publish event to kafka: %s", err.Err)
+			}
+		}()
+
+		events.kafka = producer
+		events.kafkaTopic = config.Kafka.Topic
+	}
+
+	if config.NATS.URL != "" {
+		if config.NATS.Subject == "" {
+			return nil, fmt.Errorf("events.nats.subject is required")
+		}
+
+		conn, err := nats.Connect(config.NATS.URL, nats.Name("snobs"))
+		if err != nil {
+			return nil, fmt.Errorf("can't connect to nats: %s", err)
+		}
+
+		events.nats = conn
+		events.natsSubject = config.NATS.Subject
+	}
+
+	return events, nil
+}
+
+func (events *Events) Publish(event Event) {
+	if events == nil {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		logger.Errorf("can't encode event: %s", err)
+		return
+	}
+
+	// Events of the same pull request go to the same partition, so they
+	// are consumed in order.
+	key := event.Project + "/" + event.Repository + "/" + event.PullRequest
+
+	if events.kafka != nil {
+		events.kafka.Input() <- &sarama.ProducerMessage{
+			Topic: events.kafkaTopic,
+			Key:   sarama.StringEncoder(key),
+			Value: sarama.ByteEncoder(payload),
+		}
+	}
+
+	if events.nats != nil {
+		err := events.nats.Publish(events.natsSubject, payload)
+		if err != nil {
+			logger.Errorf("can't publish event to nats: %s", err)
+		}
+	}
+}
+
+// PublishAssignment publishes result of the assignment attempt.
+func (events *Events) PublishAssignment(
+	project, repository, pullRequest string,
+	assignment *Assignment, err error,
+) {
+	events.Publish(
+		getAssignmentEvent(project, repository, pullRequest, assignment, err),
+	)
+}
+
+func getAssignmentEvent(
+	project, repository, pullRequest string,
+	assignment *Assignment, err error,
+) Event {
+	event := Event{
+		Type:        EventAssignment,
+		Project:     project,
+		Repository:  repository,
+		PullRequest: pullRequest,
+		Outcome:     getOutcome(assignment, err),
+	}
+
+	if err != nil {
+		event.Type = EventError
+		event.Category = getErrorCategory(err)
+		event.Error = err.Error()
+	}
+
+	if assignment != nil {
+		event.Group = assignment.Group
+		event.Reviewers = assignment.Reviewers
+
+		if assignment.Info != nil {
+			event.Author = assignment.Info.Author.User.Name
+			event.URL = assignment.Info.GetURL()
+		}
+	}
+
+	return event
+}
+
+func (events *Events) Close() {
+	if events == nil {
+		return
+	}
+
+	if events.kafka != nil {
+		events.kafka.Close()
+	}
+
+	if events.nats != nil {
+		events.nats.Close()
+	}
+}
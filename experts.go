@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type ExpertRule struct {
+	Name     string
+	Paths    []string
+	Groups   []string
+	Users    []string
+	patterns []*regexp.Regexp
+}
+
+type ResponseChanges struct {
+	Values []struct {
+		Path struct {
+			String string `json:"toString"`
+		} `json:"path"`
+	} `json:"values"`
+	IsLastPage    bool `json:"isLastPage"`
+	NextPageStart int  `json:"nextPageStart"`
+}
+
+type ExpertConfig struct {
+	Paths  []string `toml:"paths"`
+	Groups []string `toml:"groups"`
+	Users  []string `toml:"users"`
+}
+
+func getExpertRules(config map[string]ExpertConfig) ([]ExpertRule, error) {
+	names := []string{}
+	for name := range config {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	rules := []ExpertRule{}
+	for _, name := range names {
+		rule := ExpertRule{
+			Name:   name,
+			Paths:  config[name].Paths,
+			Groups: config[name].Groups,
+			Users:  config[name].Users,
+		}
+
+		if len(rule.Paths) == 0 {
+			return nil, fmt.Errorf("experts.%s: paths are required", name)
+		}
+
+		for _, path := range rule.Paths {
+			pattern, err := compilePathGlob(path)
+			if err != nil {
+				return nil, fmt.Errorf(
+					"experts.%s: invalid path %q: %s", name, path, err,
+				)
+			}
+
+			rule.patterns = append(rule.patterns, pattern)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// compilePathGlob converts gitignore-like glob to regexp: pattern without
+// slash matches file name in any directory, leading slash anchors pattern
+// to repository root, ** matches any number of directories.
+func compilePathGlob(glob string) (*regexp.Regexp, error) {
+	expression := &strings.Builder{}
+	expression.WriteString("^")
+
+	switch {
+	case strings.HasPrefix(glob, "/"):
+		glob = strings.TrimPrefix(glob, "/")
+
+	case !strings.Contains(glob, "/"):
+		expression.WriteString("(.*/)?")
+	}
+
+	for index := 0; index < len(glob); index++ {
+		switch char := glob[index]; {
+		case strings.HasPrefix(glob[index:], "**/"):
+			expression.WriteString("(.*/)?")
+			index += 2
+
+		case strings.HasPrefix(glob[index:], "**"):
+			expression.WriteString(".*")
+			index++
+
+		case char == '*':
+			expression.WriteString("[^/]*")
+
+		case char == '?':
+			expression.WriteString("[^/]")
+
+		default:
+			expression.WriteString(regexp.QuoteMeta(string(char)))
+		}
+	}
+
+	expression.WriteString("$")
+
+	return regexp.Compile(expression.String())
+}
+
+func (rule ExpertRule) Matches(paths []string) bool {
+	for _, path := range paths {
+		for _, pattern := range rule.patterns {
+			if pattern.MatchString(path) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (server *SnobServer) GetPullRequestChanges(
+	project string, repository string, pullRequest string,
+) ([]string, error) {
+	return server.backend.GetPullRequestChanges(
+		project, repository, pullRequest,
+	)
+}
+
+func (server *SnobServer) getStashPullRequestChanges(
+	project string, repository string, pullRequest string,
+) ([]string, error) {
+	paths := []string{}
+	start := 0
+
+	for {
+		request, err := server.repositoryResource(project, repository).
+			Res("pull-requests").Res(pullRequest).
+			Res("changes", &ResponseChanges{}).
+			Get(map[string]string{
+				"start": strconv.Itoa(start),
+				"limit": "500",
+			})
+
+		err = checkStashResponse(request, err)
+		if err != nil {
+			return nil, err
+		}
+
+		changes := request.Response.(*ResponseChanges)
+		for _, change := range changes.Values {
+			paths = append(paths, change.Path.String)
+		}
+
+		if changes.IsLastPage || len(changes.Values) == 0 {
+			return paths, nil
+		}
+
+		start = changes.NextPageStart
+	}
+}
+
+// GetExperts returns users and members of groups of expert rules matching
+// files changed in the pull request.
+func (server *SnobServer) GetExperts(selection *Selection) ([]string, error) {
+	if len(server.experts) == 0 {
+		return []string{}, nil
+	}
+
+	paths, err := selection.GetChanges()
+	if err != nil {
+		return nil, err
+	}
+
+	experts := []string{}
+	for _, rule := range server.experts {
+		if !rule.Matches(paths) {
+			continue
+		}
+
+		users := rule.Users
+		for _, group := range rule.Groups {
+			members, err := server.getCachedUsers(group)
+			if err != nil {
+				return nil, err
+			}
+
+			users = mergeUsers(users, members)
+		}
+
+		logger.Debugf("[experts %s]: %s", rule.Name, strings.Join(users, ", "))
+
+		experts = mergeUsers(experts, users)
+	}
+
+	return experts, nil
+}
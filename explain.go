@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type Explanation struct {
+	Project     string                        `json:"project"`
+	Repository  string                        `json:"repository"`
+	PullRequest string                        `json:"pull_request"`
+	Group       string                        `json:"group"`
+	Count       int                           `json:"count"`
+	Strategy    string                        `json:"strategy"`
+	Skipped     bool                          `json:"skipped"`
+	Groups      map[string][]string           `json:"groups,omitempty"`
+	Steps       []TraceStep                   `json:"steps,omitempty"`
+	Required    []string                      `json:"required,omitempty"`
+	Scores      map[string]float64            `json:"scores,omitempty"`
+	Signals     map[string]map[string]float64 `json:"signals,omitempty"`
+	Reviewers   []string                      `json:"reviewers"`
+}
+
+// ExplainReviewers runs selection for the pull request without adding
+// anybody and returns full trace of it.
+func (server *SnobServer) ExplainReviewers(
+	key *APIKey, usergroup string, pullRequestURL string,
+	options AssignOptions,
+) (*Explanation, error) {
+	if instance := server.getInstance(pullRequestURL); instance != server {
+		return instance.ExplainReviewers(
+			key, usergroup, pullRequestURL, options,
+		)
+	}
+
+	project, repository, pullRequest, err := server.backend.ParsePullRequestURL(
+		pullRequestURL,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	assignment, directives, err := server.preparePullRequest(
+		key, usergroup, project, repository, pullRequest, options,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	explanation := &Explanation{
+		Project:     assignment.Project,
+		Repository:  assignment.Repository,
+		PullRequest: assignment.PullRequest,
+		Group:       assignment.Group,
+		Skipped:     assignment.Skipped,
+		Reviewers:   []string{},
+	}
+
+	selection := server.NewSelection(
+		assignment.Project, assignment.Repository, assignment.PullRequest,
+		assignment.Info,
+	)
+
+	explanation.Count = server.getReviewersCount(
+		options, directives, selection.Rule,
+	)
+	explanation.Strategy = server.getRuleStrategy(selection)
+
+	if assignment.Skipped {
+		return explanation, nil
+	}
+
+	selection.Exclude(options.Excluded)
+	selection.Union = options.Union
+	selection.ExcludeGroups = options.ExcludeGroups
+	selection.DryRun = true
+
+	users, err := server.SelectReviewers(
+		selection, assignment.Group, explanation.Count,
+	)
+
+	explanation.Groups = selection.Trace.Groups
+	explanation.Steps = selection.Trace.Steps
+	explanation.Required = selection.Required
+	explanation.Scores = selection.Scores
+	explanation.Signals = selection.Signals
+
+	if err != nil {
+		return explanation, err
+	}
+
+	explanation.Reviewers = users
+
+	return explanation, nil
+}
+
+// handleExplain serves GET /v1/explain?url=<pull request>&group=<group>,
+// optional count parameter is the same as for assignment.
+func (server *SnobServer) handleExplain(
+	response http.ResponseWriter, request *http.Request,
+) {
+	request, ok := server.authorize(response, request, OperationGroups)
+	if !ok {
+		return
+	}
+
+	var (
+		query          = request.URL.Query()
+		pullRequestURL = query.Get("url")
+		usergroup      = query.Get("group")
+	)
+
+	if pullRequestURL == "" || usergroup == "" {
+		server.reportError(
+			response,
+			NewError(ErrorBadRequest, "url and group parameters are required"),
+			http.StatusBadRequest,
+		)
+		return
+	}
+
+	options, err := parseAssignOptions(request)
+	if err != nil {
+		server.reportError(response, err, getErrorStatus(err))
+		return
+	}
+
+	explanation, err := server.ExplainReviewers(
+		getRequestAPIKey(request), usergroup, pullRequestURL, options,
+	)
+	if err != nil && explanation == nil {
+		server.reportError(response, err, getErrorStatus(err))
+		return
+	}
+
+	var body struct {
+		*Explanation
+		Error string `json:"error,omitempty"`
+	}
+
+	body.Explanation = explanation
+
+	status := http.StatusOK
+	if err != nil {
+		body.Error = err.Error()
+		status = getErrorStatus(err)
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(status)
+
+	json.NewEncoder(response).Encode(body)
+}
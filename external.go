@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// ExternalStrategy delegates selection to external HTTP service, which
+// receives pull request metadata with candidates and returns chosen
+// reviewers. Fallback strategy is used if service fails.
+type ExternalStrategy struct {
+	URL      string
+	Client   *http.Client
+	Fallback Strategy
+}
+
+type ExternalConfig struct {
+	URL      string   `toml:"url"`
+	Timeout  Duration `toml:"timeout"`
+	Fallback string   `toml:"fallback"`
+}
+
+type ExternalRequest struct {
+	Project     string   `json:"project"`
+	Repository  string   `json:"repository"`
+	PullRequest string   `json:"pull_request"`
+	Title       string   `json:"title"`
+	Author      string   `json:"author"`
+	Count       int      `json:"count"`
+	Required    []string `json:"required"`
+	Candidates  []string `json:"candidates"`
+}
+
+type ExternalResponse struct {
+	Reviewers []string `json:"reviewers"`
+}
+
+func getExternalStrategy(config *Config) (*ExternalStrategy, error) {
+	external := config.External
+	if external.URL == "" {
+		return nil, fmt.Errorf("external.url is required for external strategy")
+	}
+
+	if external.Fallback == StrategyExternal {
+		return nil, fmt.Errorf("external.fallback can't be external")
+	}
+
+	fallback, err := getStrategy(config, external.Fallback)
+	if err != nil {
+		return nil, fmt.Errorf("external.fallback: %s", err)
+	}
+
+	return &ExternalStrategy{
+		URL:      external.URL,
+		Client:   &http.Client{Timeout: external.Timeout.Duration},
+		Fallback: fallback,
+	}, nil
+}
+
+func (strategy *ExternalStrategy) Select(
+	selection *Selection, users []string, count int,
+) ([]string, error) {
+	selected, err := strategy.callout(selection, users, count)
+	if err != nil {
+		logger.Warnf("external strategy failed, using fallback: %s", err)
+
+		return strategy.Fallback.Select(selection, users, count)
+	}
+
+	return selected, nil
+}
+
+func (strategy *ExternalStrategy) callout(
+	selection *Selection, users []string, count int,
+) ([]string, error) {
+	payload, err := json.Marshal(ExternalRequest{
+		Project:     selection.Project,
+		Repository:  selection.Repository,
+		PullRequest: selection.PullRequest,
+		Title:       selection.Info.Title,
+		Author:      selection.Info.Author.User.Name,
+		Count:       count,
+		Required:    selection.Required,
+		Candidates:  users,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := strategy.Client.Post(
+		strategy.URL, "application/json", bytes.NewReader(payload),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("service responded with %s", response.Status)
+	}
+
+	var result ExternalResponse
+
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response: %s", err)
+	}
+
+	// external service can only choose among candidates, everybody else
+	// was filtered out for a reason
+	selected := []string{}
+	for _, user := range result.Reviewers {
+		if !containsUser(users, user) {
+			logger.Warnf("external strategy returned non-candidate %s", user)
+			continue
+		}
+
+		selected = mergeUsers(selected, []string{user})
+	}
+
+	if len(selected) == 0 && len(users) > 0 {
+		return nil, fmt.Errorf("service returned no candidates")
+	}
+
+	return selected, nil
+}
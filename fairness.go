@@ -0,0 +1,54 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+)
+
+const SignalAuthorReviews = "author_reviews"
+
+type FairnessConfig struct {
+	// Recent is number of the author's last pull requests checked for
+	// reviewers.
+	Recent int `toml:"recent"`
+}
+
+// FairStrategy picks candidates who reviewed the least of the author's
+// recent pull requests according to assignment history, so the same
+// people don't review the same author over and over.
+type FairStrategy struct {
+	Recent int
+}
+
+func (strategy FairStrategy) Select(
+	selection *Selection, users []string, count int,
+) ([]string, error) {
+	counts := selection.server.history.CountAuthorReviewers(
+		selection.Info.Author.User.Name, strategy.Recent,
+	)
+
+	// Candidates are shuffled first, so ties are not always resolved in
+	// favor of the same users.
+	ranked := append([]string{}, users...)
+	rand.Shuffle(len(ranked), func(i, j int) {
+		ranked[i], ranked[j] = ranked[j], ranked[i]
+	})
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return counts[normalizeUser(ranked[i])] <
+			counts[normalizeUser(ranked[j])]
+	})
+
+	for _, user := range ranked {
+		reviews := float64(counts[normalizeUser(user)])
+
+		selection.Scores[user] = -reviews
+		selection.Signals[user] = map[string]float64{
+			SignalAuthorReviews: reviews,
+		}
+
+		logger.Debugf("[fair] %s: %.0f recent reviews of author", user, reviews)
+	}
+
+	return selectRankedUsers(ranked, count, selection.Required), nil
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// gerritMagicPrefix is prepended by Gerrit to every JSON response to
+// prevent XSSI.
+const gerritMagicPrefix = ")]}'"
+
+var (
+	reGerritURL = regexp.MustCompile(
+		`^https?://[^/]+/(?:#/)?c/(.+)/\+/(\d+)`,
+	)
+
+	// legacy URLs and bare identifiers don't have project, it's resolved
+	// by looking up the change
+	reGerritLegacyURL = regexp.MustCompile(`^https?://[^/]+/(?:#/)?c/(\d+)`)
+	reGerritChange    = regexp.MustCompile(`^(\d+|I[0-9a-f]{40})$`)
+)
+
+type GerritConfig struct {
+	URL  string `toml:"url"`
+	User string `toml:"user"`
+	Pass string `toml:"pass"`
+}
+
+// GerritBackend serves changes from Gerrit, Gerrit groups are used as
+// groups. Project path of change is split into project and repository by
+// last slash, so platform/tools becomes project platform and repository
+// tools.
+type GerritBackend struct {
+	config GerritConfig
+	client *http.Client
+}
+
+type ResponseGerritAccount struct {
+	Username string `json:"username"`
+}
+
+type ResponseGerritChange struct {
+	Project         string                             `json:"project"`
+	Branch          string                             `json:"branch"`
+	Subject         string                             `json:"subject"`
+	Status          string                             `json:"status"`
+	Number          int                                `json:"_number"`
+	Owner           ResponseGerritAccount              `json:"owner"`
+	Reviewers       map[string][]ResponseGerritAccount `json:"reviewers"`
+	CurrentRevision string                             `json:"current_revision"`
+	Revisions       map[string]struct {
+		Commit struct {
+			Message string `json:"message"`
+		} `json:"commit"`
+	} `json:"revisions"`
+	Labels map[string]struct {
+		Approved *ResponseGerritAccount `json:"approved"`
+	} `json:"labels"`
+}
+
+func NewGerritBackend(config GerritConfig, timeout time.Duration) *GerritBackend {
+	config.URL = strings.TrimRight(config.URL, "/")
+
+	return &GerritBackend{
+		config: config,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// ParsePullRequestURL accepts change URL, change number or Change-Id.
+func (backend *GerritBackend) ParsePullRequestURL(
+	url string,
+) (string, string, string, error) {
+	if matches := reGerritURL.FindStringSubmatch(url); len(matches) > 0 {
+		project, repository := splitGerritProject(matches[1])
+
+		return project, repository, matches[2], nil
+	}
+
+	var change string
+
+	if matches := reGerritLegacyURL.FindStringSubmatch(url); len(matches) > 0 {
+		change = matches[1]
+	} else if reGerritChange.MatchString(url) {
+		change = url
+	} else {
+		return "", "", "", NewError(ErrorBadURL, "wrong url")
+	}
+
+	var info ResponseGerritChange
+
+	err := backend.request("GET", "/changes/"+change, nil, &info)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	project, repository := splitGerritProject(info.Project)
+
+	return project, repository, fmt.Sprint(info.Number), nil
+}
+
+// GetGroupMembers returns members of Gerrit group including members of
+// included groups.
+func (backend *GerritBackend) GetGroupMembers(group string) ([]string, error) {
+	members := []ResponseGerritAccount{}
+
+	err := backend.request(
+		"GET", "/groups/"+url.PathEscape(group)+"/members/?recursive",
+		nil, &members,
+	)
+	if err != nil {
+		if getErrorCategory(err) == ErrorPullRequestNotFound {
+			return []string{}, NewError(
+				ErrorGroupNotFound, "group %q not found", group,
+			)
+		}
+
+		return []string{}, err
+	}
+
+	users := []string{}
+	for _, member := range members {
+		if member.Username != "" {
+			users = append(users, member.Username)
+		}
+	}
+
+	return users, nil
+}
+
+func (backend *GerritBackend) GetPullRequest(
+	project string, repository string, pullRequest string,
+) (*ResponsePullRequest, error) {
+	var change ResponseGerritChange
+
+	err := backend.request(
+		"GET",
+		backend.changePath(project, repository, pullRequest)+
+			"?o=DETAILED_ACCOUNTS&o=LABELS&o=CURRENT_REVISION&o=CURRENT_COMMIT",
+		nil, &change,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ResponsePullRequest{
+		State:       getGerritState(change.Status),
+		Title:       change.Subject,
+		Description: change.Revisions[change.CurrentRevision].Commit.Message,
+	}
+
+	info.Author.User.Name = change.Owner.Username
+	info.FromRef.DisplayID = change.Branch
+	info.Links.Self = []ResponseLink{{
+		Href: fmt.Sprintf(
+			"%s/c/%s/+/%d", backend.config.URL, change.Project, change.Number,
+		),
+	}}
+
+	approved := map[string]bool{}
+	for _, label := range change.Labels {
+		if label.Approved != nil {
+			approved[label.Approved.Username] = true
+		}
+	}
+
+	for _, reviewer := range change.Reviewers["REVIEWER"] {
+		participant := ResponseParticipant{
+			Role:     "REVIEWER",
+			Approved: approved[reviewer.Username],
+		}
+		participant.User.Name = reviewer.Username
+
+		info.Reviewers = append(info.Reviewers, participant)
+	}
+
+	return info, nil
+}
+
+func (backend *GerritBackend) GetPullRequestChanges(
+	project string, repository string, pullRequest string,
+) ([]string, error) {
+	files := map[string]interface{}{}
+
+	err := backend.request(
+		"GET",
+		backend.changePath(project, repository, pullRequest)+
+			"/revisions/current/files",
+		nil, &files,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	for path := range files {
+		// magic files like /COMMIT_MSG are not part of the tree
+		if !strings.HasPrefix(path, "/") {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths, nil
+}
+
+func (backend *GerritBackend) AddReviewers(
+	project string, repository string, pullRequest string,
+	info *ResponsePullRequest, users []string,
+) error {
+	users = excludeUsers(users, []string{info.Author.User.Name})
+
+	for _, user := range users {
+		err := backend.request(
+			"POST",
+			backend.changePath(project, repository, pullRequest)+"/reviewers",
+			map[string]string{"reviewer": user}, nil,
+		)
+		if err != nil {
+			return fmt.Errorf("can't add reviewer %s: %s", user, err)
+		}
+	}
+
+	return nil
+}
+
+func (backend *GerritBackend) RemoveReviewers(
+	project string, repository string, pullRequest string,
+	info *ResponsePullRequest, users []string,
+) error {
+	for _, user := range users {
+		err := backend.request(
+			"DELETE",
+			backend.changePath(project, repository, pullRequest)+
+				"/reviewers/"+url.PathEscape(user),
+			nil, nil,
+		)
+		if err != nil {
+			return fmt.Errorf("can't remove reviewer %s: %s", user, err)
+		}
+	}
+
+	return nil
+}
+
+func (backend *GerritBackend) changePath(
+	project string, repository string, pullRequest string,
+) string {
+	path := repository
+	if project != "" {
+		path = project + "/" + repository
+	}
+
+	return "/changes/" + url.PathEscape(path) + "~" + pullRequest
+}
+
+// request calls authenticated REST API, gopencils is not used because
+// Gerrit responses are not valid JSON without stripping magic prefix.
+func (backend *GerritBackend) request(
+	method string, path string, payload interface{}, result interface{},
+) error {
+	var body io.Reader
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+
+		body = bytes.NewReader(raw)
+	}
+
+	request, err := http.NewRequest(method, backend.config.URL+"/a"+path, body)
+	if err != nil {
+		return err
+	}
+
+	request.SetBasicAuth(backend.config.User, backend.config.Pass)
+	request.Header.Set("Accept", "application/json")
+
+	if payload != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := backend.client.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	reader := bufio.NewReader(response.Body)
+
+	if response.StatusCode >= 400 {
+		message, _ := ioutil.ReadAll(io.LimitReader(reader, 1024))
+
+		gerritError := &StashError{
+			Service:    "gerrit",
+			StatusCode: response.StatusCode,
+			Status:     response.Status,
+		}
+
+		if text := strings.TrimSpace(string(message)); text != "" {
+			gerritError.Messages = []string{text}
+		}
+
+		return gerritError
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	prefix, err := reader.Peek(len(gerritMagicPrefix))
+	if err == nil && string(prefix) == gerritMagicPrefix {
+		reader.ReadString('\n')
+	}
+
+	return json.NewDecoder(reader).Decode(result)
+}
+
+func splitGerritProject(path string) (string, string) {
+	index := strings.LastIndex(path, "/")
+	if index < 0 {
+		return "", path
+	}
+
+	return path[:index], path[index+1:]
+}
+
+// getGerritState maps change status to Stash pull request state.
+func getGerritState(status string) string {
+	switch status {
+	case "NEW":
+		return "OPEN"
+	case "ABANDONED":
+		return "DECLINED"
+	}
+
+	return status
+}
+
+func validateGerrit(config GerritConfig) error {
+	if config.URL == "" {
+		return fmt.Errorf("gerrit.url is required for gerrit backend")
+	}
+
+	if config.User == "" || config.Pass == "" {
+		return fmt.Errorf(
+			"gerrit.user and gerrit.pass are required for gerrit backend",
+		)
+	}
+
+	return nil
+}
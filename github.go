@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bndr/gopencils"
+)
+
+const (
+	defaultGitHubURL = "https://api.github.com"
+
+	githubPageSize = 100
+)
+
+var (
+	reGitHubURL = regexp.MustCompile(
+		`https?://[^/]+/([^/]+)/([^/]+)/pull/(\d+)`,
+	)
+)
+
+type GitHubConfig struct {
+	// URL of GitHub API, it should be set for GitHub Enterprise, like
+	// https://github.host/api/v3.
+	URL   string `toml:"url"`
+	Token string `toml:"token"`
+
+	// Organization of teams which are used as groups, group can also be
+	// given as organization/team.
+	Organization string `toml:"organization"`
+}
+
+// GitHubBackend serves pull requests from GitHub, teams are used as groups
+// and reviewers are requested through requested_reviewers API.
+type GitHubBackend struct {
+	api          *gopencils.Resource
+	organization string
+}
+
+type ResponseGitHubUser struct {
+	Login string `json:"login"`
+}
+
+type ResponseGitHubPullRequest struct {
+	State string             `json:"state"`
+	Title string             `json:"title"`
+	Body  string             `json:"body"`
+	User  ResponseGitHubUser `json:"user"`
+	Head  struct {
+		Ref string `json:"ref"`
+	} `json:"head"`
+	RequestedReviewers []ResponseGitHubUser `json:"requested_reviewers"`
+	HTMLURL            string               `json:"html_url"`
+}
+
+type ResponseGitHubFile struct {
+	Filename string `json:"filename"`
+}
+
+func NewGitHubBackend(config GitHubConfig, timeout time.Duration) *GitHubBackend {
+	url := config.URL
+	if url == "" {
+		url = defaultGitHubURL
+	}
+
+	return &GitHubBackend{
+		api: gopencils.Api(
+			strings.TrimRight(url, "/"),
+			&gopencils.BasicAuth{"snobs", config.Token},
+			&http.Client{Timeout: timeout},
+		),
+		organization: config.Organization,
+	}
+}
+
+func (backend *GitHubBackend) ParsePullRequestURL(
+	url string,
+) (string, string, string, error) {
+	matches := reGitHubURL.FindStringSubmatch(url)
+	if len(matches) == 0 {
+		return "", "", "", NewError(ErrorBadURL, "wrong url")
+	}
+
+	return matches[1], matches[2], matches[3], nil
+}
+
+// GetGroupMembers returns members of the team, group is either team slug
+// in configured organization or organization/team.
+func (backend *GitHubBackend) GetGroupMembers(group string) ([]string, error) {
+	organization, team := backend.organization, group
+	if index := strings.Index(group, "/"); index >= 0 {
+		organization, team = group[:index], group[index+1:]
+	}
+
+	if organization == "" {
+		return nil, NewError(
+			ErrorBadRequest,
+			"group %q should be organization/team, "+
+				"github.organization is not configured",
+			group,
+		)
+	}
+
+	users := []string{}
+
+	for page := 1; ; page++ {
+		members := []ResponseGitHubUser{}
+
+		request, err := backend.api.Res("orgs").Res(organization).
+			Res("teams").Res(team).Res("members", &members).
+			Get(getGitHubPage(page))
+
+		err = checkGitHubResponse(request, err)
+		if err != nil {
+			if getErrorCategory(err) == ErrorPullRequestNotFound {
+				return []string{}, NewError(
+					ErrorGroupNotFound, "group %q not found", group,
+				)
+			}
+
+			return []string{}, err
+		}
+
+		for _, member := range members {
+			users = append(users, member.Login)
+		}
+
+		if len(members) < githubPageSize {
+			return users, nil
+		}
+	}
+}
+
+func (backend *GitHubBackend) GetPullRequest(
+	project string, repository string, pullRequest string,
+) (*ResponsePullRequest, error) {
+	request, err := backend.pullsResource(project, repository).
+		Res(pullRequest, &ResponseGitHubPullRequest{}).
+		Get()
+
+	err = checkGitHubResponse(request, err)
+	if err != nil {
+		return nil, err
+	}
+
+	pull := request.Response.(*ResponseGitHubPullRequest)
+
+	info := &ResponsePullRequest{
+		State:       strings.ToUpper(pull.State),
+		Title:       pull.Title,
+		Description: pull.Body,
+	}
+
+	info.Author.User.Name = pull.User.Login
+	info.FromRef.DisplayID = pull.Head.Ref
+	info.Links.Self = []ResponseLink{{Href: pull.HTMLURL}}
+
+	for _, reviewer := range pull.RequestedReviewers {
+		participant := ResponseParticipant{Role: "REVIEWER"}
+		participant.User.Name = reviewer.Login
+
+		info.Reviewers = append(info.Reviewers, participant)
+	}
+
+	return info, nil
+}
+
+func (backend *GitHubBackend) GetPullRequestChanges(
+	project string, repository string, pullRequest string,
+) ([]string, error) {
+	paths := []string{}
+
+	for page := 1; ; page++ {
+		files := []ResponseGitHubFile{}
+
+		request, err := backend.pullsResource(project, repository).
+			Res(pullRequest).Res("files", &files).Get(getGitHubPage(page))
+
+		err = checkGitHubResponse(request, err)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			paths = append(paths, file.Filename)
+		}
+
+		if len(files) < githubPageSize {
+			return paths, nil
+		}
+	}
+}
+
+func (backend *GitHubBackend) AddReviewers(
+	project string, repository string, pullRequest string,
+	info *ResponsePullRequest, users []string,
+) error {
+	users = excludeUsers(users, []string{info.Author.User.Name})
+	if len(users) == 0 {
+		return nil
+	}
+
+	request, err := backend.pullsResource(project, repository).
+		Res(pullRequest).Res("requested_reviewers", &map[string]interface{}{}).
+		Post(map[string]interface{}{"reviewers": users})
+
+	return checkGitHubResponse(request, err)
+}
+
+func (backend *GitHubBackend) RemoveReviewers(
+	project string, repository string, pullRequest string,
+	info *ResponsePullRequest, users []string,
+) error {
+	// DELETE with body is not supported by gopencils, so the request is
+	// made directly.
+	body, err := json.Marshal(map[string]interface{}{"reviewers": users})
+	if err != nil {
+		return err
+	}
+
+	resource := backend.pullsResource(project, repository).
+		Res(pullRequest).Res("requested_reviewers", &map[string]interface{}{})
+
+	resource.Payload = strings.NewReader(string(body))
+
+	request, err := resource.Delete()
+
+	return checkGitHubResponse(request, err)
+}
+
+func (backend *GitHubBackend) pullsResource(
+	project string, repository string,
+) *gopencils.Resource {
+	return backend.api.Res("repos").Res(project).Res(repository).Res("pulls")
+}
+
+func getGitHubPage(page int) map[string]string {
+	return map[string]string{
+		"per_page": strconv.Itoa(githubPageSize),
+		"page":     strconv.Itoa(page),
+	}
+}
+
+// checkGitHubResponse turns HTTP error statuses into StashError, so they
+// are categorized the same way as Stash errors.
+func checkGitHubResponse(resource *gopencils.Resource, err error) error {
+	if resource == nil || resource.Raw == nil || resource.Raw.StatusCode < 400 {
+		return err
+	}
+
+	defer resource.Raw.Body.Close()
+
+	var body struct {
+		Message string `json:"message"`
+	}
+
+	githubError := &StashError{
+		Service:    "github",
+		StatusCode: resource.Raw.StatusCode,
+		Status:     resource.Raw.Status,
+	}
+
+	if json.NewDecoder(resource.Raw.Body).Decode(&body) == nil &&
+		body.Message != "" {
+		githubError.Messages = []string{body.Message}
+	}
+
+	return githubError
+}
+
+func validateGitHub(config GitHubConfig) error {
+	if config.Token == "" {
+		return fmt.Errorf("github.token is required for github backend")
+	}
+
+	return nil
+}
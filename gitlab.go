@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bndr/gopencils"
+)
+
+const (
+	gitlabPageSize = 100
+)
+
+var (
+	// project is namespace of the repository, which can be nested like
+	// group/subgroup, old URLs have no /-/ part.
+	reGitLabURL = regexp.MustCompile(
+		`https?://[^/]+/(.+)/([^/]+)/(?:-/)?merge_requests/(\d+)`,
+	)
+)
+
+type GitLabConfig struct {
+	URL   string `toml:"url"`
+	Token string `toml:"token"`
+}
+
+// GitLabBackend serves merge requests from GitLab, GitLab groups are used
+// as groups and reviewers are set by reviewer_ids of merge request.
+type GitLabBackend struct {
+	api *gopencils.Resource
+
+	// ids of users are needed to set reviewers, they don't change, so
+	// they are kept for the whole run.
+	mutex sync.Mutex
+	ids   map[string]int
+}
+
+type ResponseGitLabUser struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+}
+
+type ResponseGitLabMergeRequest struct {
+	State        string               `json:"state"`
+	Title        string               `json:"title"`
+	Description  string               `json:"description"`
+	SourceBranch string               `json:"source_branch"`
+	WebURL       string               `json:"web_url"`
+	Author       ResponseGitLabUser   `json:"author"`
+	Reviewers    []ResponseGitLabUser `json:"reviewers"`
+}
+
+type ResponseGitLabChanges struct {
+	Changes []struct {
+		NewPath string `json:"new_path"`
+	} `json:"changes"`
+}
+
+func NewGitLabBackend(config GitLabConfig, timeout time.Duration) *GitLabBackend {
+	return &GitLabBackend{
+		api: gopencils.Api(
+			strings.TrimRight(config.URL, "/")+"/api/v4",
+			&http.Client{
+				Timeout: timeout,
+				Transport: &headerTransport{
+					header: "PRIVATE-TOKEN",
+					value:  config.Token,
+					next:   http.DefaultTransport,
+				},
+			},
+		),
+		ids: map[string]int{},
+	}
+}
+
+func (backend *GitLabBackend) ParsePullRequestURL(
+	url string,
+) (string, string, string, error) {
+	matches := reGitLabURL.FindStringSubmatch(url)
+	if len(matches) == 0 {
+		return "", "", "", NewError(ErrorBadURL, "wrong url")
+	}
+
+	return matches[1], matches[2], matches[3], nil
+}
+
+// GetGroupMembers returns members of GitLab group including inherited
+// ones, group is given by its full path.
+func (backend *GitLabBackend) GetGroupMembers(group string) ([]string, error) {
+	users := []string{}
+
+	for page := 1; ; page++ {
+		members := []ResponseGitLabUser{}
+
+		request, err := backend.api.Res("groups").Res(url.PathEscape(group)).
+			Res("members").Res("all", &members).
+			Get(getGitLabPage(page))
+
+		err = checkGitLabResponse(request, err)
+		if err != nil {
+			if getErrorCategory(err) == ErrorPullRequestNotFound {
+				return []string{}, NewError(
+					ErrorGroupNotFound, "group %q not found", group,
+				)
+			}
+
+			return []string{}, err
+		}
+
+		backend.mutex.Lock()
+		for _, member := range members {
+			backend.ids[member.Username] = member.ID
+			users = append(users, member.Username)
+		}
+		backend.mutex.Unlock()
+
+		if len(members) < gitlabPageSize {
+			return users, nil
+		}
+	}
+}
+
+func (backend *GitLabBackend) GetPullRequest(
+	project string, repository string, pullRequest string,
+) (*ResponsePullRequest, error) {
+	mergeRequest, err := backend.getMergeRequest(
+		project, repository, pullRequest,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ResponsePullRequest{
+		State:       getGitLabState(mergeRequest.State),
+		Title:       mergeRequest.Title,
+		Description: mergeRequest.Description,
+	}
+
+	info.Author.User.Name = mergeRequest.Author.Username
+	info.FromRef.DisplayID = mergeRequest.SourceBranch
+	info.Links.Self = []ResponseLink{{Href: mergeRequest.WebURL}}
+
+	for _, reviewer := range mergeRequest.Reviewers {
+		participant := ResponseParticipant{Role: "REVIEWER"}
+		participant.User.Name = reviewer.Username
+
+		info.Reviewers = append(info.Reviewers, participant)
+	}
+
+	return info, nil
+}
+
+func (backend *GitLabBackend) GetPullRequestChanges(
+	project string, repository string, pullRequest string,
+) ([]string, error) {
+	request, err := backend.mergeRequestsResource(project, repository).
+		Res(pullRequest).Res("changes", &ResponseGitLabChanges{}).
+		Get()
+
+	err = checkGitLabResponse(request, err)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	for _, change := range request.Response.(*ResponseGitLabChanges).Changes {
+		paths = append(paths, change.NewPath)
+	}
+
+	return paths, nil
+}
+
+// AddReviewers sets reviewer_ids to current reviewers and given users,
+// since GitLab replaces the whole list.
+func (backend *GitLabBackend) AddReviewers(
+	project string, repository string, pullRequest string,
+	info *ResponsePullRequest, users []string,
+) error {
+	users = excludeUsers(users, []string{info.Author.User.Name})
+	if len(users) == 0 {
+		return nil
+	}
+
+	return backend.updateReviewers(
+		project, repository, pullRequest,
+		func(current []string) []string {
+			return mergeUsers(current, users)
+		},
+	)
+}
+
+func (backend *GitLabBackend) RemoveReviewers(
+	project string, repository string, pullRequest string,
+	info *ResponsePullRequest, users []string,
+) error {
+	return backend.updateReviewers(
+		project, repository, pullRequest,
+		func(current []string) []string {
+			return excludeUsers(current, users)
+		},
+	)
+}
+
+// updateReviewers reads current reviewers right before update, so
+// reviewers added since pull request info was fetched are not lost.
+func (backend *GitLabBackend) updateReviewers(
+	project string, repository string, pullRequest string,
+	update func([]string) []string,
+) error {
+	mergeRequest, err := backend.getMergeRequest(
+		project, repository, pullRequest,
+	)
+	if err != nil {
+		return err
+	}
+
+	current := []string{}
+	for _, reviewer := range mergeRequest.Reviewers {
+		backend.mutex.Lock()
+		backend.ids[reviewer.Username] = reviewer.ID
+		backend.mutex.Unlock()
+
+		current = append(current, reviewer.Username)
+	}
+
+	ids := []int{}
+	for _, user := range update(current) {
+		id, err := backend.getUserID(user)
+		if err != nil {
+			return err
+		}
+
+		ids = append(ids, id)
+	}
+
+	request, err := backend.mergeRequestsResource(project, repository).
+		Res(pullRequest, &map[string]interface{}{}).
+		Put(map[string]interface{}{"reviewer_ids": ids})
+
+	return checkGitLabResponse(request, err)
+}
+
+func (backend *GitLabBackend) getMergeRequest(
+	project string, repository string, pullRequest string,
+) (*ResponseGitLabMergeRequest, error) {
+	request, err := backend.mergeRequestsResource(project, repository).
+		Res(pullRequest, &ResponseGitLabMergeRequest{}).
+		Get()
+
+	err = checkGitLabResponse(request, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return request.Response.(*ResponseGitLabMergeRequest), nil
+}
+
+func (backend *GitLabBackend) getUserID(user string) (int, error) {
+	backend.mutex.Lock()
+	id, ok := backend.ids[user]
+	backend.mutex.Unlock()
+
+	if ok {
+		return id, nil
+	}
+
+	found := []ResponseGitLabUser{}
+
+	request, err := backend.api.Res("users", &found).
+		Get(map[string]string{"username": user})
+
+	err = checkGitLabResponse(request, err)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(found) == 0 {
+		return 0, NewError(ErrorBadRequest, "gitlab user %q not found", user)
+	}
+
+	backend.mutex.Lock()
+	backend.ids[user] = found[0].ID
+	backend.mutex.Unlock()
+
+	return found[0].ID, nil
+}
+
+func (backend *GitLabBackend) mergeRequestsResource(
+	project string, repository string,
+) *gopencils.Resource {
+	return backend.api.Res("projects").
+		Res(url.PathEscape(project + "/" + repository)).
+		Res("merge_requests")
+}
+
+// getGitLabState maps merge request state to Stash one.
+func getGitLabState(state string) string {
+	switch state {
+	case "opened":
+		return "OPEN"
+	case "closed":
+		return "DECLINED"
+	}
+
+	return strings.ToUpper(state)
+}
+
+func getGitLabPage(page int) map[string]string {
+	return map[string]string{
+		"per_page": strconv.Itoa(gitlabPageSize),
+		"page":     strconv.Itoa(page),
+	}
+}
+
+func checkGitLabResponse(resource *gopencils.Resource, err error) error {
+	if resource == nil || resource.Raw == nil || resource.Raw.StatusCode < 400 {
+		return err
+	}
+
+	defer resource.Raw.Body.Close()
+
+	// message is either a string or an object with messages per field
+	var body struct {
+		Message interface{} `json:"message"`
+		Error   string      `json:"error"`
+	}
+
+	gitlabError := &StashError{
+		Service:    "gitlab",
+		StatusCode: resource.Raw.StatusCode,
+		Status:     resource.Raw.Status,
+	}
+
+	if json.NewDecoder(resource.Raw.Body).Decode(&body) == nil {
+		switch {
+		case body.Message != nil:
+			gitlabError.Messages = []string{fmt.Sprint(body.Message)}
+		case body.Error != "":
+			gitlabError.Messages = []string{body.Error}
+		}
+	}
+
+	return gitlabError
+}
+
+func validateGitLab(config GitLabConfig) error {
+	if config.URL == "" {
+		return fmt.Errorf("gitlab.url is required for gitlab backend")
+	}
+
+	if config.Token == "" {
+		return fmt.Errorf("gitlab.token is required for gitlab backend")
+	}
+
+	return nil
+}
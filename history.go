@@ -0,0 +1,522 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+type HistoryEntry struct {
+	Time        time.Time `json:"time"`
+	Project     string    `json:"project"`
+	Repository  string    `json:"repository"`
+	PullRequest string    `json:"pull_request"`
+	Author      string    `json:"author"`
+	Group       string    `json:"group"`
+	Reviewers   []string  `json:"reviewers"`
+
+	// Undo entry records reviewers removed by undo of the previous
+	// assignment to the same pull request.
+	Undo bool `json:"undo,omitempty"`
+
+	// Target is time of assignment which is undone, reroll records undo
+	// after the new assignment, so it's not the previous one.
+	Target *time.Time `json:"target,omitempty"`
+
+	undone bool
+}
+
+// History keeps assignments made during retention period in memory and
+// appends them to JSON lines file if path is given, so the history
+// survives restarts. Zero retention keeps all assignments.
+type History struct {
+	path      string
+	retention time.Duration
+	mutex     sync.RWMutex
+	entries   []HistoryEntry
+}
+
+func OpenHistory(path string, retention time.Duration) (*History, error) {
+	history := &History{
+		path:      path,
+		retention: retention,
+		entries:   []HistoryEntry{},
+	}
+
+	if path == "" {
+		return history, nil
+	}
+
+	err := history.read(time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// ReadSince reads assignments made since given time from the file under
+// shared lock, so assignments recorded by other processes and ones which
+// are older than retention are included.
+func (history *History) ReadSince(since time.Time) (*History, error) {
+	result := &History{path: history.path, entries: []HistoryEntry{}}
+
+	err := result.read(since)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// read appends entries of the file which are not older than since.
+func (history *History) read(since time.Time) error {
+	file, err := os.Open(history.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	// lock is released when file is closed
+	defer file.Close()
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_SH)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry HistoryEntry
+
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return err
+		}
+
+		if entry.Time.Before(since) {
+			continue
+		}
+
+		history.append(entry)
+	}
+
+	return scanner.Err()
+}
+
+func (history *History) Add(entry HistoryEntry) error {
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	if history.path != "" {
+		file, err := os.OpenFile(
+			history.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644,
+		)
+		if err != nil {
+			return err
+		}
+
+		// lock is released when file is closed
+		defer file.Close()
+
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+		if err != nil {
+			return err
+		}
+
+		err = json.NewEncoder(file).Encode(entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	history.append(entry)
+
+	return nil
+}
+
+// append adds entry to memory, undo entry marks its target or the last
+// assignment to the same pull request as undone.
+func (history *History) append(entry HistoryEntry) {
+	if entry.Undo {
+		for index := len(history.entries) - 1; index >= 0; index-- {
+			previous := &history.entries[index]
+			if previous.Undo || !previous.isSamePullRequest(entry) {
+				continue
+			}
+
+			if entry.Target != nil && !previous.Time.Equal(*entry.Target) {
+				continue
+			}
+
+			previous.undone = true
+			break
+		}
+	}
+
+	history.entries = append(history.entries, entry)
+
+	history.prune(time.Now())
+}
+
+// prune drops entries older than retention, entries are appended in order
+// of time, so they are dropped from the start.
+func (history *History) prune(now time.Time) {
+	if history.retention <= 0 || len(history.entries) == 0 {
+		return
+	}
+
+	cutoff := now.Add(-history.retention)
+	if !history.entries[0].Time.Before(cutoff) {
+		return
+	}
+
+	index := 0
+	for index < len(history.entries) &&
+		history.entries[index].Time.Before(cutoff) {
+		index++
+	}
+
+	history.entries = append([]HistoryEntry{}, history.entries[index:]...)
+}
+
+// LastAssignment returns the most recent assignment to the pull request,
+// which is not undone yet.
+func (history *History) LastAssignment(
+	project, repository, pullRequest string,
+) (HistoryEntry, bool) {
+	history.mutex.RLock()
+	defer history.mutex.RUnlock()
+
+	target := HistoryEntry{
+		Project:     project,
+		Repository:  repository,
+		PullRequest: pullRequest,
+	}
+
+	for index := len(history.entries) - 1; index >= 0; index-- {
+		entry := history.entries[index]
+		if entry.Undo || !entry.isSamePullRequest(target) {
+			continue
+		}
+
+		return entry, !entry.undone
+	}
+
+	return HistoryEntry{}, false
+}
+
+func (entry HistoryEntry) isSamePullRequest(other HistoryEntry) bool {
+	return strings.EqualFold(entry.Project, other.Project) &&
+		strings.EqualFold(entry.Repository, other.Repository) &&
+		entry.PullRequest == other.PullRequest
+}
+
+// Since returns assignments made since given time, undone assignments are
+// not returned.
+func (history *History) Since(since time.Time) []HistoryEntry {
+	history.mutex.RLock()
+	defer history.mutex.RUnlock()
+
+	entries := []HistoryEntry{}
+	for _, entry := range history.entries {
+		if entry.Undo || entry.undone {
+			continue
+		}
+
+		if !entry.Time.Before(since) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// CountAssignments returns number of assignments per normalized reviewer
+// name since given time.
+func (history *History) CountAssignments(since time.Time) map[string]int {
+	counts := map[string]int{}
+	for _, entry := range history.Since(since) {
+		for _, reviewer := range entry.Reviewers {
+			counts[normalizeUser(reviewer)]++
+		}
+	}
+
+	return counts
+}
+
+// CountAuthorReviewers returns number of the author's last pull requests
+// each reviewer was assigned to, per normalized reviewer name. At most
+// limit pull requests are checked, undone assignments are skipped.
+func (history *History) CountAuthorReviewers(
+	author string, limit int,
+) map[string]int {
+	history.mutex.RLock()
+	defer history.mutex.RUnlock()
+
+	var (
+		counts       = map[string]int{}
+		pullRequests = map[string]UserSet{}
+	)
+
+	for index := len(history.entries) - 1; index >= 0; index-- {
+		entry := history.entries[index]
+		if entry.Undo || entry.undone {
+			continue
+		}
+
+		if !strings.EqualFold(entry.Author, author) {
+			continue
+		}
+
+		name := strings.ToLower(
+			entry.Project + "/" + entry.Repository + "#" + entry.PullRequest,
+		)
+
+		reviewers, ok := pullRequests[name]
+		if !ok {
+			if len(pullRequests) >= limit {
+				continue
+			}
+
+			reviewers = NewUserSet()
+			pullRequests[name] = reviewers
+		}
+
+		reviewers.Add(entry.Reviewers...)
+	}
+
+	for _, reviewers := range pullRequests {
+		for reviewer := range reviewers {
+			counts[reviewer]++
+		}
+	}
+
+	return counts
+}
+
+// HistoryQuery filters history entries, empty fields match everything.
+type HistoryQuery struct {
+	Reviewer    string
+	Author      string
+	Project     string
+	Repository  string
+	PullRequest string
+	Group       string
+	From        time.Time
+	To          time.Time
+
+	// Allowed additionally filters entries by repository, it's used to
+	// hide repositories which are not allowed for caller API key.
+	Allowed func(project, repository string) bool
+
+	Offset int
+	Limit  int
+}
+
+// HistoryRecord is entry returned by history API.
+type HistoryRecord struct {
+	HistoryEntry
+	Undone bool `json:"undone,omitempty"`
+}
+
+type HistoryPage struct {
+	Total   int             `json:"total"`
+	Offset  int             `json:"offset"`
+	Limit   int             `json:"limit"`
+	Entries []HistoryRecord `json:"entries"`
+}
+
+// Query returns page of entries matching query, newest first, undo
+// entries and undone assignments are included.
+func (history *History) Query(query HistoryQuery) HistoryPage {
+	history.mutex.RLock()
+	defer history.mutex.RUnlock()
+
+	page := HistoryPage{
+		Offset:  query.Offset,
+		Limit:   query.Limit,
+		Entries: []HistoryRecord{},
+	}
+
+	for index := len(history.entries) - 1; index >= 0; index-- {
+		entry := history.entries[index]
+		if !query.matches(entry) {
+			continue
+		}
+
+		page.Total++
+
+		if page.Total <= query.Offset || len(page.Entries) >= query.Limit {
+			continue
+		}
+
+		page.Entries = append(page.Entries, HistoryRecord{
+			HistoryEntry: entry,
+			Undone:       entry.undone,
+		})
+	}
+
+	return page
+}
+
+func (query HistoryQuery) matches(entry HistoryEntry) bool {
+	switch {
+	case query.Project != "" && !strings.EqualFold(query.Project, entry.Project),
+		query.Repository != "" &&
+			!strings.EqualFold(query.Repository, entry.Repository),
+		query.PullRequest != "" && query.PullRequest != entry.PullRequest,
+		query.Group != "" && query.Group != entry.Group,
+		query.Author != "" &&
+			normalizeUser(query.Author) != normalizeUser(entry.Author),
+		!query.From.IsZero() && entry.Time.Before(query.From),
+		!query.To.IsZero() && !entry.Time.Before(query.To):
+		return false
+	}
+
+	if query.Allowed != nil && !query.Allowed(entry.Project, entry.Repository) {
+		return false
+	}
+
+	if query.Reviewer == "" {
+		return true
+	}
+
+	for _, reviewer := range entry.Reviewers {
+		if normalizeUser(reviewer) == normalizeUser(query.Reviewer) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseHistoryQuery reads query from URL parameters reviewer, author,
+// project, repository, pull_request, group, from, to, offset and limit.
+func parseHistoryQuery(values url.Values) (HistoryQuery, error) {
+	query := HistoryQuery{
+		Reviewer:    values.Get("reviewer"),
+		Author:      values.Get("author"),
+		Project:     values.Get("project"),
+		Repository:  values.Get("repository"),
+		PullRequest: values.Get("pull_request"),
+		Group:       values.Get("group"),
+		Limit:       defaultHistoryLimit,
+	}
+
+	var err error
+
+	for _, bound := range []struct {
+		name  string
+		value *time.Time
+	}{
+		{"from", &query.From},
+		{"to", &query.To},
+	} {
+		raw := values.Get(bound.name)
+		if raw == "" {
+			continue
+		}
+
+		*bound.value, err = parseHistoryTime(raw)
+		if err != nil {
+			return query, NewError(
+				ErrorBadRequest,
+				"invalid %s %q, expected YYYY-MM-DD or RFC3339",
+				bound.name, raw,
+			)
+		}
+	}
+
+	for _, number := range []struct {
+		name  string
+		value *int
+	}{
+		{"offset", &query.Offset},
+		{"limit", &query.Limit},
+	} {
+		raw := values.Get(number.name)
+		if raw == "" {
+			continue
+		}
+
+		*number.value, err = strconv.Atoi(raw)
+		if err != nil || *number.value < 0 {
+			return query, NewError(
+				ErrorBadRequest, "invalid %s %q, expected number",
+				number.name, raw,
+			)
+		}
+	}
+
+	switch {
+	case query.Limit == 0:
+		query.Limit = defaultHistoryLimit
+
+	case query.Limit > maxHistoryLimit:
+		query.Limit = maxHistoryLimit
+	}
+
+	return query, nil
+}
+
+func parseHistoryTime(value string) (time.Time, error) {
+	moment, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err == nil {
+		return moment, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// handleHistory serves GET /v1/history, see parseHistoryQuery for filters.
+func (server *SnobServer) handleHistory(
+	response http.ResponseWriter, request *http.Request,
+) {
+	if request.Method != "GET" {
+		server.reportError(
+			response,
+			NewError(ErrorBadRequest, "method %s is not allowed", request.Method),
+			http.StatusMethodNotAllowed,
+		)
+		return
+	}
+
+	request, ok := server.authorize(response, request, OperationGroups)
+	if !ok {
+		return
+	}
+
+	query, err := parseHistoryQuery(request.URL.Query())
+	if err != nil {
+		server.reportError(response, err, getErrorStatus(err))
+		return
+	}
+
+	if key := getRequestAPIKey(request); key != nil {
+		query.Allowed = key.AllowsRepository
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+
+	err = json.NewEncoder(response).Encode(server.history.Query(query))
+	if err != nil {
+		server.reportError(response, err, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// InstanceConfig describes additional Stash server served by the same
+// daemon, everything except connection settings is taken from the main
+// config.
+type InstanceConfig struct {
+	Stash string `toml:"stash"`
+	User  string `toml:"user"`
+	Pass  string `toml:"pass"`
+	Token string `toml:"token"`
+}
+
+func validateInstances(config *Config) error {
+	if len(config.Instances) == 0 {
+		return nil
+	}
+
+	if config.Backend != BackendStash {
+		return fmt.Errorf("instances are supported only by stash backend")
+	}
+
+	names := []string{}
+	for name := range config.Instances {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	hosts := map[string]string{}
+
+	stashURL, err := getStashURL(config.Stash)
+	if err == nil {
+		hosts[getURLHost(stashURL)] = "stash"
+	}
+
+	for _, name := range names {
+		instance := config.Instances[name]
+
+		if instance.Stash == "" || instance.User == "" ||
+			(instance.Pass == "" && instance.Token == "") {
+			return fmt.Errorf(
+				"instances.%s: stash, user and pass or token are required", name,
+			)
+		}
+
+		stashURL, err := getStashURL(instance.Stash)
+		if err != nil {
+			return fmt.Errorf("instances.%s: %s", name, err)
+		}
+
+		host := getURLHost(stashURL)
+		if other, ok := hosts[host]; ok {
+			return fmt.Errorf(
+				"instances.%s: host %s is already used by %s", name, host, other,
+			)
+		}
+
+		hosts[host] = "instances." + name
+	}
+
+	return nil
+}
+
+// setInstances builds server for every additional Stash instance. Instance
+// servers have own Stash client and groups cache, but share history,
+// availability, audit log and everything else with the main server.
+func (server *SnobServer) setInstances() error {
+	instances := map[string]*SnobServer{}
+
+	for name, instanceConfig := range server.config.Instances {
+		config := *server.config
+		config.Stash = instanceConfig.Stash
+		config.User = instanceConfig.User
+		config.Pass = instanceConfig.Pass
+		config.Token = instanceConfig.Token
+		config.Instances = nil
+
+		instance := &SnobServer{
+			config:       &config,
+			configPath:   server.configPath,
+			cache:        NewGroupCache(),
+			metrics:      server.metrics,
+			keys:         server.keys,
+			experts:      server.experts,
+			routes:       server.routes,
+			staticGroups: server.staticGroups,
+			limiter:      server.limiter,
+			jira:         server.jira,
+			org:          server.org,
+			history:      server.history,
+			availability: server.availability,
+			auditLog:     server.auditLog,
+			oidc:         server.oidc,
+			statsd:       server.statsd,
+			events:       server.events,
+			outbox:       server.outbox,
+			maintenance:  server.maintenance,
+			rotation:     server.rotation,
+		}
+
+		instance.cache.SetTTL(config.CacheTTL.Duration)
+
+		err := instance.setStashClient()
+		if err != nil {
+			return fmt.Errorf("instance %s: %s", name, err)
+		}
+
+		instance.backend = &StashBackend{server: instance}
+
+		instances[name] = instance
+	}
+
+	server.instances = instances
+
+	return nil
+}
+
+// getInstance returns server of Stash instance which serves the pull
+// request by host of its URL, main server is returned if there is no
+// such instance.
+func (server *SnobServer) getInstance(pullRequestURL string) *SnobServer {
+	if len(server.instances) == 0 {
+		return server
+	}
+
+	host := getURLHost(pullRequestURL)
+
+	for _, instance := range server.instances {
+		stashURL, err := getStashURL(instance.config.Stash)
+		if err != nil {
+			continue
+		}
+
+		if host != "" && host == getURLHost(stashURL) {
+			return instance
+		}
+	}
+
+	return server
+}
+
+func getURLHost(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(parsed.Host)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bndr/gopencils"
+)
+
+var (
+	reJiraIssue = regexp.MustCompile(`\b[A-Z][A-Z0-9_]+-[0-9]+\b`)
+)
+
+type JiraConfig struct {
+	URL  string `toml:"url"`
+	User string `toml:"user"`
+	Pass string `toml:"pass"`
+}
+
+type JiraClient struct {
+	api *gopencils.Resource
+}
+
+type ResponseJiraIssue struct {
+	Fields struct {
+		Components []struct {
+			ID string `json:"id"`
+		} `json:"components"`
+	} `json:"fields"`
+}
+
+type ResponseJiraComponent struct {
+	Name string `json:"name"`
+	Lead struct {
+		Name string `json:"name"`
+	} `json:"lead"`
+}
+
+// NewJiraClient returns nil client if jira is not configured.
+func NewJiraClient(config JiraConfig, timeout time.Duration) *JiraClient {
+	if config.URL == "" {
+		return nil
+	}
+
+	return &JiraClient{
+		api: gopencils.Api(
+			strings.TrimRight(config.URL, "/")+"/rest/api/2",
+			&gopencils.BasicAuth{config.User, config.Pass},
+			&http.Client{Timeout: timeout},
+		),
+	}
+}
+
+// getJiraIssues finds issue keys like PROJ-123 in given texts, usually
+// title, description and branch name of the pull request.
+func getJiraIssues(texts ...string) []string {
+	issues := []string{}
+	for _, text := range texts {
+		issues = mergeUsers(issues, reJiraIssue.FindAllString(text, -1))
+	}
+
+	return issues
+}
+
+// GetComponentLeads returns leads of all components of given issues.
+// Jira failures are logged and not returned, because Jira is optional
+// source of reviewers and should not block assignment.
+func (jira *JiraClient) GetComponentLeads(issues []string) []string {
+	leads := []string{}
+	for _, issue := range issues {
+		request, err := jira.api.Res("issue").Res(
+			issue, &ResponseJiraIssue{},
+		).Get(map[string]string{"fields": "components"})
+
+		err = checkJiraResponse(request, err)
+		if err != nil {
+			logger.Errorf("can't get jira issue %s: %s", issue, err)
+			continue
+		}
+
+		info := request.Response.(*ResponseJiraIssue)
+		for _, component := range info.Fields.Components {
+			request, err := jira.api.Res("component").Res(
+				component.ID, &ResponseJiraComponent{},
+			).Get()
+
+			err = checkJiraResponse(request, err)
+			if err != nil {
+				logger.Errorf(
+					"can't get jira component %s: %s", component.ID, err,
+				)
+				continue
+			}
+
+			lead := request.Response.(*ResponseJiraComponent).Lead.Name
+			if lead == "" {
+				continue
+			}
+
+			logger.Debugf(
+				"[jira %s]: component %s lead: %s",
+				issue, request.Response.(*ResponseJiraComponent).Name, lead,
+			)
+
+			leads = mergeUsers(leads, []string{lead})
+		}
+	}
+
+	return leads
+}
+
+func checkJiraResponse(resource *gopencils.Resource, err error) error {
+	if resource == nil || resource.Raw == nil || resource.Raw.StatusCode < 400 {
+		return err
+	}
+
+	resource.Raw.Body.Close()
+
+	return fmt.Errorf("jira responded with %s", resource.Raw.Status)
+}
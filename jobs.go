@@ -0,0 +1,576 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	JobPending   = "pending"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// jobQueueSize limits number of jobs waiting for worker, callers get rate
+// limited error when it's reached.
+const jobQueueSize = 1000
+
+var jobsBucket = []byte("jobs")
+
+type JobsConfig struct {
+	Workers   int      `toml:"workers"`
+	Retention Duration `toml:"retention"`
+
+	// File is BoltDB database jobs are kept in, so they survive restarts,
+	// jobs are kept in memory only if it's not set.
+	File string `toml:"file"`
+
+	// Jobs failed because Stash is not available are retried with
+	// exponential backoff until they are older than max_age.
+	Backoff    Duration `toml:"backoff"`
+	MaxBackoff Duration `toml:"max_backoff"`
+	MaxAge     Duration `toml:"max_age"`
+}
+
+// Job is assignment requested with ?async=1, it's done in background and
+// its status is reported by GET /jobs/%id%. Pull request is given either
+// by URL or by coordinates.
+type Job struct {
+	ID       string     `json:"id"`
+	Status   string     `json:"status"`
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	Group       string        `json:"group"`
+	URL         string        `json:"url,omitempty"`
+	Project     string        `json:"project,omitempty"`
+	Repository  string        `json:"repository,omitempty"`
+	PullRequest string        `json:"pull_request,omitempty"`
+	Options     AssignOptions `json:"options"`
+	Caller      string        `json:"caller,omitempty"`
+	Remote      string        `json:"remote,omitempty"`
+
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+
+	Result *APIResponse `json:"result,omitempty"`
+}
+
+// JobQueue runs jobs by fixed number of workers and keeps finished jobs
+// for retention period, so their status can be checked. Every change of job
+// is written to database once it's opened.
+type JobQueue struct {
+	config JobsConfig
+	run    func(*Job) (APIResponse, error)
+	db     *bolt.DB
+
+	mutex sync.Mutex
+	jobs  map[string]*Job
+	queue chan *Job
+
+	// closed queue doesn't run jobs, they are held until it's opened
+	// again, so jobs are not run by both processes during upgrade.
+	closed bool
+	held   []*Job
+
+	// running are jobs taken by workers, Close waits for them, so their
+	// results are saved before database is handed over.
+	running sync.WaitGroup
+}
+
+// NewJobQueue returns queue which keeps jobs in memory until Open.
+func NewJobQueue(
+	config JobsConfig, run func(*Job) (APIResponse, error),
+) *JobQueue {
+	return &JobQueue{
+		config: config,
+		run:    run,
+		jobs:   map[string]*Job{},
+		queue:  make(chan *Job, jobQueueSize),
+	}
+}
+
+// Open opens database of jobs and schedules jobs held while queue was
+// closed. It's done only by daemon, database is locked while it's open.
+func (queue *JobQueue) Open() error {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	if queue.config.File != "" && queue.db == nil {
+		db, err := bolt.Open(
+			queue.config.File, 0600, &bolt.Options{Timeout: time.Second},
+		)
+		if err != nil {
+			return err
+		}
+
+		err = db.Update(func(tx *bolt.Tx) error {
+			bucket, err := tx.CreateBucketIfNotExists(jobsBucket)
+			if err != nil {
+				return err
+			}
+
+			return bucket.ForEach(func(id []byte, data []byte) error {
+				var job Job
+
+				err := json.Unmarshal(data, &job)
+				if err != nil {
+					return fmt.Errorf("invalid job %s: %s", id, err)
+				}
+
+				if _, ok := queue.jobs[job.ID]; !ok {
+					queue.jobs[job.ID] = &job
+				}
+
+				return nil
+			})
+		})
+		if err != nil {
+			db.Close()
+			return err
+		}
+
+		queue.db = db
+	}
+
+	queue.closed = false
+
+	for _, job := range queue.held {
+		queue.schedule(job)
+	}
+
+	queue.held = nil
+
+	return nil
+}
+
+// Close stops running jobs and closes database, so another process can
+// open it. Jobs which are already running are finished and saved first,
+// otherwise that process would run them again. Pending jobs are kept in
+// database and run by that process.
+func (queue *JobQueue) Close() error {
+	queue.mutex.Lock()
+	queue.closed = true
+	queue.mutex.Unlock()
+
+	queue.running.Wait()
+
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	if queue.db == nil {
+		return nil
+	}
+
+	err := queue.db.Close()
+	queue.db = nil
+
+	return err
+}
+
+// Submit stores the job, queues it and returns its id.
+func (queue *JobQueue) Submit(job Job) (string, error) {
+	job.ID = getRandomToken()
+	job.Status = JobPending
+	job.Created = time.Now()
+	job.NextAttempt = job.Created
+
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	if queue.closed {
+		return "", NewError(
+			ErrorMaintenance, "jobs are handed over to new process",
+		)
+	}
+
+	queue.prune(job.Created)
+
+	err := queue.save(&job)
+	if err != nil {
+		return "", err
+	}
+
+	// sending must not block, workers need the lock to finish jobs
+	select {
+	case queue.queue <- &job:
+	default:
+		queue.remove([]string{job.ID})
+
+		return "", NewError(ErrorRateLimited, "job queue is full")
+	}
+
+	queue.jobs[job.ID] = &job
+
+	return job.ID, nil
+}
+
+// Get returns copy of the job, so it can be encoded while job is running.
+func (queue *JobQueue) Get(id string) (Job, bool) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	job, ok := queue.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+
+	return *job, true
+}
+
+// Run starts workers and schedules jobs which were pending on previous
+// run, workers run until process exits.
+func (queue *JobQueue) Run() {
+	for worker := 0; worker < queue.config.Workers; worker++ {
+		go func() {
+			for job := range queue.queue {
+				if queue.hold(job) {
+					continue
+				}
+
+				result, err := queue.run(job)
+				queue.finish(job, result, err)
+
+				queue.running.Done()
+			}
+		}()
+	}
+
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	pending := []*Job{}
+	for _, job := range queue.jobs {
+		if job.Status == JobPending {
+			pending = append(pending, job)
+		}
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].Created.Before(pending[j].Created)
+	})
+
+	for _, job := range pending {
+		queue.schedule(job)
+	}
+}
+
+// hold keeps the job aside if queue is closed, otherwise job is counted as
+// running until it's finished.
+func (queue *JobQueue) hold(job *Job) bool {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	if queue.closed {
+		queue.held = append(queue.held, job)
+	} else {
+		queue.running.Add(1)
+	}
+
+	return queue.closed
+}
+
+// finish records result of the job, jobs failed because Stash is not
+// available are scheduled again unless they are too old.
+func (queue *JobQueue) finish(job *Job, result APIResponse, err error) {
+	now := time.Now()
+
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	job.Attempts++
+	job.Result = &result
+
+	switch {
+	case err == nil:
+		job.Status = JobSucceeded
+		job.LastError = ""
+
+	case isRetryableError(err) &&
+		now.Sub(job.Created) < queue.config.MaxAge.Duration:
+		job.LastError = err.Error()
+		job.NextAttempt = now.Add(queue.getBackoff(job.Attempts))
+
+		logger.Warnf(
+			"job %s failed, attempt %d, retrying at %s: %s",
+			job.ID, job.Attempts, job.NextAttempt.Format(time.RFC3339), err,
+		)
+
+	default:
+		job.Status = JobFailed
+		job.LastError = err.Error()
+	}
+
+	if job.Status != JobPending {
+		job.Finished = &now
+	}
+
+	err = queue.save(job)
+	if err != nil {
+		logger.Errorf("can't save job %s: %s", job.ID, err)
+	}
+
+	if job.Status == JobPending {
+		queue.schedule(job)
+	}
+}
+
+// schedule hands job to workers when its next attempt is due.
+func (queue *JobQueue) schedule(job *Job) {
+	time.AfterFunc(time.Until(job.NextAttempt), func() {
+		queue.queue <- job
+	})
+}
+
+func (queue *JobQueue) getBackoff(attempts int) time.Duration {
+	backoff := queue.config.Backoff.Duration
+	for attempt := 1; attempt < attempts; attempt++ {
+		backoff *= 2
+		if backoff >= queue.config.MaxBackoff.Duration {
+			return queue.config.MaxBackoff.Duration
+		}
+	}
+
+	return backoff
+}
+
+// save writes the job to database, it fails if database is handed over to
+// new process, so changes are not dropped silently.
+func (queue *JobQueue) save(job *Job) error {
+	if queue.db == nil {
+		if queue.closed && queue.config.File != "" {
+			return NewError(
+				ErrorMaintenance, "jobs are handed over to new process",
+			)
+		}
+
+		return nil
+	}
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	return queue.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Put([]byte(job.ID), data)
+	})
+}
+
+func (queue *JobQueue) prune(now time.Time) {
+	expired := []string{}
+	for id, job := range queue.jobs {
+		if job.Finished != nil &&
+			now.Sub(*job.Finished) > queue.config.Retention.Duration {
+			expired = append(expired, id)
+		}
+	}
+
+	if len(expired) > 0 {
+		queue.remove(expired)
+	}
+}
+
+func (queue *JobQueue) remove(ids []string) {
+	for _, id := range ids {
+		delete(queue.jobs, id)
+	}
+
+	if queue.db == nil {
+		return
+	}
+
+	err := queue.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+		for _, id := range ids {
+			err := bucket.Delete([]byte(id))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("can't remove jobs: %s", err)
+	}
+}
+
+// isRetryableError reports whether job can succeed later without changes,
+// because it failed due to Stash outage or concurrent changes.
+func isRetryableError(err error) bool {
+	switch getErrorCategory(err) {
+	case ErrorStashUnreachable, ErrorStashTimeout, ErrorVersionConflict,
+		ErrorRateLimited, ErrorMaintenance:
+		return true
+	}
+
+	return false
+}
+
+func validateJobs(config JobsConfig) error {
+	if config.Workers <= 0 {
+		return fmt.Errorf("jobs.workers should be positive")
+	}
+
+	if config.Retention.Duration <= 0 {
+		return fmt.Errorf("jobs.retention should be positive")
+	}
+
+	if config.Backoff.Duration <= 0 ||
+		config.MaxBackoff.Duration < config.Backoff.Duration {
+		return fmt.Errorf(
+			"jobs.backoff should be positive and not greater than " +
+				"jobs.max_backoff",
+		)
+	}
+
+	if config.MaxAge.Duration <= 0 {
+		return fmt.Errorf("jobs.max_age should be positive")
+	}
+
+	return nil
+}
+
+// runJob does assignment of the job in the same way as synchronous request
+// does it.
+func (server *SnobServer) runJob(job *Job) (APIResponse, error) {
+	defer server.hold()()
+
+	key, err := server.getJobKey(job)
+	if err != nil {
+		return APIResponse{
+			Error: &APIError{Code: getErrorCategory(err), Message: err.Error()},
+		}, err
+	}
+
+	var assignment *Assignment
+
+	if job.URL != "" {
+		assignment, err = server.AssignReviewers(
+			key, job.Group, job.URL, job.Options,
+		)
+	} else {
+		assignment, err = server.assignPullRequest(
+			key, job.Group, job.Project, job.Repository, job.PullRequest,
+			job.Options,
+		)
+	}
+	if err != nil {
+		return APIResponse{
+			Error: &APIError{Code: getErrorCategory(err), Message: err.Error()},
+		}, err
+	}
+
+	if assignment.Changed() {
+		entry := assignment.AuditEntry()
+		entry.Caller = job.Caller
+		entry.Remote = job.Remote
+		entry.Details = "async job " + job.ID
+
+		server.audit(nil, entry)
+	}
+
+	_, body := getAssignmentResponse(assignment)
+
+	return body, nil
+}
+
+// getJobKey returns key the job was submitted with, job can't run if the
+// key is revoked or renamed since then, because nil key is not restricted
+// at all. Login sessions are not restricted by repositories or groups, so
+// their jobs run with the same unrestricted key.
+func (server *SnobServer) getJobKey(job *Job) (*APIKey, error) {
+	if job.Caller == "" {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(job.Caller, "oidc:") {
+		return &APIKey{Name: job.Caller}, nil
+	}
+
+	key := server.getKey(job.Caller)
+	if key == nil {
+		return nil, NewError(
+			ErrorForbidden, "key %s which submitted the job no longer exists",
+			job.Caller,
+		)
+	}
+
+	return key, nil
+}
+
+// submitJob queues assignment instead of doing it while caller waits and
+// responds with id of the job.
+func (server *SnobServer) submitJob(
+	response http.ResponseWriter, request *http.Request, job Job,
+) {
+	if key := getRequestAPIKey(request); key != nil {
+		job.Caller = key.Name
+	}
+
+	job.Remote = request.RemoteAddr
+
+	id, err := server.jobs.Submit(job)
+	if err != nil {
+		server.reportError(response, err, getErrorStatus(err))
+		return
+	}
+
+	writeResponse(response, http.StatusAccepted, APIResponse{
+		Success: true,
+		Job:     id,
+	})
+}
+
+// handleJob serves GET /jobs/%id%, jobs submitted with API key are visible
+// only with the same key.
+func (server *SnobServer) handleJob(
+	response http.ResponseWriter, request *http.Request,
+) {
+	if request.Method != "GET" {
+		server.reportError(
+			response,
+			NewError(ErrorBadRequest, "method %s is not allowed", request.Method),
+			http.StatusMethodNotAllowed,
+		)
+		return
+	}
+
+	request, ok := server.authorize(response, request, OperationGroups)
+	if !ok {
+		return
+	}
+
+	id := strings.TrimPrefix(request.URL.Path, "/jobs/")
+
+	job, ok := server.jobs.Get(id)
+
+	key := getRequestAPIKey(request)
+	if ok && job.Caller != "" && (key == nil || key.Name != job.Caller) {
+		ok = false
+	}
+
+	if !ok {
+		server.reportError(
+			response,
+			NewError(ErrorJobNotFound, "job %q is not found", id),
+			http.StatusNotFound,
+		)
+		return
+	}
+
+	job.Remote = ""
+
+	response.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(response).Encode(job)
+}
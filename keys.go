@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	OperationGroups    = "groups"
+	OperationReviewers = "reviewers"
+	OperationCache     = "cache"
+	OperationConfig    = "config"
+	OperationReplay    = "replay"
+
+	// OperationAvailability allows to pause assignments of any user.
+	OperationAvailability = "availability"
+
+	// OperationAdmin is kept for compatibility with keys configured before
+	// roles, it grants every operation of admin role.
+	OperationAdmin = "admin"
+)
+
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+// roleOperations lists operations granted by each role, every role
+// includes operations of the roles below it.
+var roleOperations = map[string][]string{
+	RoleViewer: {
+		OperationGroups,
+	},
+	RoleOperator: {
+		OperationGroups, OperationReviewers, OperationCache,
+		OperationAvailability,
+	},
+	RoleAdmin: {
+		OperationGroups, OperationReviewers, OperationCache,
+		OperationAvailability, OperationConfig, OperationReplay,
+	},
+}
+
+type contextKey int
+
+const (
+	contextKeyAPIKey contextKey = iota
+	contextKeyRequestID
+)
+
+type APIKey struct {
+	Name         string
+	Key          string
+	Roles        []string
+	Operations   []string
+	Repositories []string
+	Groups       []string
+	Quota        int64
+	QuotaPeriod  time.Duration
+
+	mutex       sync.Mutex
+	windowStart time.Time
+	used        int64
+}
+
+type KeyConfig struct {
+	Key          string   `toml:"key"`
+	Roles        []string `toml:"roles"`
+	Operations   []string `toml:"operations"`
+	Repositories []string `toml:"repositories"`
+	Groups       []string `toml:"groups"`
+	Quota        int64    `toml:"quota"`
+	QuotaPeriod  Duration `toml:"quota_period"`
+}
+
+func getAPIKeys(config map[string]KeyConfig) ([]*APIKey, error) {
+	names := []string{}
+	for name := range config {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	keys := []*APIKey{}
+	for _, name := range names {
+		key, err := getAPIKey(name, config[name])
+		if err != nil {
+			return nil, fmt.Errorf("keys.%s: %s", name, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func getAPIKey(name string, config KeyConfig) (*APIKey, error) {
+	if config.Key == "" {
+		return nil, fmt.Errorf("key is required")
+	}
+
+	key := &APIKey{
+		Name:         name,
+		Key:          config.Key,
+		Roles:        config.Roles,
+		Repositories: config.Repositories,
+		Groups:       config.Groups,
+		Quota:        config.Quota,
+		QuotaPeriod:  config.QuotaPeriod.Duration,
+	}
+
+	if key.QuotaPeriod <= 0 {
+		key.QuotaPeriod = time.Hour
+	}
+
+	var err error
+
+	key.Operations, err = getRoleOperations(config.Roles, config.Operations)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(key.Operations) == 0 {
+		return nil, fmt.Errorf(
+			"at least one of roles or operations is required",
+		)
+	}
+
+	err = validateRepositoryPatterns(key.Repositories)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// getRoleOperations returns operations granted by roles together with
+// explicitly listed operations.
+func getRoleOperations(roles []string, operations []string) ([]string, error) {
+	granted := []string{}
+
+	for _, role := range roles {
+		roleGranted, ok := roleOperations[role]
+		if !ok {
+			return nil, fmt.Errorf("unknown role %q", role)
+		}
+
+		granted = mergeOperations(granted, roleGranted)
+	}
+
+	for _, operation := range operations {
+		switch operation {
+		case OperationGroups, OperationReviewers, OperationCache,
+			OperationAvailability, OperationConfig, OperationReplay:
+			granted = mergeOperations(granted, []string{operation})
+
+		case OperationAdmin:
+			granted = mergeOperations(granted, roleOperations[RoleAdmin])
+
+		default:
+			return nil, fmt.Errorf("unknown operation %q", operation)
+		}
+	}
+
+	return granted, nil
+}
+
+func mergeOperations(operations []string, other []string) []string {
+	for _, operation := range other {
+		found := false
+		for _, existing := range operations {
+			if existing == operation {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			operations = append(operations, operation)
+		}
+	}
+
+	return operations
+}
+
+func (key *APIKey) AllowsOperation(operation string) bool {
+	for _, allowed := range key.Operations {
+		if allowed == operation {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (key *APIKey) AllowsRepository(project, repository string) bool {
+	if len(key.Repositories) == 0 {
+		return true
+	}
+
+	_, ok := matchRepository(key.Repositories, project+"/"+repository)
+
+	return ok
+}
+
+// AllowsGroup reports whether key can use the group, key which doesn't
+// list groups can use any.
+func (key *APIKey) AllowsGroup(group string) bool {
+	if len(key.Groups) == 0 {
+		return true
+	}
+
+	return containsUser(key.Groups, group)
+}
+
+// checkGroupAccess checks that caller key, if any, is scoped to the group.
+func checkGroupAccess(key *APIKey, group string) error {
+	if key != nil && !key.AllowsGroup(group) {
+		return NewError(
+			ErrorForbidden, "key %s is not allowed to use group %s",
+			key.Name, group,
+		)
+	}
+
+	return nil
+}
+
+// Take consumes one request from the key quota, quota is counted in fixed
+// windows of QuotaPeriod length.
+func (key *APIKey) Take() bool {
+	if key.Quota <= 0 {
+		return true
+	}
+
+	key.mutex.Lock()
+	defer key.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(key.windowStart) >= key.QuotaPeriod {
+		key.windowStart = now
+		key.used = 0
+	}
+
+	if key.used >= key.Quota {
+		return false
+	}
+
+	key.used++
+
+	return true
+}
+
+// authenticate finds key given as bearer token or, for clients which can't
+// set headers like webhooks of other tools, as ?token= query parameter.
+func (server *SnobServer) authenticate(request *http.Request) (*APIKey, error) {
+	var secret string
+
+	authorization := request.Header.Get("Authorization")
+	switch {
+	case strings.HasPrefix(authorization, "Bearer "):
+		secret = strings.TrimPrefix(authorization, "Bearer ")
+
+	case request.URL.Query().Get("token") != "":
+		secret = request.URL.Query().Get("token")
+
+	case server.oidc != nil:
+		return nil, NewError(
+			ErrorUnauthorized, "api key or login session is required",
+		)
+
+	default:
+		return nil, NewError(ErrorUnauthorized, "api key is required")
+	}
+
+	for _, key := range server.keys {
+		if subtle.ConstantTimeCompare([]byte(key.Key), []byte(secret)) == 1 {
+			return key, nil
+		}
+	}
+
+	return nil, NewError(ErrorUnauthorized, "invalid api key")
+}
+
+// authorize checks that caller is allowed to perform given operation and
+// attaches caller key to the request context. Caller is identified by API
+// key or, if there is no Authorization header, by login session. Access is
+// not restricted only if neither keys nor OIDC login are configured.
+func (server *SnobServer) authorize(
+	response http.ResponseWriter, request *http.Request, operation string,
+) (*http.Request, bool) {
+	var session *Session
+	if request.Header.Get("Authorization") == "" {
+		session = server.oidc.GetSession(request)
+	}
+
+	if len(server.keys) == 0 && server.oidc == nil {
+		return request, true
+	}
+
+	var (
+		key *APIKey
+		err error
+	)
+
+	if session != nil {
+		key = session.APIKey()
+	} else {
+		key, err = server.authenticate(request)
+		if err != nil {
+			response.Header().Set("WWW-Authenticate", "Bearer")
+			server.reportError(response, err, http.StatusUnauthorized)
+			return request, false
+		}
+	}
+
+	if !key.AllowsOperation(operation) {
+		server.reportError(
+			response,
+			NewError(
+				ErrorForbidden, "key %s is not allowed to use %s",
+				key.Name, operation,
+			),
+			http.StatusForbidden,
+		)
+		return request, false
+	}
+
+	if !key.Take() {
+		server.reportError(
+			response,
+			NewError(
+				ErrorQuotaExceeded, "key %s exceeded quota of %d per %s",
+				key.Name, key.Quota, key.QuotaPeriod,
+			),
+			http.StatusTooManyRequests,
+		)
+		return request, false
+	}
+
+	return request.WithContext(
+		context.WithValue(request.Context(), contextKeyAPIKey, key),
+	), true
+}
+
+// getKey returns key by its name, nil if there is no such key anymore.
+func (server *SnobServer) getKey(name string) *APIKey {
+	for _, key := range server.keys {
+		if key.Name == name {
+			return key
+		}
+	}
+
+	return nil
+}
+
+func getRequestAPIKey(request *http.Request) *APIKey {
+	key, _ := request.Context().Value(contextKeyAPIKey).(*APIKey)
+	return key
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuthorizeOIDCWithoutKeys(t *testing.T) {
+	provider := &OIDCProvider{sessionKey: []byte("secret")}
+
+	server := &SnobServer{metrics: NewMetrics(), oidc: provider}
+
+	session := provider.encodeSession(&Session{
+		User:    "alice",
+		Roles:   []string{RoleAdmin},
+		Expires: time.Now().Add(time.Hour),
+	})
+
+	tests := []struct {
+		name    string
+		header  string
+		cookie  string
+		allowed bool
+	}{
+		{"no session and no key", "", "", false},
+		{"unknown key", "Bearer secret", "", false},
+		{"forged session", "", "e30.forged", false},
+		{"valid session", "", session, true},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest("GET", "/v1/config", nil)
+		if test.header != "" {
+			request.Header.Set("Authorization", test.header)
+		}
+
+		if test.cookie != "" {
+			request.AddCookie(&http.Cookie{Name: sessionCookie, Value: test.cookie})
+		}
+
+		response := httptest.NewRecorder()
+
+		_, allowed := server.authorize(response, request, OperationConfig)
+		if allowed != test.allowed {
+			t.Errorf("%s: allowed %t, want %t", test.name, allowed, test.allowed)
+		}
+
+		if !test.allowed && response.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want 401", test.name, response.Code)
+		}
+	}
+}
+
+func TestAuthorizeWithoutKeysAndOIDC(t *testing.T) {
+	server := &SnobServer{metrics: NewMetrics()}
+
+	request := httptest.NewRequest("GET", "/v1/config", nil)
+
+	_, allowed := server.authorize(
+		httptest.NewRecorder(), request, OperationConfig,
+	)
+	if !allowed {
+		t.Errorf("request is denied, access should not be restricted")
+	}
+}
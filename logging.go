@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+	LogLevelWarn  = "warn"
+	LogLevelError = "error"
+
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+var logLevels = map[string]int{
+	LogLevelDebug: 0,
+	LogLevelInfo:  1,
+	LogLevelWarn:  2,
+	LogLevelError: 3,
+}
+
+// LogFields are attached to every record of logger, like remote address
+// or pull request coordinates.
+type LogFields map[string]interface{}
+
+type logOutput struct {
+	mutex  sync.Mutex
+	writer io.Writer
+	level  int
+	format string
+}
+
+// Logger writes leveled records as text or JSON lines, loggers derived by
+// With share output and settings with their parent.
+type Logger struct {
+	output *logOutput
+	fields LogFields
+}
+
+// logger is used by the whole process, it's configured by log_level and
+// log_format once config is loaded.
+var logger = &Logger{
+	output: &logOutput{
+		writer: os.Stderr,
+		level:  logLevels[LogLevelInfo],
+		format: LogFormatText,
+	},
+}
+
+func configureLogging(config *Config) {
+	logger.output.mutex.Lock()
+	defer logger.output.mutex.Unlock()
+
+	logger.output.level = logLevels[config.LogLevel]
+	logger.output.format = config.LogFormat
+}
+
+func validateLogging(config *Config) error {
+	if _, ok := logLevels[config.LogLevel]; !ok {
+		return fmt.Errorf(
+			"log_level should be debug, info, warn or error, got %q",
+			config.LogLevel,
+		)
+	}
+
+	if config.LogFormat != LogFormatText && config.LogFormat != LogFormatJSON {
+		return fmt.Errorf(
+			"log_format should be text or json, got %q", config.LogFormat,
+		)
+	}
+
+	return nil
+}
+
+func (logger *Logger) With(fields LogFields) *Logger {
+	merged := LogFields{}
+	for key, value := range logger.fields {
+		merged[key] = value
+	}
+
+	for key, value := range fields {
+		merged[key] = value
+	}
+
+	return &Logger{output: logger.output, fields: merged}
+}
+
+// WithPullRequest adds pull request coordinates to records.
+func (logger *Logger) WithPullRequest(
+	project string, repository string, pullRequest string,
+) *Logger {
+	return logger.With(LogFields{
+		"project":      project,
+		"repository":   repository,
+		"pull_request": pullRequest,
+	})
+}
+
+func (logger *Logger) Debugf(format string, args ...interface{}) {
+	logger.write(LogLevelDebug, format, args)
+}
+
+func (logger *Logger) Infof(format string, args ...interface{}) {
+	logger.write(LogLevelInfo, format, args)
+}
+
+func (logger *Logger) Warnf(format string, args ...interface{}) {
+	logger.write(LogLevelWarn, format, args)
+}
+
+func (logger *Logger) Errorf(format string, args ...interface{}) {
+	logger.write(LogLevelError, format, args)
+}
+
+func (logger *Logger) write(level string, format string, args []interface{}) {
+	output := logger.output
+
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+
+	if logLevels[level] < output.level {
+		return
+	}
+
+	var (
+		now     = time.Now().UTC().Format(time.RFC3339Nano)
+		message = fmt.Sprintf(format, args...)
+	)
+
+	if output.format == LogFormatJSON {
+		record := map[string]interface{}{}
+		for key, value := range logger.fields {
+			record[key] = value
+		}
+
+		record["time"] = now
+		record["level"] = level
+		record["message"] = message
+
+		json.NewEncoder(output.writer).Encode(record)
+
+		return
+	}
+
+	line := &strings.Builder{}
+	fmt.Fprintf(line, "%s %-5s %s", now, strings.ToUpper(level), message)
+
+	keys := []string{}
+	for key := range logger.fields {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Fprintf(line, " %s=%v", key, logger.fields[key])
+	}
+
+	fmt.Fprintln(output.writer, line.String())
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+type SMTPConfig struct {
+	Address string `toml:"address"`
+	From    string `toml:"from"`
+	User    string `toml:"user"`
+	Pass    string `toml:"pass"`
+}
+
+func sendMail(
+	config SMTPConfig, to []string, subject string, body string,
+) error {
+	address := config.Address
+	if address == "" {
+		return errors.New("smtp.address is not configured")
+	}
+
+	from := config.From
+	if from == "" {
+		return errors.New("smtp.from is not configured")
+	}
+
+	var auth smtp.Auth
+	if config.User != "" {
+		host, _, _ := net.SplitHostPort(address)
+
+		auth = smtp.PlainAuth("", config.User, config.Pass, host)
+	}
+
+	message := &bytes.Buffer{}
+	fmt.Fprintf(message, "From: %s\r\n", from)
+	fmt.Fprintf(message, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(message, "Subject: %s\r\n", subject)
+	fmt.Fprintf(message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(message, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(message, "\r\n%s", strings.Replace(body, "\n", "\r\n", -1))
+
+	return smtp.SendMail(address, auth, from, to, message.Bytes())
+}
@@ -1,156 +1,719 @@
 package main
 
 import (
+	"crypto/tls"
 	"encoding/json"
+	"fmt"
 	"log"
+	"math"
+	"math/rand"
+	"net"
 	"net/http"
-	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/BurntSushi/toml"
 	"github.com/bndr/gopencils"
 	"github.com/docopt/docopt-go"
-	"github.com/zazab/zhash"
+	"golang.org/x/sync/errgroup"
 )
 
 const (
-	usage = `Snobs 1.0
+	version = "1.0"
+
+	usage = `Snobs ` + version + `
 
 Usage:
     snobs [options]
+    snobs add <url> <group> [options]
+    snobs audit verify [options]
+    snobs sync-reviewers [options]
+    snobs doctor [--repo <repo>] [options]
 
 Options:
-    -c <config>   use specified configuration file
-                  [default: /etc/snobs/snobs.conf].
+    -c <config>     use specified configuration file
+                    [default: /etc/snobs/snobs.conf].
+    --repo <repo>   check pull request write permission on repository
+                    PROJECT/repo.
 `
 )
 
-var (
-	reStashURL = regexp.MustCompile(
-		`(https?://.*/)` +
-			`(users|projects)/([^/]+)` +
-			`/repos/([^/]+)` +
-			`/pull-requests/(\d+)`)
-)
-
 type SnobServer struct {
-	config zhash.Hash
-	api    *gopencils.Resource
-	cache  map[string][]string
+	config       *Config
+	configPath   string
+	api          *gopencils.Resource
+	settingsAPI  *gopencils.Resource
+	stashURL     string
+	httpClient   *http.Client
+	cache        *GroupCache
+	metrics      *Metrics
+	keys         []*APIKey
+	experts      []ExpertRule
+	routes       []PathRoute
+	allowlist    []*net.IPNet
+	staticGroups map[string]StaticGroup
+	limiter      *RateLimiter
+	jira         *JiraClient
+	org          *OrgChart
+	history      *History
+	availability *AvailabilityStore
+	auditLog     *AuditLog
+	oidc         *OIDCProvider
+	statsd       *StatsD
+	events       *Events
+	outbox       *Outbox
+	maintenance  *Maintenance
+	jobs         *JobQueue
+	rotation     *RotationStore
+	version      stashVersion
+	backend      Backend
+	instances    map[string]*SnobServer
+	tenants      map[string]*SnobServer
+
+	// reload guards config and clients built from it, see hold.
+	reload sync.RWMutex
 }
 
 type ResponseUsers struct {
 	Users []struct {
 		Name string `json:"name"`
 	} `json:"values"`
+	IsLastPage    bool `json:"isLastPage"`
+	NextPageStart int  `json:"nextPageStart"`
 }
 
 type ResponsePullRequest struct {
-	Version float64 `json:"version"`
-	Author  struct {
+	ID          int64   `json:"id"`
+	Version     float64 `json:"version"`
+	State       string  `json:"state"`
+	Title       string  `json:"title"`
+	Description string  `json:"description"`
+	Author      struct {
 		User struct {
 			Name string `json:"name"`
 		} `json:"user"`
 	} `json:"author"`
+	FromRef struct {
+		DisplayID string `json:"displayId"`
+	} `json:"fromRef"`
+	Reviewers    []ResponseParticipant `json:"reviewers"`
+	Participants []ResponseParticipant `json:"participants"`
+	Links        struct {
+		Self []ResponseLink `json:"self"`
+	} `json:"links"`
+}
+
+type ResponseLink struct {
+	Href string `json:"href"`
+}
+
+// GetURL returns web URL of the pull request, empty if it's unknown.
+func (info *ResponsePullRequest) GetURL() string {
+	if len(info.Links.Self) == 0 {
+		return ""
+	}
+
+	return info.Links.Self[0].Href
+}
+
+type ResponseParticipant struct {
+	User struct {
+		Name string `json:"name"`
+	} `json:"user"`
+	Role     string `json:"role"`
+	Approved bool   `json:"approved"`
+	Status   string `json:"status"`
 }
 
 func main() {
-	args, err := docopt.Parse(usage, nil, true, "blah", false, true)
+	args, err := docopt.Parse(usage, nil, true, version, false, true)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	rand.Seed(time.Now().UnixNano())
+
 	var (
 		configPath = args["-c"].(string)
 	)
 
+	if args["doctor"].(bool) {
+		repository, _ := args["--repo"].(string)
+
+		err = runDoctor(configPath, repository)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
 	config, err := getConfig(configPath)
 	if err != nil {
 		log.Fatalf("can't load config: %s", err.Error())
 	}
 
+	configureLogging(config)
+
+	if args["audit"].(bool) {
+		verifyAudit(config.AuditFile, config.AuditKey)
+		return
+	}
+
+	if args["sync-reviewers"].(bool) {
+		err = runSyncReviewers(config)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	if args["add"].(bool) {
+		err = runAdd(config, args["<url>"].(string), args["<group>"].(string))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
 	server, err := NewSnobServer(config)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	server.configPath = configPath
+
+	err = server.OpenStores()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	err = server.ListenHTTP()
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
-func NewSnobServer(config zhash.Hash) (*SnobServer, error) {
+func NewSnobServer(config *Config) (*SnobServer, error) {
 	server := &SnobServer{}
-	server.cache = map[string][]string{}
+	server.cache = NewGroupCache()
+	server.metrics = NewMetrics()
 
 	err := server.SetConfig(config)
 	if err != nil {
 		return nil, err
 	}
 
-	var (
-		stashHost, _ = server.config.GetString("stash")
-		stashUser, _ = server.config.GetString("user")
-		stashPass, _ = server.config.GetString("pass")
+	server.cache.SetTTL(server.config.CacheTTL.Duration)
+
+	timeout := server.config.StashTimeout.Duration
+
+	server.limiter, err = getRateLimiter(server.config.RateLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	server.statsd, err = NewStatsD(server.config.StatsD)
+	if err != nil {
+		return nil, err
+	}
+
+	server.events, err = NewEvents(server.config.Events)
+	if err != nil {
+		return nil, err
+	}
+
+	err = server.setStashClient()
+	if err != nil {
+		return nil, err
+	}
+
+	server.backend, err = server.getBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	server.jira = NewJiraClient(server.config.Jira, timeout)
+	server.org = NewOrgChart(server.config.LDAP, timeout)
+
+	server.oidc, err = NewOIDCProvider(server.config.OIDC, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	server.history, err = OpenHistory(
+		server.config.HistoryFile, server.config.HistoryRetention.Duration,
 	)
+	if err != nil {
+		return nil, fmt.Errorf("can't open history: %s", err)
+	}
 
-	server.api = gopencils.Api(
-		"http://"+stashHost+"/rest/api/1.0",
-		&gopencils.BasicAuth{stashUser, stashPass},
+	server.availability, err = OpenAvailabilityStore(
+		server.config.AvailabilityFile,
 	)
+	if err != nil {
+		return nil, fmt.Errorf("can't open availability store: %s", err)
+	}
+
+	server.availability.SetConfigured(server.config.Availability.Unavailable)
+
+	server.auditLog, err = OpenAuditLog(
+		server.config.AuditFile, server.config.AuditKey,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("can't open audit log: %s", err)
+	}
+
+	server.outbox, err = OpenOutbox(
+		server.config.Outbox, server.deliverNotification,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("can't open outbox: %s", err)
+	}
+
+	server.rotation, err = OpenRotationStore(server.config.RotationFile)
+	if err != nil {
+		return nil, fmt.Errorf("can't open rotation store: %s", err)
+	}
+
+	server.maintenance, err = OpenMaintenance(server.config.Maintenance)
+	if err != nil {
+		return nil, fmt.Errorf("can't open maintenance queue: %s", err)
+	}
+
+	server.jobs = NewJobQueue(server.config.Jobs, server.runJob)
+
+	err = server.setInstances()
+	if err != nil {
+		return nil, err
+	}
+
+	server.tenants = map[string]*SnobServer{}
+	for name, tenantConfig := range config.tenants {
+		server.tenants[name], err = NewSnobServer(tenantConfig)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %s", name, err)
+		}
+	}
 
 	return server, nil
 }
 
-func (server *SnobServer) SetConfig(config zhash.Hash) error {
-	params := []string{
-		"listen", "stash", "user", "pass",
+// OpenStores opens databases which are locked while they are open, it's
+// done only by daemon, so command line runs don't conflict with it.
+func (server *SnobServer) OpenStores() error {
+	err := server.jobs.Open()
+	if err != nil {
+		return fmt.Errorf("can't open jobs: %s", err)
 	}
 
-	for _, paramName := range params {
-		_, err := config.GetString(paramName)
+	for name, tenant := range server.tenants {
+		err := tenant.OpenStores()
 		if err != nil {
-			return err
+			return fmt.Errorf("tenant %s: %s", name, err)
 		}
 	}
 
-	_, err := config.GetStringSlice("intersect")
+	return nil
+}
+
+// CloseStores releases databases opened by OpenStores before they are
+// handed over to new process.
+func (server *SnobServer) CloseStores() {
+	err := server.jobs.Close()
+	if err != nil {
+		logger.Errorf("can't close jobs: %s", err)
+	}
+
+	for _, tenant := range server.tenants {
+		tenant.CloseStores()
+	}
+}
+
+// setStashClient builds Stash API client according to current config.
+func (server *SnobServer) setStashClient() error {
+	timeout := server.config.StashTimeout.Duration
+
+	stashURL, err := getStashURL(server.config.Stash)
+	if err != nil {
+		return err
+	}
+
+	transport, err := getStashTransport(server.config)
 	if err != nil {
 		return err
 	}
 
+	options := []interface{}{}
+
+	// personal access token is sent as bearer token instead of password
+	if server.config.Token != "" {
+		transport = &headerTransport{
+			header: "Authorization",
+			value:  "Bearer " + server.config.Token,
+			next:   transport,
+		}
+	} else {
+		options = append(
+			options,
+			&gopencils.BasicAuth{server.config.User, server.config.Pass},
+		)
+	}
+
+	httpClient := &http.Client{
+		Timeout: timeout,
+		Transport: &throttledTransport{
+			limiter: server.limiter,
+			timeout: timeout,
+			next: &timedTransport{
+				statsd: server.statsd,
+				next:   transport,
+			},
+		},
+	}
+
+	server.stashURL = stashURL + "/rest/api/1.0"
+	server.httpClient = httpClient
+	server.api = gopencils.Api(server.stashURL, append(options, httpClient)...)
+
+	// default reviewers are managed by bundled plugin with its own API
+	server.settingsAPI = gopencils.Api(
+		stashURL+"/rest/default-reviewers/1.0",
+		append(options, httpClient)...,
+	)
+
+	return nil
+}
+
+// SetConfig applies validated config, see getConfig.
+func (server *SnobServer) SetConfig(config *Config) error {
+	keys, err := getAPIKeys(config.Keys)
+	if err != nil {
+		return err
+	}
+
+	experts, err := getExpertRules(config.Experts)
+	if err != nil {
+		return err
+	}
+
+	routes, err := getPathRoutes(config.Routes)
+	if err != nil {
+		return err
+	}
+
+	allowlist, err := getAllowedNetworks(config.AllowedCIDRs)
+	if err != nil {
+		return err
+	}
+
+	staticGroups := map[string]StaticGroup{}
+	if config.GroupsFile != "" {
+		staticGroups, err = loadStaticGroups(config.GroupsFile)
+		if err != nil {
+			return err
+		}
+	}
+
 	server.config = config
+	server.keys = keys
+	server.experts = experts
+	server.routes = routes
+	server.allowlist = allowlist
+	server.staticGroups = staticGroups
 
 	return nil
 }
 
 func (server *SnobServer) ListenHTTP() error {
-	address, _ := server.config.GetString("listen")
+	listener, err := listen(server.config.Listen)
+	if err != nil {
+		return err
+	}
 
 	httpServer := &http.Server{
-		Addr:    address,
 		Handler: server,
 	}
 
-	return httpServer.ListenAndServe()
+	var reloader *certificateReloader
+	if server.config.TLSCert != "" {
+		reloader, err = newCertificateReloader(
+			server.config.TLSCert, server.config.TLSKey,
+		)
+		if err != nil {
+			return err
+		}
+
+		httpServer.TLSConfig = &tls.Config{
+			GetCertificate: reloader.GetCertificate,
+		}
+
+		// only clients holding certificate of the CA can connect at all
+		if server.config.ClientCA != "" {
+			pool, err := loadClientCA(server.config.ClientCA)
+			if err != nil {
+				return err
+			}
+
+			httpServer.TLSConfig.ClientCAs = pool
+			httpServer.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	go server.handleReloads(reloader)
+
+	shutdown := newGracefulShutdown(httpServer)
+	go server.handleUpgrades(shutdown, listener)
+	go server.handleTermination(shutdown)
+
+	server.runBackground()
+
+	notifyParentReady()
+
+	if reloader != nil {
+		err = httpServer.ServeTLS(listener, "", "")
+	} else {
+		err = httpServer.Serve(listener)
+	}
+
+	if err == http.ErrServerClosed {
+		<-shutdown.Stopped()
+		return nil
+	}
+
+	return err
+}
+
+// runBackground starts background jobs of the server and of every tenant.
+func (server *SnobServer) runBackground() {
+	go server.outbox.Run()
+
+	go server.RunDigests()
+
+	go server.RunEmailDigest()
+
+	go server.RunMaintenance()
+
+	go server.RunCacheRefresh()
+
+	go server.RunSweep()
+
+	go server.RunDefaultReviewersSync()
+
+	server.jobs.Run()
+
+	for _, tenant := range server.tenants {
+		tenant.runBackground()
+	}
 }
 
 func (server *SnobServer) ServeHTTP(
 	response http.ResponseWriter, request *http.Request,
 ) {
-	log.Printf("%s: %s", request.RemoteAddr, request.URL.Path)
+	request = withRequestID(response, request)
+
+	defer server.hold()()
+
+	if !server.isAllowedClient(request) {
+		server.reportError(
+			response,
+			NewError(
+				ErrorForbidden, "client %s is not allowed by allowed_cidrs",
+				getClientIP(request, server.config.TrustProxy),
+			),
+			http.StatusForbidden,
+		)
+		return
+	}
 
-	uriParts := strings.SplitN(
-		strings.Trim(request.URL.Path, "/"),
-		"/", 2,
+	err := server.checkClientRate(request)
+	if err != nil {
+		server.reportError(response, err, getErrorStatus(err))
+		return
+	}
+
+	if server.statsd == nil {
+		server.serveHTTP(response, request)
+		return
+	}
+
+	started := time.Now()
+	recorder := &statusRecorder{ResponseWriter: response, status: 200}
+
+	server.serveHTTP(recorder, request)
+
+	server.statsd.Timing(
+		"request", time.Since(started),
+		"method:"+strings.ToLower(request.Method),
+		"status:"+fmt.Sprint(recorder.status),
 	)
+}
+
+func (server *SnobServer) serveHTTP(
+	response http.ResponseWriter, request *http.Request,
+) {
+	getRequestLogger(request).Infof("%s %s", request.Method, request.URL.Path)
+
+	tenant, request, err := server.getTenant(request)
+	if err != nil {
+		server.reportError(response, err, getErrorStatus(err))
+		return
+	}
+
+	if tenant != nil {
+		defer tenant.hold()()
+
+		tenant.serveHTTP(response, request)
+		return
+	}
+
+	if request.Method == "DELETE" &&
+		(request.URL.Path == "/cache" ||
+			strings.HasPrefix(request.URL.Path, "/cache/")) {
+		server.handleInvalidateCache(response, request)
+		return
+	}
+
+	switch request.URL.Path {
+	case "/audit":
+		server.handleAudit(response, request)
+		return
+
+	case "/batch":
+		server.handleBatch(response, request)
+		return
+
+	case "/metrics":
+		server.handleMetrics(response, request)
+		return
+
+	case "/optout":
+		server.handleOptout(response, request)
+		return
+
+	case "/pull-request":
+		server.handlePullRequest(response, request)
+		return
+
+	case "/snapshot":
+		server.handleSnapshot(response, request)
+		return
+
+	case "/stats":
+		server.handleStats(response, request)
+		return
+
+	case "/v1/capabilities":
+		server.handleCapabilities(response, request)
+		return
+
+	case "/v1/config":
+		server.handleConfig(response, request)
+		return
+
+	case "/v1/default-reviewers":
+		server.handleDefaultReviewers(response, request)
+		return
+
+	case "/v1/explain":
+		server.handleExplain(response, request)
+		return
+
+	case "/v1/history":
+		server.handleHistory(response, request)
+		return
+
+	case "/v1/outbox":
+		server.handleOutbox(response, request)
+		return
+
+	case "/v1/rotation":
+		server.handleRotation(response, request)
+		return
+
+	case "/v1/undo":
+		server.handleUndo(response, request)
+		return
+
+	case "/v2/reviewers":
+		server.handleReviewers(response, request)
+		return
+
+	case "/webhook":
+		server.handleWebhook(response, request)
+		return
+	}
+
+	if server.oidc != nil {
+		switch request.URL.Path {
+		case "/login":
+			server.handleLogin(response, request)
+			return
+
+		case "/login/callback":
+			server.handleLoginCallback(response, request)
+			return
+
+		case "/logout":
+			server.handleLogout(response, request)
+			return
+		}
+	}
+
+	if strings.HasPrefix(request.URL.Path, "/suggest/") {
+		server.handleSuggest(response, request)
+		return
+	}
+
+	if strings.HasPrefix(request.URL.Path, "/jobs/") {
+		server.handleJob(response, request)
+		return
+	}
+
+	if strings.HasPrefix(request.URL.Path, "/availability/") {
+		server.handleAvailability(response, request)
+		return
+	}
+
+	uriParts, err := splitRequestPath(request)
+	if err != nil {
+		server.reportError(response, err, http.StatusBadRequest)
+		return
+	}
+
+	var ok bool
 
 	switch len(uriParts) {
 	case 2:
+		request, ok = server.authorize(response, request, OperationReviewers)
+		if !ok {
+			return
+		}
+
+		if request.Method == "DELETE" {
+			server.handleRemoveReviewers(
+				response, request, uriParts[0], uriParts[1],
+			)
+			return
+		}
+
 		server.handleAddReviewers(response, request, uriParts[0], uriParts[1])
 
 	case 1:
+		request, ok = server.authorize(response, request, OperationGroups)
+		if !ok {
+			return
+		}
+
+		err := checkGroupAccess(getRequestAPIKey(request), uriParts[0])
+		if err != nil {
+			server.reportError(response, err, getErrorStatus(err))
+			return
+		}
+
 		server.handleGetUsers(response, request, uriParts[0])
 
 	default:
@@ -162,151 +725,295 @@ func (server *SnobServer) handleAddReviewers(
 	response http.ResponseWriter, request *http.Request,
 	usergroup, pullRequestURL string,
 ) {
-	intersectGroups, _ := server.config.GetStringSlice("intersect")
-
-	users, err := server.GetUsersIntersection(usergroup, intersectGroups)
+	options, err := parseAssignOptions(request)
 	if err != nil {
-		http.Error(response, err.Error(), http.StatusBadRequest)
+		server.reportError(response, err, getErrorStatus(err))
 		return
 	}
 
-	matches := reStashURL.FindStringSubmatch(pullRequestURL)
-	if len(matches) == 0 {
-		http.Error(response, "wrong url", http.StatusBadRequest)
+	if request.URL.Query().Get("async") == "1" {
+		server.submitJob(response, request, Job{
+			Group:   usergroup,
+			URL:     pullRequestURL,
+			Options: options,
+		})
 		return
 	}
 
-	var (
-		project     = matches[3]
-		repository  = matches[4]
-		pullRequest = matches[5]
+	assignment, err := server.AssignReviewers(
+		getRequestAPIKey(request), usergroup, pullRequestURL, options,
 	)
-
-	err = server.AddReviewers(project, repository, pullRequest, users)
 	if err != nil {
-		http.Error(response, err.Error(), http.StatusInternalServerError)
+		if !options.DryRun {
+			server.auditFailure(request, AuditEntry{
+				Action: AuditAddReviewers,
+				Group:  usergroup,
+			}, pullRequestURL, err)
+		}
+
+		server.reportError(response, err, getErrorStatus(err))
 		return
 	}
 
-	http.Error(response, `{"success":true}`, http.StatusOK)
+	server.writeAssignment(response, request, assignment)
 }
 
-func (server *SnobServer) handleGetUsers(
-	response http.ResponseWriter, request *http.Request, usergroup string,
+// writeAssignment audits successful assignment and responds with its
+// outcome.
+func (server *SnobServer) writeAssignment(
+	response http.ResponseWriter, request *http.Request,
+	assignment *Assignment,
 ) {
-	users, ok := server.cache[usergroup]
-	if !ok {
-		var err error
-		users, err = server.GetUsers(usergroup)
-		if err != nil {
-			http.Error(response, err.Error(), http.StatusInternalServerError)
-			return
+	if assignment.Changed() {
+		server.audit(request, assignment.AuditEntry())
+	}
+
+	status, body := getAssignmentResponse(assignment)
+
+	writeResponse(response, status, body)
+}
+
+func getAssignmentResponse(assignment *Assignment) (int, APIResponse) {
+	switch {
+	case assignment.Skipped:
+		return http.StatusOK, APIResponse{
+			Success:      true,
+			Skipped:      true,
+			SkippedUsers: assignment.SkippedUsers,
 		}
 
-		if len(users) > 0 {
-			server.cache[usergroup] = users
+	case assignment.Queued:
+		return http.StatusAccepted, APIResponse{
+			Success: true,
+			Queued:  true,
 		}
+
+	case assignment.DryRun:
+		return http.StatusOK, APIResponse{
+			Success:      true,
+			DryRun:       true,
+			Reviewers:    assignment.Reviewers,
+			SkippedUsers: assignment.SkippedUsers,
+		}
+	}
+
+	return http.StatusOK, APIResponse{
+		Success:      true,
+		Added:        assignment.Reviewers,
+		Changed:      true,
+		SkippedUsers: assignment.SkippedUsers,
+	}
+}
+
+func (server *SnobServer) handleGetUsers(
+	response http.ResponseWriter, request *http.Request, usergroup string,
+) {
+	users, err := server.getCachedUsers(usergroup)
+	if err != nil {
+		server.reportError(response, err, http.StatusInternalServerError)
+		return
 	}
 
-	err := json.NewEncoder(response).Encode(users)
+	err = json.NewEncoder(response).Encode(users)
 	if err != nil {
-		http.Error(response, err.Error(), http.StatusInternalServerError)
+		server.reportError(response, err, http.StatusInternalServerError)
 	}
 
 	response.WriteHeader(http.StatusOK)
 }
 
 func (server *SnobServer) GetUsers(group string) ([]string, error) {
-	request, err := server.api.Res(
-		"admin/groups/more-members", &ResponseUsers{},
-	).Get(map[string]string{"context": group, "limit": "99999"})
+	static, ok := server.staticGroups[group]
+	if !ok {
+		return server.backend.GetGroupMembers(group)
+	}
+
+	if !static.MergeStash {
+		return static.Users, nil
+	}
 
+	users, err := server.backend.GetGroupMembers(group)
 	if err != nil {
-		return []string{}, nil
+		return []string{}, err
 	}
 
-	response := request.Response.(*ResponseUsers)
+	return mergeUsers(static.Users, users), nil
+}
+
+func (server *SnobServer) GetStashUsers(group string) ([]string, error) {
 	names := []string{}
-	for _, user := range response.Users {
-		names = append(names, user.Name)
-	}
+	start := 0
+
+	for {
+		request, err := server.api.Res(
+			"admin/groups/more-members", &ResponseUsers{},
+		).Get(map[string]string{
+			"context": group,
+			"start":   strconv.Itoa(start),
+			"limit":   "1000",
+		})
+
+		err = checkStashResponse(request, err)
+		if err != nil {
+			if stashError, ok := err.(*StashError); ok &&
+				stashError.StatusCode == http.StatusNotFound {
+				return []string{}, NewError(
+					ErrorGroupNotFound, "group %q not found", group,
+				)
+			}
+
+			return []string{}, err
+		}
+
+		response := request.Response.(*ResponseUsers)
+		for _, user := range response.Users {
+			names = append(names, user.Name)
+		}
 
-	return names, nil
+		if response.IsLastPage || len(response.Users) == 0 {
+			return names, nil
+		}
+
+		start = response.NextPageStart
+	}
 }
 
 func (server *SnobServer) AddReviewers(
 	project string, repository string, pullRequest string,
-	users []string,
+	info *ResponsePullRequest, users []string,
 ) error {
-	author, version, err := server.GetPullRequestInfo(
-		project, repository, pullRequest,
+	return server.backend.AddReviewers(
+		project, repository, pullRequest, info, users,
 	)
-	if err != nil {
-		return err
-	}
+}
 
-	stashUser, _ := server.config.GetString("user")
-	reviewers := getReviewers(
-		users, []string{author, stashUser},
+func (server *SnobServer) addStashReviewers(
+	project string, repository string, pullRequest string,
+	info *ResponsePullRequest, users []string,
+) error {
+	// exclude_users are never added, even if they are requested explicitly
+	ignored := append(
+		[]string{info.Author.User.Name, server.config.User},
+		server.config.ExcludeUsers...,
 	)
 
-	payload := map[string]interface{}{
-		"id":        pullRequest,
-		"version":   version,
-		"reviewers": reviewers,
+	chunkSize := server.config.ReviewersChunkSize
+	if chunkSize > 0 && len(users) > chunkSize ||
+		server.usesParticipantsAPI() {
+		if chunkSize <= 0 {
+			chunkSize = len(users)
+		}
+
+		return server.addReviewersChunked(
+			project, repository, pullRequest,
+			excludeUsers(users, ignored), chunkSize,
+		)
 	}
 
-	_, err = server.api.Res("projects").Res(project).
-		Res("repos").Res(repository).
-		Res("pull-requests").Res(pullRequest, &map[string]interface{}{}).
-		Put(payload)
+	users = excludeUsers(users, ignored)
 
-	return err
+	// reviewers list is replaced as a whole, so reviewers who are already on
+	// the pull request, including added by hand, are kept
+	return server.updateStashReviewers(
+		project, repository, pullRequest, info,
+		func(info *ResponsePullRequest) []map[string]interface{} {
+			current := []string{}
+			for _, reviewer := range info.Reviewers {
+				current = append(current, reviewer.User.Name)
+			}
+
+			return getReviewers(mergeUsers(current, users), nil)
+		},
+	)
+}
+
+// updateStashReviewers puts reviewers built from the pull request to it. If
+// pull request is changed meanwhile, Stash rejects stale version, then pull
+// request is fetched again and update is retried version_conflict_retries
+// times.
+func (server *SnobServer) updateStashReviewers(
+	project string, repository string, pullRequest string,
+	info *ResponsePullRequest,
+	reviewers func(*ResponsePullRequest) []map[string]interface{},
+) error {
+	for attempt := 1; ; attempt++ {
+		payload := map[string]interface{}{
+			"id":        pullRequest,
+			"version":   int64(info.Version),
+			"reviewers": reviewers(info),
+		}
+
+		request, err := server.repositoryResource(project, repository).
+			Res("pull-requests").Res(pullRequest, &map[string]interface{}{}).
+			Put(payload)
+
+		err = checkStashResponse(request, err)
+		if !isVersionConflict(err) || attempt > server.config.ConflictRetries {
+			return err
+		}
+
+		logger.WithPullRequest(project, repository, pullRequest).Warnf(
+			"version %d is outdated, retrying update (%d of %d)",
+			int64(info.Version), attempt, server.config.ConflictRetries,
+		)
+
+		info, err = server.getStashPullRequest(project, repository, pullRequest)
+		if err != nil {
+			return err
+		}
+	}
 }
 
 func (server *SnobServer) GetPullRequestInfo(
 	project string, repository string, pullRequest string,
-) (string, int64, error) {
-	request, err := server.api.Res("projects").Res(project).
-		Res("repos").Res(repository).
+) (*ResponsePullRequest, error) {
+	return server.backend.GetPullRequest(project, repository, pullRequest)
+}
+
+func (server *SnobServer) getStashPullRequest(
+	project string, repository string, pullRequest string,
+) (*ResponsePullRequest, error) {
+	request, err := server.repositoryResource(project, repository).
 		Res("pull-requests").Res(pullRequest, &ResponsePullRequest{}).
 		Get()
 
+	err = checkStashResponse(request, err)
 	if err != nil {
-		return "", 0, err
+		return nil, err
 	}
 
-	info := *request.Response.(*ResponsePullRequest)
-
-	return info.Author.User.Name, int64(info.Version), nil
+	return request.Response.(*ResponsePullRequest), nil
 }
 
-func getConfig(path string) (zhash.Hash, error) {
-	var configData map[string]interface{}
+func (server *SnobServer) reportError(
+	response http.ResponseWriter, err error, status int,
+) {
+	category := getErrorCategory(err)
 
-	_, err := toml.DecodeFile(path, &configData)
-	if err != nil {
-		return zhash.Hash{}, err
+	server.metrics.Errors.Inc(category)
+
+	// request id is set to response header before request is served
+	log := logger
+	if id := response.Header().Get(requestIDHeader); id != "" {
+		log = logger.With(LogFields{"request_id": id})
 	}
 
-	return zhash.HashFromMap(configData), nil
+	log.Errorf("error [%s]: %s", category, err)
+
+	if err, ok := err.(*Error); ok && err.RetryAfter > 0 {
+		response.Header().Set(
+			"Retry-After", fmt.Sprint(int(math.Ceil(err.RetryAfter.Seconds()))),
+		)
+	}
+
+	writeResponse(response, status, APIResponse{
+		Error: &APIError{Code: category, Message: err.Error()},
+	})
 }
 
 func getReviewers(users []string, ignoreUsers []string) []map[string]interface{} {
 	reviewers := []map[string]interface{}{}
-	for _, user := range users {
-		ignore := false
-		for _, ignoreUser := range ignoreUsers {
-			if ignoreUser == user {
-				ignore = true
-				break
-			}
-		}
-
-		if ignore {
-			continue
-		}
-
+	for _, user := range excludeUsers(users, ignoreUsers) {
 		reviewers = append(reviewers, map[string]interface{}{
 			"user": map[string]interface{}{
 				"name": user,
@@ -317,36 +1024,125 @@ func getReviewers(users []string, ignoreUsers []string) []map[string]interface{}
 	return reviewers
 }
 
-func (server *SnobServer) GetUsersIntersection(
-	targetGroup string, intersectGroups []string,
+func excludeUsers(users []string, ignoreUsers []string) []string {
+	return NewUserSet(ignoreUsers...).Difference(users)
+}
+
+func mergeUsers(users []string, otherUsers []string) []string {
+	return Union(users, otherUsers)
+}
+
+// containsUser compares usernames case-insensitively, because Stash and
+// directory services may return the same name in different case.
+func containsUser(users []string, user string) bool {
+	for _, item := range users {
+		if strings.EqualFold(item, user) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func normalizeUser(user string) string {
+	return strings.ToLower(user)
+}
+
+// GroupQuery describes candidates as members of group or any of union
+// groups, who are also members of any of intersect groups, if given,
+// except members of exclude groups.
+type GroupQuery struct {
+	Group     string
+	Union     []string
+	Intersect []string
+	Exclude   []string
+}
+
+func (query GroupQuery) String() string {
+	targets := append([]string{query.Group}, query.Union...)
+
+	text := "members of " + strings.Join(targets, ", ")
+
+	if len(query.Intersect) > 0 {
+		text += " which are in " + strings.Join(query.Intersect, ", ")
+	}
+
+	if len(query.Exclude) > 0 {
+		text += " except members of " + strings.Join(query.Exclude, ", ")
+	}
+
+	return text
+}
+
+// GetCandidateUsers returns users described by query, fetched groups are
+// recorded to trace if given.
+func (server *SnobServer) GetCandidateUsers(
+	query GroupQuery, trace *Trace,
 ) ([]string, error) {
-	targetUsers, err := server.GetUsers(targetGroup)
+	groups := append([]string{query.Group}, query.Union...)
+	groups = append(groups, query.Intersect...)
+	groups = append(groups, query.Exclude...)
+
+	members := make([][]string, len(groups))
+
+	var fetch errgroup.Group
+	fetch.SetLimit(server.config.GroupFetchParallel)
+
+	for index, group := range groups {
+		index, group := index, group
+
+		fetch.Go(func() error {
+			users, err := server.getCachedUsers(group)
+			if err != nil {
+				return err
+			}
+
+			members[index] = users
+
+			return nil
+		})
+	}
+
+	err := fetch.Wait()
 	if err != nil {
 		return []string{}, err
 	}
 
-	log.Printf(
-		"[%s]: %s", targetGroup, strings.Join(targetUsers, ", "),
-	)
+	// trace is not safe for concurrent use, so groups are recorded after
+	// all of them are fetched, in the same order as in config
+	for index, group := range groups {
+		trace.Group(group, members[index])
 
-	intersectUsers := []string{}
-	for _, group := range intersectGroups {
-		groupUsers, err := server.GetUsers(group)
-		if err != nil {
-			return []string{}, err
-		}
+		logger.Debugf("[%s]: %s", group, strings.Join(members[index], ", "))
+	}
 
-		log.Printf(
-			"[%s]: %s", group, strings.Join(groupUsers, ", "),
-		)
+	var (
+		targets    = 1 + len(query.Union)
+		intersects = targets + len(query.Intersect)
 
-		intersectUsers = append(intersectUsers, groupUsers...)
+		targetUsers   = []string{}
+		intersectSet  = NewUserSet()
+		excludedUsers = NewUserSet()
+	)
 
+	for index, users := range members {
+		switch {
+		case index < targets:
+			targetUsers = Union(targetUsers, users)
+		case index < intersects:
+			intersectSet.Add(users...)
+		default:
+			excludedUsers.Add(users...)
+		}
 	}
 
-	users := getIntersection(targetUsers, intersectUsers)
+	if len(query.Intersect) > 0 {
+		targetUsers = intersectSet.Intersect(targetUsers)
+	}
+
+	users := excludedUsers.Difference(targetUsers)
 
-	log.Printf(
+	logger.Debugf(
 		"[intersection]: %s", strings.Join(users, ", "),
 	)
 
@@ -354,24 +1150,5 @@ func (server *SnobServer) GetUsersIntersection(
 }
 
 func getIntersection(original []string, other []string) []string {
-	intersection := []string{}
-
-	for _, origItem := range original {
-		for _, otherItem := range other {
-			if origItem == otherItem {
-				exists := false
-				for _, interItem := range intersection {
-					if origItem == interItem {
-						exists = true
-						break
-					}
-				}
-				if !exists {
-					intersection = append(intersection, origItem)
-				}
-			}
-		}
-	}
-
-	return intersection
+	return NewUserSet(other...).Intersect(original)
 }
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type MaintenanceConfig struct {
+	QueueFile string              `toml:"queue_file"`
+	Windows   []MaintenanceWindow `toml:"windows"`
+}
+
+// MaintenanceWindow is period when Stash is expected to be down, like
+// scheduled upgrade.
+type MaintenanceWindow struct {
+	Start time.Time `toml:"start"`
+	End   time.Time `toml:"end"`
+}
+
+// QueuedAssignment is assignment requested during maintenance window,
+// caller access is checked before it's queued.
+type QueuedAssignment struct {
+	Time        time.Time     `json:"time"`
+	Caller      string        `json:"caller,omitempty"`
+	Group       string        `json:"group"`
+	Project     string        `json:"project"`
+	Repository  string        `json:"repository"`
+	PullRequest string        `json:"pull_request"`
+	Options     AssignOptions `json:"options"`
+
+	// Count is only read from queues saved before options were kept.
+	Count int `json:"count,omitempty"`
+}
+
+// Maintenance queues assignments during maintenance windows instead of
+// sending them to Stash and flushes the queue when window ends. Queue is
+// saved to the JSON file as a whole on every change if path is given. The
+// file is shared by daemon and snobs add, so it's re-read under lock
+// before every change.
+type Maintenance struct {
+	path string
+
+	windowsMutex sync.RWMutex
+	windows      []MaintenanceWindow
+
+	// changed wakes up RunMaintenance when windows are changed by reload.
+	changed chan struct{}
+
+	mutex  sync.Mutex
+	queued []QueuedAssignment
+}
+
+func OpenMaintenance(config MaintenanceConfig) (*Maintenance, error) {
+	maintenance := &Maintenance{
+		path:    config.QueueFile,
+		windows: config.Windows,
+		changed: make(chan struct{}, 1),
+		queued:  []QueuedAssignment{},
+	}
+
+	err := maintenance.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return maintenance, nil
+}
+
+// SetWindows replaces maintenance windows, it's used by reload.
+func (maintenance *Maintenance) SetWindows(windows []MaintenanceWindow) {
+	maintenance.windowsMutex.Lock()
+	maintenance.windows = windows
+	maintenance.windowsMutex.Unlock()
+
+	select {
+	case maintenance.changed <- struct{}{}:
+	default:
+	}
+}
+
+// ActiveUntil returns end of maintenance window which contains given time.
+func (maintenance *Maintenance) ActiveUntil(now time.Time) (time.Time, bool) {
+	maintenance.windowsMutex.RLock()
+	defer maintenance.windowsMutex.RUnlock()
+
+	for _, window := range maintenance.windows {
+		if !now.Before(window.Start) && now.Before(window.End) {
+			return window.End, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// NextStart returns start of the nearest window after given time, it's zero
+// if there are no windows ahead.
+func (maintenance *Maintenance) NextStart(now time.Time) time.Time {
+	maintenance.windowsMutex.RLock()
+	defer maintenance.windowsMutex.RUnlock()
+
+	next := time.Time{}
+	for _, window := range maintenance.windows {
+		if window.Start.After(now) &&
+			(next.IsZero() || window.Start.Before(next)) {
+			next = window.Start
+		}
+	}
+
+	return next
+}
+
+// wait sleeps until given time or until windows are changed, zero time
+// means waiting for change only.
+func (maintenance *Maintenance) wait(until time.Time) {
+	if until.IsZero() {
+		<-maintenance.changed
+		return
+	}
+
+	select {
+	case <-maintenance.changed:
+	case <-time.After(time.Until(until)):
+	}
+}
+
+func (maintenance *Maintenance) Enqueue(assignment QueuedAssignment) error {
+	return maintenance.update(func() {
+		maintenance.queued = append(maintenance.queued, assignment)
+	})
+}
+
+// Len returns number of queued assignments, including ones queued by other
+// processes.
+func (maintenance *Maintenance) Len() int {
+	count := 0
+
+	err := maintenance.update(func() {
+		count = len(maintenance.queued)
+	})
+	if err != nil {
+		logger.Errorf("can't load maintenance queue: %s", err)
+	}
+
+	return count
+}
+
+// take removes all queued assignments and returns them.
+func (maintenance *Maintenance) take() ([]QueuedAssignment, error) {
+	queued := []QueuedAssignment{}
+
+	err := maintenance.update(func() {
+		queued = maintenance.queued
+		maintenance.queued = []QueuedAssignment{}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return queued, nil
+}
+
+// update loads queue from the file under exclusive lock, applies change
+// and saves queue back, so assignments queued by other processes are not
+// overwritten. Lock is taken on separate file, because queue file is
+// replaced on save.
+func (maintenance *Maintenance) update(change func()) error {
+	maintenance.mutex.Lock()
+	defer maintenance.mutex.Unlock()
+
+	if maintenance.path == "" {
+		change()
+		return nil
+	}
+
+	lock, err := os.OpenFile(
+		maintenance.path+".lock", os.O_RDWR|os.O_CREATE, 0644,
+	)
+	if err != nil {
+		return err
+	}
+
+	// lock is released when file is closed
+	defer lock.Close()
+
+	err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+
+	err = maintenance.load()
+	if err != nil {
+		return err
+	}
+
+	change()
+
+	return maintenance.save()
+}
+
+// load replaces queue with one saved in the file.
+func (maintenance *Maintenance) load() error {
+	if maintenance.path == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(maintenance.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	queued := []QueuedAssignment{}
+
+	err = json.Unmarshal(data, &queued)
+	if err != nil {
+		return err
+	}
+
+	maintenance.queued = queued
+
+	return nil
+}
+
+func (maintenance *Maintenance) save() error {
+	data, err := json.MarshalIndent(maintenance.queued, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	temporary := maintenance.path + ".tmp"
+
+	err = ioutil.WriteFile(temporary, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temporary, maintenance.path)
+}
+
+// checkMaintenance returns error for write operations which can't be
+// queued, like undo, during maintenance window.
+func (server *SnobServer) checkMaintenance() error {
+	until, active := server.maintenance.ActiveUntil(time.Now())
+	if !active {
+		return nil
+	}
+
+	err := NewError(
+		ErrorMaintenance, "stash is under maintenance until %s",
+		until.Format(time.RFC3339),
+	)
+	err.RetryAfter = time.Until(until)
+
+	return err
+}
+
+// queueAssignment queues assignment if maintenance window is active now,
+// returned assignment is nil otherwise.
+func (server *SnobServer) queueAssignment(
+	key *APIKey, usergroup string,
+	project string, repository string, pullRequest string,
+	options AssignOptions,
+) (*Assignment, error) {
+	until, active := server.maintenance.ActiveUntil(time.Now())
+	if !active {
+		return nil, nil
+	}
+
+	err := server.checkPullRequestAccess(key, project, repository)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkGroupAccess(key, usergroup)
+	if err != nil {
+		return nil, err
+	}
+
+	queued := QueuedAssignment{
+		Time:        time.Now(),
+		Group:       usergroup,
+		Project:     project,
+		Repository:  repository,
+		PullRequest: pullRequest,
+		Options:     options,
+	}
+
+	// request which queued assignment is over when it's replayed
+	queued.Options.RequestID = ""
+
+	if key != nil {
+		queued.Caller = key.Name
+	}
+
+	err = server.maintenance.Enqueue(queued)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.WithPullRequest(project, repository, pullRequest).Infof(
+		"queued until maintenance ends at %s", until.Format(time.RFC3339),
+	)
+
+	return &Assignment{
+		Project:     project,
+		Repository:  repository,
+		PullRequest: pullRequest,
+		Group:       usergroup,
+		Queued:      true,
+	}, nil
+}
+
+// RunMaintenance flushes queued assignments after every maintenance window
+// and at start, if there are assignments left from the previous run.
+// Assignments which are left in queue after flush are retried with jobs
+// backoff. Windows changed by reload are picked up right away.
+func (server *SnobServer) RunMaintenance() {
+	for {
+		until, active := server.maintenance.ActiveUntil(time.Now())
+		if active {
+			logger.Infof("stash is under maintenance until %s", until)
+
+			server.maintenance.wait(until)
+			continue
+		}
+
+		release := server.hold()
+		server.FlushMaintenanceQueue()
+		retry := server.config.Jobs.Backoff.Duration
+		release()
+
+		next := server.maintenance.NextStart(time.Now())
+
+		if server.maintenance.Len() > 0 &&
+			(next.IsZero() || time.Until(next) > retry) {
+			next = time.Now().Add(retry)
+		}
+
+		server.maintenance.wait(next)
+	}
+}
+
+// FlushMaintenanceQueue assigns reviewers to every queued pull request.
+// Assignments failed because Stash is not back yet are submitted as jobs,
+// which are retried with backoff, other failures are logged.
+func (server *SnobServer) FlushMaintenanceQueue() {
+	queued, err := server.maintenance.take()
+	if err != nil {
+		logger.Errorf("can't take maintenance queue: %s", err)
+		return
+	}
+
+	for _, item := range queued {
+		options := item.Options
+		if item.Count > 0 {
+			options.Count = item.Count
+		}
+
+		assignment, err := server.assignPullRequest(
+			nil, item.Group,
+			item.Project, item.Repository, item.PullRequest, options,
+		)
+		if err != nil {
+			logger.WithPullRequest(
+				item.Project, item.Repository, item.PullRequest,
+			).Errorf("can't assign queued reviewers: %s", err)
+
+			if isRetryableError(err) {
+				server.retryQueuedAssignment(item, options)
+			}
+
+			continue
+		}
+
+		if assignment.Skipped || assignment.DryRun {
+			continue
+		}
+
+		entry := assignment.AuditEntry()
+		entry.Caller = item.Caller
+		entry.Details = "queued during maintenance at " +
+			item.Time.Format(time.RFC3339)
+
+		server.audit(nil, entry)
+	}
+}
+
+// retryQueuedAssignment submits failed assignment as job, it's kept in
+// maintenance queue for the next flush if job queue doesn't accept it.
+func (server *SnobServer) retryQueuedAssignment(
+	item QueuedAssignment, options AssignOptions,
+) {
+	log := logger.WithPullRequest(
+		item.Project, item.Repository, item.PullRequest,
+	)
+
+	id, err := server.jobs.Submit(Job{
+		Group:       item.Group,
+		Project:     item.Project,
+		Repository:  item.Repository,
+		PullRequest: item.PullRequest,
+		Options:     options,
+		Caller:      item.Caller,
+	})
+	if err == nil {
+		log.Infof("queued reviewers will be assigned by job %s", id)
+		return
+	}
+
+	log.Errorf("can't submit job, keeping assignment in queue: %s", err)
+
+	err = server.maintenance.Enqueue(item)
+	if err != nil {
+		log.Errorf("can't queue assignment again: %s", err)
+	}
+}
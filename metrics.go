@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+type Metrics struct {
+	Errors *CounterVec
+}
+
+type CounterVec struct {
+	name   string
+	help   string
+	label  string
+	mutex  sync.Mutex
+	values map[string]int64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		Errors: NewCounterVec(
+			"snobs_errors_total",
+			"Failed requests by error category.",
+			"category", errorCategories...,
+		),
+	}
+}
+
+func (metrics *Metrics) Expose(writer io.Writer) {
+	metrics.Errors.Expose(writer)
+}
+
+// NewCounterVec creates counter with single label, given label values are
+// exposed as zeroes before first increment, so alerting on rate() works
+// from the start.
+func NewCounterVec(name, help, label string, values ...string) *CounterVec {
+	counter := &CounterVec{
+		name:   name,
+		help:   help,
+		label:  label,
+		values: map[string]int64{},
+	}
+
+	for _, value := range values {
+		counter.values[value] = 0
+	}
+
+	return counter
+}
+
+func (counter *CounterVec) Inc(value string) {
+	counter.Add(value, 1)
+}
+
+func (counter *CounterVec) Add(value string, delta int64) {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+
+	counter.values[value] += delta
+}
+
+func (counter *CounterVec) Expose(writer io.Writer) {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+
+	values := []string{}
+	for value := range counter.values {
+		values = append(values, value)
+	}
+
+	sort.Strings(values)
+
+	fmt.Fprintf(writer, "# HELP %s %s\n", counter.name, counter.help)
+	fmt.Fprintf(writer, "# TYPE %s counter\n", counter.name)
+
+	for _, value := range values {
+		fmt.Fprintf(
+			writer, "%s{%s=%q} %d\n",
+			counter.name, counter.label, value, counter.values[value],
+		)
+	}
+}
+
+func (server *SnobServer) handleMetrics(
+	response http.ResponseWriter, request *http.Request,
+) {
+	response.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	server.metrics.Expose(response)
+}
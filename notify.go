@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	WebhookFormatJSON       = "json"
+	WebhookFormatMattermost = "mattermost"
+)
+
+const (
+	defaultSlackTemplate = "{{.Mentions}}: you were assigned to review " +
+		"<{{.URL}}|{{.Project}}/{{.Repository}}#{{.PullRequest}} {{.Title}}> " +
+		"by {{.Author}}"
+
+	defaultMattermostTemplate = "{{.Mentions}}: you were assigned to review " +
+		"[{{.Project}}/{{.Repository}}#{{.PullRequest}} {{.Title}}]({{.URL}}) " +
+		"by {{.Author}}"
+)
+
+// NotifyConfig configures notifications sent on every assignment, they are
+// delivered through outbox.
+type NotifyConfig struct {
+	Slack    SlackNotifyConfig     `toml:"slack"`
+	Webhooks []WebhookNotifyConfig `toml:"webhooks"`
+	Email    EmailNotifyConfig     `toml:"email"`
+}
+
+type SlackNotifyConfig struct {
+	URL      string `toml:"url"`
+	Channel  string `toml:"channel"`
+	Template string `toml:"template"`
+}
+
+// WebhookNotifyConfig is outgoing webhook. In json format event of every
+// assignment attempt is posted as is, including failed ones, while in
+// mattermost format message rendered by template is posted only when
+// reviewers are added.
+type WebhookNotifyConfig struct {
+	URL      string `toml:"url"`
+	Format   string `toml:"format"`
+	Channel  string `toml:"channel"`
+	Template string `toml:"template"`
+}
+
+func validateNotify(config NotifyConfig) ConfigErrors {
+	errs := ConfigErrors{}
+
+	if config.Slack.URL == "" {
+		if config.Slack.Channel != "" || config.Slack.Template != "" {
+			errs = append(errs, "notify.slack.url is required")
+		}
+	} else {
+		_, err := parseTemplate("notify.slack.template", config.Slack.Template)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("notify.slack.template: %s", err))
+		}
+	}
+
+	for index, webhook := range config.Webhooks {
+		if webhook.URL == "" {
+			errs = append(errs, fmt.Sprintf(
+				"notify.webhooks[%d]: url is required", index,
+			))
+		}
+
+		switch webhook.Format {
+		case "", WebhookFormatJSON:
+			if webhook.Channel != "" || webhook.Template != "" {
+				errs = append(errs, fmt.Sprintf(
+					"notify.webhooks[%d]: channel and template are supported "+
+						"only by mattermost format",
+					index,
+				))
+			}
+
+		case WebhookFormatMattermost:
+			_, err := parseTemplate("template", webhook.Template)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf(
+					"notify.webhooks[%d]: template: %s", index, err,
+				))
+			}
+
+		default:
+			errs = append(errs, fmt.Sprintf(
+				"notify.webhooks[%d]: unknown format %q", index, webhook.Format,
+			))
+		}
+	}
+
+	return errs
+}
+
+// notifyAssignment queues notifications about added reviewers.
+func (server *SnobServer) notifyAssignment(assignment *Assignment) error {
+	slack := server.config.Notify.Slack
+	if slack.URL == "" {
+		return nil
+	}
+
+	template := slack.Template
+	if template == "" {
+		template = defaultSlackTemplate
+	}
+
+	text, err := renderTemplate("notify.slack.template", template, assignment)
+	if err != nil {
+		return err
+	}
+
+	return server.outbox.Enqueue(Notification{
+		Kind:    NotificationSlack,
+		URL:     slack.URL,
+		Channel: slack.Channel,
+		Text:    text,
+	})
+}
+
+// notifyWebhooks queues posts of the assignment attempt to configured
+// webhooks, failures to queue are only logged.
+func (server *SnobServer) notifyWebhooks(
+	project, repository, pullRequest string,
+	assignment *Assignment, failure error,
+) {
+	if len(server.config.Notify.Webhooks) == 0 {
+		return
+	}
+
+	event := getAssignmentEvent(
+		project, repository, pullRequest, assignment, failure,
+	)
+	event.Time = time.Now()
+
+	for _, webhook := range server.config.Notify.Webhooks {
+		payload, err := getWebhookPayload(webhook, event, assignment, failure)
+		if err != nil {
+			logger.Errorf("can't prepare webhook payload: %s", err)
+			continue
+		}
+
+		if payload == nil {
+			continue
+		}
+
+		err = server.outbox.Enqueue(Notification{
+			Kind:    NotificationWebhook,
+			URL:     webhook.URL,
+			Payload: payload,
+		})
+		if err != nil {
+			logger.Errorf("can't queue webhook notification: %s", err)
+		}
+	}
+}
+
+// getWebhookPayload returns nil payload if nothing should be posted to the
+// webhook.
+func getWebhookPayload(
+	webhook WebhookNotifyConfig, event Event,
+	assignment *Assignment, failure error,
+) ([]byte, error) {
+	if webhook.Format != WebhookFormatMattermost {
+		return json.Marshal(event)
+	}
+
+	if failure != nil || !assignment.Changed() {
+		return nil, nil
+	}
+
+	template := webhook.Template
+	if template == "" {
+		template = defaultMattermostTemplate
+	}
+
+	text, err := renderTemplate("template", template, assignment)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := map[string]string{"text": text}
+	if webhook.Channel != "" {
+		payload["channel"] = webhook.Channel
+	}
+
+	return json.Marshal(payload)
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sessionCookie = "snobs_session"
+	loginCookie   = "snobs_login"
+)
+
+// OIDCProvider implements OpenID Connect authorization code flow, users
+// are mapped to roles by groups claim of the ID token and get a signed
+// session cookie, which is accepted by admin endpoints and web pages.
+type OIDCProvider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+	userClaim    string
+	groupsClaim  string
+	roleGroups   map[string][]string
+	sessionKey   []byte
+	sessionTTL   time.Duration
+	client       *http.Client
+
+	mutex     sync.Mutex
+	discovery *oidcDiscovery
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+type Session struct {
+	User    string    `json:"user"`
+	Roles   []string  `json:"roles"`
+	Expires time.Time `json:"expires"`
+}
+
+type OIDCConfig struct {
+	Issuer       string   `toml:"issuer"`
+	ClientID     string   `toml:"client_id"`
+	ClientSecret string   `toml:"client_secret"`
+	RedirectURL  string   `toml:"redirect_url"`
+	Scopes       []string `toml:"scopes"`
+	UserClaim    string   `toml:"user_claim"`
+	GroupsClaim  string   `toml:"groups_claim"`
+	SessionKey   string   `toml:"session_key"`
+	SessionTTL   Duration `toml:"session_ttl"`
+
+	// Roles maps snobs roles to groups of identity provider.
+	Roles map[string][]string `toml:"roles"`
+}
+
+// NewOIDCProvider returns nil provider if oidc is not configured.
+func NewOIDCProvider(
+	config OIDCConfig, timeout time.Duration,
+) (*OIDCProvider, error) {
+	if config.Issuer == "" {
+		return nil, nil
+	}
+
+	for _, required := range []struct {
+		key   string
+		value string
+	}{
+		{"client_id", config.ClientID},
+		{"client_secret", config.ClientSecret},
+		{"redirect_url", config.RedirectURL},
+		{"session_key", config.SessionKey},
+	} {
+		if required.value == "" {
+			return nil, fmt.Errorf("oidc.%s is required", required.key)
+		}
+	}
+
+	if config.SessionTTL.Duration <= 0 {
+		return nil, fmt.Errorf("oidc.session_ttl should be positive")
+	}
+
+	for role := range config.Roles {
+		if _, ok := roleOperations[role]; !ok {
+			return nil, fmt.Errorf("oidc.roles: unknown role %q", role)
+		}
+	}
+
+	return &OIDCProvider{
+		issuer:       strings.TrimRight(config.Issuer, "/"),
+		clientID:     config.ClientID,
+		clientSecret: config.ClientSecret,
+		redirectURL:  config.RedirectURL,
+		scopes:       config.Scopes,
+		userClaim:    config.UserClaim,
+		groupsClaim:  config.GroupsClaim,
+		roleGroups:   config.Roles,
+		sessionKey:   []byte(config.SessionKey),
+		sessionTTL:   config.SessionTTL.Duration,
+		client:       &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (provider *OIDCProvider) getDiscovery() (*oidcDiscovery, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	if provider.discovery != nil {
+		return provider.discovery, nil
+	}
+
+	response, err := provider.client.Get(
+		provider.issuer + "/.well-known/openid-configuration",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(
+			"can't discover oidc provider: %s", response.Status,
+		)
+	}
+
+	var discovery oidcDiscovery
+
+	err = json.NewDecoder(response.Body).Decode(&discovery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid oidc discovery document: %s", err)
+	}
+
+	provider.discovery = &discovery
+
+	return provider.discovery, nil
+}
+
+func (server *SnobServer) handleLogin(
+	response http.ResponseWriter, request *http.Request,
+) {
+	provider := server.oidc
+
+	discovery, err := provider.getDiscovery()
+	if err != nil {
+		server.reportError(response, err, http.StatusBadGateway)
+		return
+	}
+
+	var (
+		state = getRandomToken()
+		nonce = getRandomToken()
+		next  = getLoginNext(request.URL.Query().Get("next"))
+	)
+
+	http.SetCookie(response, &http.Cookie{
+		Name:     loginCookie,
+		Value:    provider.sign(strings.Join([]string{state, nonce, next}, "|")),
+		Path:     "/login",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(provider.redirectURL, "https://"),
+	})
+
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {provider.clientID},
+		"redirect_uri":  {provider.redirectURL},
+		"scope":         {strings.Join(provider.scopes, " ")},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+
+	http.Redirect(
+		response, request,
+		discovery.AuthorizationEndpoint+"?"+query.Encode(),
+		http.StatusFound,
+	)
+}
+
+func (server *SnobServer) handleLoginCallback(
+	response http.ResponseWriter, request *http.Request,
+) {
+	provider := server.oidc
+	query := request.URL.Query()
+
+	if message := query.Get("error"); message != "" {
+		server.reportError(
+			response,
+			NewError(ErrorUnauthorized, "login failed: %s", message),
+			http.StatusUnauthorized,
+		)
+		return
+	}
+
+	var login []string
+
+	if cookie, err := request.Cookie(loginCookie); err == nil {
+		if value, ok := provider.verify(cookie.Value); ok {
+			login = strings.SplitN(value, "|", 3)
+		}
+	}
+
+	if len(login) != 3 || login[0] != query.Get("state") {
+		server.reportError(
+			response,
+			NewError(ErrorUnauthorized, "invalid login state"),
+			http.StatusUnauthorized,
+		)
+		return
+	}
+
+	session, err := provider.exchange(query.Get("code"), login[1])
+	if err != nil {
+		server.reportError(response, err, getErrorStatus(err))
+		return
+	}
+
+	logger.Infof("%s logged in with roles %v", session.User, session.Roles)
+
+	http.SetCookie(response, &http.Cookie{
+		Name:     loginCookie,
+		Path:     "/login",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	http.SetCookie(response, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    provider.encodeSession(session),
+		Path:     "/",
+		Expires:  session.Expires,
+		HttpOnly: true,
+		// legacy API adds reviewers on GET, so session must not be sent
+		// with requests coming from other sites
+		SameSite: http.SameSiteStrictMode,
+		Secure:   strings.HasPrefix(provider.redirectURL, "https://"),
+	})
+
+	http.Redirect(response, request, login[2], http.StatusFound)
+}
+
+func (server *SnobServer) handleLogout(
+	response http.ResponseWriter, request *http.Request,
+) {
+	http.SetCookie(response, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	writeResponse(response, http.StatusOK, APIResponse{Success: true})
+}
+
+// exchange redeems authorization code for ID token. Token is received
+// directly from token endpoint of the provider over TLS, so its claims
+// are validated without checking the signature, as OIDC core allows.
+func (provider *OIDCProvider) exchange(
+	code string, nonce string,
+) (*Session, error) {
+	discovery, err := provider.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {provider.redirectURL},
+	}
+
+	request, err := http.NewRequest(
+		"POST", discovery.TokenEndpoint, strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth(
+		url.QueryEscape(provider.clientID),
+		url.QueryEscape(provider.clientSecret),
+	)
+
+	response, err := provider.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	var token struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&token)
+	if err != nil || response.StatusCode != http.StatusOK {
+		return nil, NewError(
+			ErrorUnauthorized, "can't redeem code: %s %s",
+			response.Status, token.Error,
+		)
+	}
+
+	claims, err := parseIDToken(token.IDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	err = provider.validateClaims(claims, discovery.Issuer, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	user, _ := claims[provider.userClaim].(string)
+	if user == "" {
+		return nil, NewError(
+			ErrorUnauthorized, "id token has no %s claim", provider.userClaim,
+		)
+	}
+
+	return &Session{
+		User:    user,
+		Roles:   provider.getRoles(claims[provider.groupsClaim]),
+		Expires: time.Now().Add(provider.sessionTTL),
+	}, nil
+}
+
+func parseIDToken(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, NewError(ErrorUnauthorized, "malformed id token")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, NewError(ErrorUnauthorized, "malformed id token: %s", err)
+	}
+
+	claims := map[string]interface{}{}
+
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return nil, NewError(ErrorUnauthorized, "malformed id token: %s", err)
+	}
+
+	return claims, nil
+}
+
+func (provider *OIDCProvider) validateClaims(
+	claims map[string]interface{}, issuer string, nonce string,
+) error {
+	if claims["iss"] != issuer {
+		return NewError(ErrorUnauthorized, "id token issuer mismatch")
+	}
+
+	audience := false
+	switch aud := claims["aud"].(type) {
+	case string:
+		audience = aud == provider.clientID
+
+	case []interface{}:
+		for _, value := range aud {
+			if value == provider.clientID {
+				audience = true
+			}
+		}
+	}
+
+	if !audience {
+		return NewError(ErrorUnauthorized, "id token audience mismatch")
+	}
+
+	expires, _ := claims["exp"].(float64)
+	if time.Now().Unix() >= int64(expires) {
+		return NewError(ErrorUnauthorized, "id token is expired")
+	}
+
+	if claims["nonce"] != nonce {
+		return NewError(ErrorUnauthorized, "id token nonce mismatch")
+	}
+
+	return nil
+}
+
+func (provider *OIDCProvider) getRoles(rawGroups interface{}) []string {
+	groups := []string{}
+	if values, ok := rawGroups.([]interface{}); ok {
+		for _, value := range values {
+			if group, ok := value.(string); ok {
+				groups = append(groups, group)
+			}
+		}
+	}
+
+	roles := []string{}
+	for role, roleGroups := range provider.roleGroups {
+		if len(getIntersection(roleGroups, groups)) > 0 {
+			roles = append(roles, role)
+		}
+	}
+
+	sort.Strings(roles)
+
+	return roles
+}
+
+func (provider *OIDCProvider) encodeSession(session *Session) string {
+	payload, _ := json.Marshal(session)
+
+	return provider.sign(base64.RawURLEncoding.EncodeToString(payload))
+}
+
+// GetSession returns session of the request or nil if there is no valid
+// session cookie.
+func (provider *OIDCProvider) GetSession(request *http.Request) *Session {
+	if provider == nil {
+		return nil
+	}
+
+	cookie, err := request.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+
+	value, ok := provider.verify(cookie.Value)
+	if !ok {
+		return nil
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil
+	}
+
+	var session Session
+
+	err = json.Unmarshal(payload, &session)
+	if err != nil || time.Now().After(session.Expires) {
+		return nil
+	}
+
+	return &session
+}
+
+// APIKey returns key with operations granted by session roles, so
+// sessions are authorized and audited the same way as API keys.
+func (session *Session) APIKey() *APIKey {
+	operations, _ := getRoleOperations(session.Roles, nil)
+
+	return &APIKey{
+		Name:       "oidc:" + session.User,
+		Roles:      session.Roles,
+		Operations: operations,
+	}
+}
+
+func (provider *OIDCProvider) sign(value string) string {
+	digest := hmac.New(sha256.New, provider.sessionKey)
+	digest.Write([]byte(value))
+
+	return value + "." + hex.EncodeToString(digest.Sum(nil))
+}
+
+func (provider *OIDCProvider) verify(signed string) (string, bool) {
+	index := strings.LastIndex(signed, ".")
+	if index < 0 {
+		return "", false
+	}
+
+	value := signed[:index]
+
+	return value, hmac.Equal([]byte(provider.sign(value)), []byte(signed))
+}
+
+// getLoginNext allows redirects only to local paths after login.
+func getLoginNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") ||
+		strings.Contains(next, "\\") {
+		return "/optout"
+	}
+
+	return next
+}
+
+func getRandomToken() string {
+	token := make([]byte, 16)
+
+	_, err := rand.Read(token)
+	if err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(token)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bndr/gopencils"
+)
+
+var optoutTemplate = template.Must(template.New("optout").Parse(`<!DOCTYPE html>
+<html>
+<head><title>snobs: pause assignments</title></head>
+<body>
+<h1>Review assignments for {{.User}}</h1>
+{{if .Error}}<p style="color: red">{{.Error}}</p>{{end}}
+{{if .Paused}}
+<p>Assignments are paused until {{.Until.Format "Monday, 2006-01-02 15:04"}}.</p>
+<form method="post">
+<input type="hidden" name="until" value="">
+<input type="submit" value="Resume assignments now">
+</form>
+{{else}}
+<p>You are receiving review assignments.</p>
+{{end}}
+<form method="post">
+<label>Pause assignments until <input type="date" name="until"></label>
+<input type="submit" value="Pause">
+</form>
+<form method="post">
+<label>Maximum reviews per week
+<input type="number" min="0" name="capacity" value="{{if .Capacity}}{{.Capacity}}{{end}}">
+</label>
+<input type="submit" value="Save">
+</form>
+<p>Leave maximum empty for unlimited reviews.
+{{if .Capacity}} You were assigned {{.Assigned}} of {{.Capacity}} reviews during
+the last week.{{end}}</p>
+</body>
+</html>
+`))
+
+type optoutStatus struct {
+	User     string    `json:"user"`
+	Paused   bool      `json:"paused"`
+	Until    time.Time `json:"until,omitempty"`
+	Capacity int       `json:"capacity"`
+	Assigned int       `json:"assigned"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// handleOptout lets reviewers pause their own assignments or limit weekly
+// capacity. Reviewers are authenticated with their own Stash credentials
+// or by login session, so nobody can change settings of anybody else.
+func (server *SnobServer) handleOptout(
+	response http.ResponseWriter, request *http.Request,
+) {
+	if session := server.oidc.GetSession(request); session != nil {
+		server.serveOptout(response, request, session.User)
+		return
+	}
+
+	if _, _, ok := request.BasicAuth(); !ok && server.oidc != nil {
+		http.Redirect(
+			response, request,
+			"/login?next="+url.QueryEscape(request.URL.RequestURI()),
+			http.StatusFound,
+		)
+		return
+	}
+
+	user, err := server.authenticateStashUser(request)
+	if err != nil {
+		response.Header().Set("WWW-Authenticate", `Basic realm="snobs"`)
+		server.reportError(response, err, http.StatusUnauthorized)
+		return
+	}
+
+	server.serveOptout(response, request, user)
+}
+
+func (server *SnobServer) serveOptout(
+	response http.ResponseWriter, request *http.Request, user string,
+) {
+	var err error
+
+	status := optoutStatus{User: user}
+
+	switch request.Method {
+	case "GET":
+
+	case "POST":
+		request.ParseForm()
+
+		if _, ok := request.Form["capacity"]; ok {
+			capacity, parseErr := parseCapacity(request.Form.Get("capacity"))
+			if parseErr != nil {
+				status.Error = parseErr.Error()
+				break
+			}
+
+			logger.Infof("%s set weekly capacity to %d", user, capacity)
+
+			err = server.availability.SetCapacity(user, capacity)
+			if err != nil {
+				break
+			}
+		}
+
+		if _, ok := request.Form["until"]; !ok {
+			break
+		}
+
+		rawUntil := strings.TrimSpace(request.Form.Get("until"))
+		if rawUntil == "" {
+			err = server.availability.SetAvailable(user)
+			break
+		}
+
+		until, parseErr := parseUntil(rawUntil)
+		if parseErr != nil {
+			status.Error = parseErr.Error()
+			break
+		}
+
+		logger.Infof("%s paused assignments until %s", user, until)
+
+		err = server.availability.SetUnavailable(user, until)
+
+	default:
+		server.reportError(
+			response,
+			NewError(ErrorBadRequest, "method %s is not allowed", request.Method),
+			http.StatusMethodNotAllowed,
+		)
+		return
+	}
+
+	if err != nil {
+		server.reportError(response, err, http.StatusInternalServerError)
+		return
+	}
+
+	if request.Method == "POST" && status.Error == "" {
+		server.audit(request, AuditEntry{
+			Action: AuditAvailability,
+			Caller: user,
+			Details: fmt.Sprintf(
+				"until: %q, capacity: %q",
+				request.Form.Get("until"), request.Form.Get("capacity"),
+			),
+		})
+	}
+
+	errorText := status.Error
+
+	status = server.getAvailabilityStatus(user)
+	status.Error = errorText
+
+	code := http.StatusOK
+	if status.Error != "" {
+		code = http.StatusBadRequest
+	}
+
+	if strings.Contains(request.Header.Get("Accept"), "application/json") {
+		response.Header().Set("Content-Type", "application/json")
+		response.WriteHeader(code)
+		json.NewEncoder(response).Encode(status)
+		return
+	}
+
+	response.Header().Set("Content-Type", "text/html; charset=utf-8")
+	response.WriteHeader(code)
+
+	err = optoutTemplate.Execute(response, status)
+	if err != nil {
+		logger.Errorf("can't render optout page: %s", err)
+	}
+}
+
+// getAvailabilityStatus returns current pause and capacity of the user
+// together with number of assignments during the last week.
+func (server *SnobServer) getAvailabilityStatus(user string) optoutStatus {
+	status := optoutStatus{User: user}
+
+	availability := server.availability.Get(user)
+	status.Paused = availability.IsPaused()
+	if status.Paused {
+		status.Until = availability.Until
+	}
+
+	status.Capacity = availability.Capacity
+	status.Assigned = server.history.CountAssignments(
+		time.Now().AddDate(0, 0, -7),
+	)[normalizeUser(user)]
+
+	return status
+}
+
+func (server *SnobServer) authenticateStashUser(
+	request *http.Request,
+) (string, error) {
+	user, pass, ok := request.BasicAuth()
+	if !ok {
+		return "", NewError(
+			ErrorUnauthorized, "stash username and password are required",
+		)
+	}
+
+	api := gopencils.Api(
+		server.stashURL,
+		&gopencils.BasicAuth{user, pass},
+		server.httpClient,
+	)
+
+	result, err := api.Res("users").Res(user, &map[string]interface{}{}).Get()
+
+	err = checkStashResponse(result, err)
+	if err != nil {
+		if getErrorCategory(err) == ErrorStashAuth {
+			return "", NewError(ErrorUnauthorized, "invalid stash credentials")
+		}
+
+		return "", err
+	}
+
+	return user, nil
+}
+
+func parseCapacity(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+
+	capacity, err := strconv.Atoi(value)
+	if err != nil || capacity < 0 {
+		return 0, NewError(
+			ErrorBadRequest, "invalid capacity %q, expected number", value,
+		)
+	}
+
+	return capacity, nil
+}
+
+// parseUntil accepts date, which means the start of that day in local
+// time, or RFC3339 timestamp.
+func parseUntil(value string) (time.Time, error) {
+	until, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		until, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return time.Time{}, NewError(
+				ErrorBadRequest,
+				"invalid date %q, expected YYYY-MM-DD", value,
+			)
+		}
+	}
+
+	if !until.After(time.Now()) {
+		return time.Time{}, NewError(
+			ErrorBadRequest, "date %q is in the past", value,
+		)
+	}
+
+	return until, nil
+}
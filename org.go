@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/ldap.v2"
+)
+
+type LDAPConfig struct {
+	Address          string `toml:"address"`
+	BindDN           string `toml:"bind_dn"`
+	BindPass         string `toml:"bind_pass"`
+	BaseDN           string `toml:"base_dn"`
+	UserFilter       string `toml:"user_filter"`
+	UserAttribute    string `toml:"user_attribute"`
+	ManagerAttribute string `toml:"manager_attribute"`
+}
+
+type OrgConfig struct {
+	ExcludeManager     bool `toml:"exclude_manager"`
+	RequireOutsideTeam bool `toml:"require_outside_team"`
+}
+
+type OrgChart struct {
+	address          string
+	bindDN           string
+	bindPass         string
+	baseDN           string
+	userFilter       string
+	userAttribute    string
+	managerAttribute string
+	timeout          time.Duration
+}
+
+type OrgContext struct {
+	Manager string
+	Team    []string
+}
+
+// NewOrgChart returns nil if ldap is not configured.
+func NewOrgChart(config LDAPConfig, timeout time.Duration) *OrgChart {
+	if config.Address == "" {
+		return nil
+	}
+
+	return &OrgChart{
+		address:          config.Address,
+		bindDN:           config.BindDN,
+		bindPass:         config.BindPass,
+		baseDN:           config.BaseDN,
+		userFilter:       config.UserFilter,
+		userAttribute:    config.UserAttribute,
+		managerAttribute: config.ManagerAttribute,
+		timeout:          timeout,
+	}
+}
+
+func (chart *OrgChart) connect() (*ldap.Conn, error) {
+	conn, err := ldap.Dial("tcp", chart.address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetTimeout(chart.timeout)
+
+	if chart.bindDN != "" {
+		err = conn.Bind(chart.bindDN, chart.bindPass)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+func (chart *OrgChart) search(
+	conn *ldap.Conn, baseDN string, scope int, filter string,
+) ([]*ldap.Entry, error) {
+	result, err := conn.Search(ldap.NewSearchRequest(
+		baseDN, scope, ldap.NeverDerefAliases, 0, 0, false,
+		filter, []string{chart.userAttribute, chart.managerAttribute}, nil,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Entries, nil
+}
+
+// GetContext returns direct manager of the user and the immediate team,
+// which is everybody who has the same manager.
+func (chart *OrgChart) GetContext(user string) (OrgContext, error) {
+	context := OrgContext{}
+
+	conn, err := chart.connect()
+	if err != nil {
+		return context, err
+	}
+
+	defer conn.Close()
+
+	entries, err := chart.search(
+		conn, chart.baseDN, ldap.ScopeWholeSubtree,
+		fmt.Sprintf(chart.userFilter, ldap.EscapeFilter(user)),
+	)
+	if err != nil {
+		return context, err
+	}
+
+	if len(entries) == 0 {
+		return context, fmt.Errorf("user %s is not found in ldap", user)
+	}
+
+	managerDN := entries[0].GetAttributeValue(chart.managerAttribute)
+	if managerDN == "" {
+		return context, nil
+	}
+
+	entries, err = chart.search(
+		conn, managerDN, ldap.ScopeBaseObject, "(objectClass=*)",
+	)
+	if err != nil {
+		return context, err
+	}
+
+	if len(entries) > 0 {
+		context.Manager = entries[0].GetAttributeValue(chart.userAttribute)
+	}
+
+	entries, err = chart.search(
+		conn, chart.baseDN, ldap.ScopeWholeSubtree,
+		fmt.Sprintf(
+			"(%s=%s)", chart.managerAttribute, ldap.EscapeFilter(managerDN),
+		),
+	)
+	if err != nil {
+		return context, err
+	}
+
+	for _, entry := range entries {
+		context.Team = append(
+			context.Team, entry.GetAttributeValue(chart.userAttribute),
+		)
+	}
+
+	return context, nil
+}
+
+// applyOrgRules removes author's manager from candidates if configured
+// and returns candidates from outside of author's team, which must be
+// represented in selection, or nil if there is no such requirement.
+// LDAP failures are logged and rules are not applied.
+func (server *SnobServer) applyOrgRules(
+	author string, users []string,
+) ([]string, []string) {
+	if server.org == nil {
+		return users, nil
+	}
+
+	var (
+		excludeManager     = server.config.Org.ExcludeManager
+		requireOutsideTeam = server.config.Org.RequireOutsideTeam
+	)
+
+	if !excludeManager && !requireOutsideTeam {
+		return users, nil
+	}
+
+	context, err := server.org.GetContext(author)
+	if err != nil {
+		logger.Errorf("can't apply org rules for %s: %s", author, err)
+		return users, nil
+	}
+
+	if excludeManager && context.Manager != "" {
+		users = excludeUsers(users, []string{context.Manager})
+	}
+
+	if !requireOutsideTeam {
+		return users, nil
+	}
+
+	outside := excludeUsers(users, context.Team)
+	if len(outside) == 0 {
+		logger.Infof(
+			"no candidates outside of %s's team: %s",
+			author, strings.Join(context.Team, ", "),
+		)
+	}
+
+	return users, outside
+}
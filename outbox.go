@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	NotificationSlack   = "slack"
+	NotificationEmail   = "email"
+	NotificationWebhook = "webhook"
+)
+
+type OutboxConfig struct {
+	File        string   `toml:"file"`
+	MaxAttempts int      `toml:"max_attempts"`
+	Backoff     Duration `toml:"backoff"`
+	MaxBackoff  Duration `toml:"max_backoff"`
+	MaxDead     int      `toml:"max_dead"`
+}
+
+// Notification is single outbound message, only fields of its Kind are
+// used.
+type Notification struct {
+	ID   string    `json:"id"`
+	Kind string    `json:"kind"`
+	Time time.Time `json:"time"`
+
+	URL     string          `json:"url,omitempty"`
+	Channel string          `json:"channel,omitempty"`
+	To      []string        `json:"to,omitempty"`
+	Subject string          `json:"subject,omitempty"`
+	Text    string          `json:"text,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// Outbox keeps notifications until they are delivered, so outage of chat
+// system or mail server doesn't drop them. Failed deliveries are retried
+// with exponential backoff, notifications which failed MaxAttempts times
+// are moved to dead letters, only MaxDead latest of which are kept. Outbox
+// is saved to the JSON file as a whole on every change if path is given.
+// The file is shared by daemon and commands like snobs add, so it's re-read
+// under lock before every change.
+type Outbox struct {
+	config  OutboxConfig
+	deliver func(*Notification) error
+
+	mutex   sync.Mutex
+	Pending []*Notification `json:"pending"`
+	Dead    []*Notification `json:"dead"`
+
+	// enqueued are ids of notifications queued by this process.
+	enqueued map[string]bool
+
+	wake chan struct{}
+}
+
+func OpenOutbox(
+	config OutboxConfig, deliver func(*Notification) error,
+) (*Outbox, error) {
+	outbox := &Outbox{
+		config:   config,
+		deliver:  deliver,
+		Pending:  []*Notification{},
+		Dead:     []*Notification{},
+		enqueued: map[string]bool{},
+		wake:     make(chan struct{}, 1),
+	}
+
+	err := outbox.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return outbox, nil
+}
+
+// Enqueue stores notification and wakes up delivery.
+func (outbox *Outbox) Enqueue(notification Notification) error {
+	now := time.Now()
+
+	// pid is included, since several processes may share the outbox
+	notification.ID = fmt.Sprintf("%d.%d", now.UnixNano(), os.Getpid())
+	notification.Time = now
+	notification.NextAttempt = now
+
+	err := outbox.update(func() bool {
+		outbox.Pending = append(outbox.Pending, &notification)
+		outbox.enqueued[notification.ID] = true
+
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case outbox.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Run delivers pending notifications until process exits.
+func (outbox *Outbox) Run() {
+	for {
+		wait := outbox.deliverDue(time.Now(), false)
+
+		select {
+		case <-outbox.wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Flush delivers notifications queued by this process right away, it's
+// used by commands which exit before Run would deliver them. Failed ones
+// are left pending for daemon.
+func (outbox *Outbox) Flush() {
+	outbox.deliverDue(time.Now(), true)
+}
+
+// deliverDue tries every notification which is due, or only those queued
+// by this process if own is set, and returns how long to wait for the next
+// one.
+func (outbox *Outbox) deliverDue(now time.Time, own bool) time.Duration {
+	due := []*Notification{}
+
+	err := outbox.update(func() bool {
+		for _, notification := range outbox.Pending {
+			if own && !outbox.enqueued[notification.ID] {
+				continue
+			}
+
+			if !notification.NextAttempt.After(now) {
+				due = append(due, notification)
+			}
+		}
+
+		return false
+	})
+	if err != nil {
+		logger.Errorf("can't load outbox: %s", err)
+
+		return outbox.config.Backoff.Duration
+	}
+
+	// Delivery is done without lock, so slow chat system doesn't block
+	// enqueueing of new notifications. Results are kept by id, since
+	// notifications are loaded from the file again afterwards.
+	results := map[string]error{}
+	for _, notification := range due {
+		results[notification.ID] = outbox.deliver(notification)
+	}
+
+	wait := outbox.config.MaxBackoff.Duration
+
+	err = outbox.update(func() bool {
+		outbox.collect(now, results)
+
+		for _, notification := range outbox.Pending {
+			if until := notification.NextAttempt.Sub(now); until < wait {
+				wait = until
+			}
+		}
+
+		return len(results) > 0
+	})
+	if err != nil {
+		logger.Errorf("can't save outbox: %s", err)
+	}
+
+	if wait < time.Second {
+		wait = time.Second
+	}
+
+	return wait
+}
+
+// collect removes delivered notifications and schedules next attempt of
+// failed ones. Notifications which are gone from pending are skipped, they
+// were handled by another process meanwhile.
+func (outbox *Outbox) collect(now time.Time, results map[string]error) {
+	pending := []*Notification{}
+	for _, notification := range outbox.Pending {
+		err, tried := results[notification.ID]
+		if !tried {
+			pending = append(pending, notification)
+			continue
+		}
+
+		if err == nil {
+			continue
+		}
+
+		notification.Attempts++
+		notification.LastError = err.Error()
+
+		if notification.Attempts >= outbox.config.MaxAttempts {
+			logger.Errorf(
+				"can't deliver %s notification %s after %d attempts, "+
+					"moved to dead letters: %s",
+				notification.Kind, notification.ID, notification.Attempts, err,
+			)
+
+			outbox.addDead(notification)
+			continue
+		}
+
+		logger.Errorf(
+			"can't deliver %s notification %s (attempt %d): %s",
+			notification.Kind, notification.ID, notification.Attempts, err,
+		)
+
+		notification.NextAttempt = now.Add(
+			outbox.getBackoff(notification.Attempts),
+		)
+
+		pending = append(pending, notification)
+	}
+
+	outbox.Pending = pending
+}
+
+// addDead adds notification to dead letters and drops the oldest ones over
+// the limit, so outbox which can't deliver anything doesn't grow forever.
+func (outbox *Outbox) addDead(notification *Notification) {
+	outbox.Dead = append(outbox.Dead, notification)
+
+	if drop := len(outbox.Dead) - outbox.config.MaxDead; drop > 0 {
+		logger.Warnf("dropping %d oldest dead notifications", drop)
+
+		outbox.Dead = append([]*Notification{}, outbox.Dead[drop:]...)
+	}
+}
+
+// OutboxContents is copy of outbox notifications, which is safe to use
+// without lock.
+type OutboxContents struct {
+	Pending []Notification `json:"pending"`
+	Dead    []Notification `json:"dead"`
+}
+
+// Contents returns copy of pending and dead notifications, outbox is loaded
+// from the file first, so notifications queued by other processes are
+// included.
+func (outbox *Outbox) Contents() (OutboxContents, error) {
+	contents := OutboxContents{
+		Pending: []Notification{},
+		Dead:    []Notification{},
+	}
+
+	err := outbox.update(func() bool {
+		for _, notification := range outbox.Pending {
+			contents.Pending = append(contents.Pending, *notification)
+		}
+
+		for _, notification := range outbox.Dead {
+			contents.Dead = append(contents.Dead, *notification)
+		}
+
+		return false
+	})
+
+	return contents, err
+}
+
+func (outbox *Outbox) getBackoff(attempts int) time.Duration {
+	backoff := outbox.config.Backoff.Duration
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= outbox.config.MaxBackoff.Duration {
+			return outbox.config.MaxBackoff.Duration
+		}
+	}
+
+	return backoff
+}
+
+// Retry moves all dead letters back to pending and returns their number.
+func (outbox *Outbox) Retry() (int, error) {
+	count := 0
+
+	err := outbox.update(func() bool {
+		count = len(outbox.Dead)
+		for _, notification := range outbox.Dead {
+			notification.Attempts = 0
+			notification.NextAttempt = time.Now()
+			outbox.Pending = append(outbox.Pending, notification)
+		}
+
+		outbox.Dead = []*Notification{}
+
+		return count > 0
+	})
+
+	select {
+	case outbox.wake <- struct{}{}:
+	default:
+	}
+
+	return count, err
+}
+
+// update loads outbox from the file under exclusive lock, applies change
+// and saves outbox back if change returns true, so changes made by other
+// processes are not overwritten. Lock is taken on separate file, because
+// outbox file is replaced on save.
+func (outbox *Outbox) update(change func() bool) error {
+	outbox.mutex.Lock()
+	defer outbox.mutex.Unlock()
+
+	if outbox.config.File == "" {
+		change()
+		return nil
+	}
+
+	lock, err := os.OpenFile(
+		outbox.config.File+".lock", os.O_RDWR|os.O_CREATE, 0644,
+	)
+	if err != nil {
+		return err
+	}
+
+	// lock is released when file is closed
+	defer lock.Close()
+
+	err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+
+	err = outbox.load()
+	if err != nil {
+		return err
+	}
+
+	if !change() {
+		return nil
+	}
+
+	return outbox.save()
+}
+
+// load replaces notifications with ones saved in the file. They are decoded
+// into new values, so notifications being delivered are not changed.
+func (outbox *Outbox) load() error {
+	if outbox.config.File == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(outbox.config.File)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	var saved struct {
+		Pending []*Notification `json:"pending"`
+		Dead    []*Notification `json:"dead"`
+	}
+
+	err = json.Unmarshal(data, &saved)
+	if err != nil {
+		return err
+	}
+
+	outbox.Pending = append([]*Notification{}, saved.Pending...)
+	outbox.Dead = append([]*Notification{}, saved.Dead...)
+
+	return nil
+}
+
+func (outbox *Outbox) save() error {
+	data, err := json.MarshalIndent(outbox, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	temporary := outbox.config.File + ".tmp"
+
+	err = ioutil.WriteFile(temporary, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temporary, outbox.config.File)
+}
+
+func (server *SnobServer) deliverNotification(notification *Notification) error {
+	defer server.hold()()
+
+	switch notification.Kind {
+	case NotificationSlack:
+		return postSlackMessage(
+			notification.URL, notification.Channel, notification.Text,
+		)
+
+	case NotificationEmail:
+		return sendMail(
+			server.config.SMTP, notification.To,
+			notification.Subject, notification.Text,
+		)
+
+	case NotificationWebhook:
+		return postWebhook(notification.URL, notification.Payload)
+
+	default:
+		return fmt.Errorf("unknown notification kind %q", notification.Kind)
+	}
+}
+
+func postWebhook(url string, payload []byte) error {
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	response, err := client.Post(
+		url, "application/json", bytes.NewReader(payload),
+	)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode >= 400 {
+		return fmt.Errorf("webhook responded with %s", response.Status)
+	}
+
+	return nil
+}
+
+// handleOutbox serves GET /v1/outbox with pending and dead notifications
+// and POST /v1/outbox which retries dead ones.
+func (server *SnobServer) handleOutbox(
+	response http.ResponseWriter, request *http.Request,
+) {
+	request, ok := server.authorize(response, request, OperationConfig)
+	if !ok {
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+
+	switch request.Method {
+	case "GET":
+		contents, err := server.outbox.Contents()
+		if err != nil {
+			server.reportError(response, err, http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(response).Encode(contents)
+
+	case "POST":
+		count, err := server.outbox.Retry()
+		if err != nil {
+			server.reportError(response, err, http.StatusInternalServerError)
+			return
+		}
+
+		writeResponse(response, http.StatusOK, APIResponse{
+			Success: true,
+			Retried: count,
+			Changed: count > 0,
+		})
+
+	default:
+		server.reportError(
+			response,
+			NewError(ErrorBadRequest, "method %s is not allowed", request.Method),
+			http.StatusMethodNotAllowed,
+		)
+	}
+}
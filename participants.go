@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// addReviewersChunked adds reviewers one by one via participants API,
+// running up to chunkSize requests concurrently, which works for large
+// reviewer sets that Stash rejects or times out on in single update.
+// Unlike update of pull request, existing reviewers are kept.
+func (server *SnobServer) addReviewersChunked(
+	project string, repository string, pullRequest string,
+	users []string, chunkSize int,
+) error {
+	added := 0
+
+	for start := 0; start < len(users); start += chunkSize {
+		end := start + chunkSize
+		if end > len(users) {
+			end = len(users)
+		}
+
+		var (
+			chunk  = users[start:end]
+			errs   = make([]error, len(chunk))
+			waiter = sync.WaitGroup{}
+		)
+
+		for index, user := range chunk {
+			waiter.Add(1)
+			go func(index int, user string) {
+				defer waiter.Done()
+
+				errs[index] = server.AddParticipant(
+					project, repository, pullRequest, user, "REVIEWER",
+				)
+			}(index, user)
+		}
+
+		waiter.Wait()
+
+		for index, err := range errs {
+			if err != nil {
+				return fmt.Errorf(
+					"can't add reviewer %s (%d of %d added): %s",
+					chunk[index], added, len(users), err,
+				)
+			}
+
+			added++
+		}
+
+		logger.WithPullRequest(project, repository, pullRequest).Infof(
+			"added %d of %d reviewers", added, len(users),
+		)
+	}
+
+	return nil
+}
+
+func (server *SnobServer) AddParticipant(
+	project string, repository string, pullRequest string,
+	user string, role string,
+) error {
+	payload := map[string]interface{}{
+		"user": map[string]interface{}{
+			"name": user,
+		},
+		"role": role,
+	}
+
+	request, err := server.repositoryResource(project, repository).
+		Res("pull-requests").Res(pullRequest).
+		Res("participants", &map[string]interface{}{}).
+		Post(payload)
+
+	return checkStashResponse(request, err)
+}
+
+func (server *SnobServer) RemoveParticipant(
+	project string, repository string, pullRequest string, user string,
+) error {
+	request, err := server.repositoryResource(project, repository).
+		Res("pull-requests").Res(pullRequest).
+		Res("participants").Res(user, &map[string]interface{}{}).
+		Delete()
+
+	return checkStashResponse(request, err)
+}
+
+type ResponseActivities struct {
+	Values []struct {
+		Action string `json:"action"`
+		User   struct {
+			Name string `json:"name"`
+		} `json:"user"`
+		RemovedReviewers []struct {
+			Name string `json:"name"`
+		} `json:"removedReviewers"`
+	} `json:"values"`
+	IsLastPage    bool `json:"isLastPage"`
+	NextPageStart int  `json:"nextPageStart"`
+}
+
+// GetWithdrawnReviewers returns users who removed themselves from reviewers
+// of the pull request.
+func (server *SnobServer) GetWithdrawnReviewers(
+	project string, repository string, pullRequest string,
+) ([]string, error) {
+	users := []string{}
+	start := 0
+
+	for {
+		request, err := server.repositoryResource(project, repository).
+			Res("pull-requests").Res(pullRequest).
+			Res("activities", &ResponseActivities{}).
+			Get(map[string]string{
+				"start": strconv.Itoa(start),
+				"limit": "500",
+			})
+
+		err = checkStashResponse(request, err)
+		if err != nil {
+			return nil, err
+		}
+
+		activities := request.Response.(*ResponseActivities)
+		for _, activity := range activities.Values {
+			if activity.Action != "UPDATED" {
+				continue
+			}
+
+			for _, removed := range activity.RemovedReviewers {
+				if strings.EqualFold(removed.Name, activity.User.Name) {
+					users = mergeUsers(users, []string{removed.Name})
+				}
+			}
+		}
+
+		if activities.IsLastPage || len(activities.Values) == 0 {
+			return users, nil
+		}
+
+		start = activities.NextPageStart
+	}
+}
+
+// getBowedOutUsers returns users who explicitly stepped back from the pull
+// request: reviewers who marked it as needing work, participants in other
+// roles and reviewers who removed themselves.
+func (server *SnobServer) getBowedOutUsers(selection *Selection) []string {
+	users := []string{}
+
+	for _, reviewer := range selection.Info.Reviewers {
+		if reviewer.Status == "NEEDS_WORK" {
+			users = append(users, reviewer.User.Name)
+		}
+	}
+
+	for _, participant := range selection.Info.Participants {
+		users = append(users, participant.User.Name)
+	}
+
+	if !server.isStash() {
+		return users
+	}
+
+	withdrawn, err := server.GetWithdrawnReviewers(
+		selection.Project, selection.Repository, selection.PullRequest,
+	)
+	if err != nil {
+		logger.WithPullRequest(
+			selection.Project, selection.Repository, selection.PullRequest,
+		).Errorf("can't get reviewers who withdrew: %s", err)
+	}
+
+	return mergeUsers(users, withdrawn)
+}
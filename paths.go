@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/bndr/gopencils"
+)
+
+// reStashURL matches path of pull request page, Stash may be served under
+// context path, and anything after pull request id, like /overview or
+// /diff, is ignored.
+var reStashURL = regexp.MustCompile(
+	`^/(?:.*?/)?` +
+		`(users|projects)/([^/]+)` +
+		`/repos/([^/]+)` +
+		`/pull-requests/(\d+)` +
+		`(?:/.*)?$`,
+)
+
+// gopencils joins resource names into url.URL.Path, which net/url escapes
+// when request is sent, so segments are passed to Res() decoded, and only
+// have to be valid single path segments.
+
+// decodePathSegment decodes percent-encoded segment of incoming path or
+// pull request URL and checks that it can be used as Stash path segment.
+func decodePathSegment(value string) (string, error) {
+	decoded, err := url.PathUnescape(value)
+	if err != nil {
+		return "", NewError(
+			ErrorBadRequest, "invalid path segment %q: %s", value, err,
+		)
+	}
+
+	if decoded == "" || decoded == "." || decoded == ".." ||
+		strings.Contains(decoded, "/") {
+		return "", NewError(ErrorBadRequest, "invalid path segment %q", value)
+	}
+
+	return decoded, nil
+}
+
+// splitRequestPath splits escaped request path into group and the rest,
+// so group names containing encoded slashes are kept intact.
+func splitRequestPath(request *http.Request) ([]string, error) {
+	return splitEscapedPath(request.URL.EscapedPath())
+}
+
+func splitEscapedPath(path string) ([]string, error) {
+	parts := strings.SplitN(strings.Trim(path, "/"), "/", 2)
+
+	for index, part := range parts {
+		decoded, err := url.PathUnescape(part)
+		if err != nil {
+			return nil, NewError(
+				ErrorBadRequest, "invalid request path: %s", err,
+			)
+		}
+
+		parts[index] = decoded
+	}
+
+	return parts, nil
+}
+
+func (server *SnobServer) repositoryResource(
+	project string, repository string,
+) *gopencils.Resource {
+	return server.api.Res("projects").Res(project).
+		Res("repos").Res(repository)
+}
+
+// ParsePullRequestURL returns project, repository and id of the pull
+// request given by its Stash URL, as copied from browser: query string and
+// fragment are stripped, as well as tab of pull request page. Repositories
+// of users are addressed by project ~USERNAME in API.
+func ParsePullRequestURL(pullRequestURL string) (string, string, string, error) {
+	parsed, err := url.Parse(strings.TrimSpace(pullRequestURL))
+	if err != nil {
+		return "", "", "", NewError(ErrorBadURL, "wrong url: %s", err)
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" || parsed.Host == "" {
+		return "", "", "", NewError(
+			ErrorBadURL, "wrong url: http or https url expected",
+		)
+	}
+
+	matches := reStashURL.FindStringSubmatch(parsed.EscapedPath())
+	if len(matches) == 0 {
+		return "", "", "", NewError(
+			ErrorBadURL, "wrong url: pull request path expected",
+		)
+	}
+
+	project, err := decodePathSegment(matches[2])
+	if err != nil {
+		return "", "", "", err
+	}
+
+	if matches[1] == "users" {
+		project = "~" + strings.ToUpper(project)
+	}
+
+	repository, err := decodePathSegment(matches[3])
+	if err != nil {
+		return "", "", "", err
+	}
+
+	return project, repository, matches[4], nil
+}
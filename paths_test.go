@@ -0,0 +1,115 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParsePullRequestURL(t *testing.T) {
+	tests := []struct {
+		url         string
+		project     string
+		repository  string
+		pullRequest string
+	}{
+		{
+			"http://stash/projects/PRJ/repos/repo/pull-requests/42",
+			"PRJ", "repo", "42",
+		},
+		{
+			"https://stash/projects/PRJ/repos/repo/pull-requests/42/",
+			"PRJ", "repo", "42",
+		},
+		{
+			"https://stash/projects/PRJ/repos/repo/pull-requests/42/overview",
+			"PRJ", "repo", "42",
+		},
+		{
+			"https://stash/projects/PRJ/repos/repo/pull-requests/42/diff#a.go",
+			"PRJ", "repo", "42",
+		},
+		{
+			"https://stash/projects/PRJ/repos/repo/pull-requests/42/commits/abc",
+			"PRJ", "repo", "42",
+		},
+		{
+			"https://stash/projects/PRJ/repos/repo/pull-requests/42?commentId=7",
+			"PRJ", "repo", "42",
+		},
+		{
+			"https://stash/projects/PRJ/repos/repo/pull-requests/42" +
+				"/overview?commentId=7#comment-7",
+			"PRJ", "repo", "42",
+		},
+		{
+			"https://stash/projects/PRJ/repos/repo/pull-requests/42#top",
+			"PRJ", "repo", "42",
+		},
+		{
+			"  https://stash/projects/PRJ/repos/repo/pull-requests/42\n",
+			"PRJ", "repo", "42",
+		},
+		{
+			"https://host/stash/projects/PRJ/repos/repo/pull-requests/42",
+			"PRJ", "repo", "42",
+		},
+		{
+			"https://stash/users/john/repos/dotfiles/pull-requests/3",
+			"~JOHN", "dotfiles", "3",
+		},
+		{
+			"https://stash/users/john/repos/dotfiles/pull-requests/3/overview",
+			"~JOHN", "dotfiles", "3",
+		},
+		{
+			"https://stash/projects/PRJ/repos/my%20repo/pull-requests/42",
+			"PRJ", "my repo", "42",
+		},
+		{
+			"https://stash/projects/PR%2BJ/repos/repo%2Ename/pull-requests/42",
+			"PR+J", "repo.name", "42",
+		},
+	}
+
+	for _, test := range tests {
+		project, repository, pullRequest, err := ParsePullRequestURL(test.url)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", test.url, err)
+			continue
+		}
+
+		if project != test.project || repository != test.repository ||
+			pullRequest != test.pullRequest {
+			t.Errorf(
+				"%q: got %s/%s/%s, want %s/%s/%s", test.url,
+				project, repository, pullRequest,
+				test.project, test.repository, test.pullRequest,
+			)
+		}
+	}
+}
+
+func TestParsePullRequestURLErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"stash/projects/PRJ/repos/repo/pull-requests/42",
+		"/projects/PRJ/repos/repo/pull-requests/42",
+		"ftp://stash/projects/PRJ/repos/repo/pull-requests/42",
+		"ssh://git@stash/projects/PRJ/repos/repo/pull-requests/42",
+		"file:///projects/PRJ/repos/repo/pull-requests/42",
+		"https:///projects/PRJ/repos/repo/pull-requests/42",
+		"https://stash/projects/PRJ/repos/repo",
+		"https://stash/projects/PRJ/repos/repo/pull-requests",
+		"https://stash/projects/PRJ/repos/repo/pull-requests/abc",
+		"https://stash/projects/PRJ/repos/repo/browse?at=pull-requests/42",
+		"https://stash/projects/PRJ/repos/re%2Fpo/pull-requests/42",
+		"https://stash/projects/PRJ/repos/%2E%2E/pull-requests/42",
+		"https://stash/projects/PRJ/repos/re%zzpo/pull-requests/42",
+	}
+
+	for _, pullRequestURL := range tests {
+		_, _, _, err := ParsePullRequestURL(pullRequestURL)
+		if err == nil {
+			t.Errorf("%q: error expected", pullRequestURL)
+		}
+	}
+}
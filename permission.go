@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const SkipNoPermission = "no_permission"
+
+// filterPermitted skips candidates who can't read the repository, Stash
+// rejects the whole update if any of reviewers has no access. Permissions
+// are checked once per selection, candidates are kept if Stash can't tell.
+func (server *SnobServer) filterPermitted(
+	selection *Selection, users []string,
+) []string {
+	if !server.config.CheckPermission ||
+		server.config.Backend != BackendStash || len(users) == 0 {
+		return users
+	}
+
+	if selection.readers == nil {
+		selection.readers = map[string]bool{}
+	}
+
+	unchecked := []string{}
+	for _, user := range users {
+		if _, ok := selection.readers[normalizeUser(user)]; !ok {
+			unchecked = append(unchecked, user)
+		}
+	}
+
+	permitted := make([]bool, len(unchecked))
+	failed := make([]error, len(unchecked))
+
+	var check errgroup.Group
+	check.SetLimit(server.config.GroupFetchParallel)
+
+	for index, user := range unchecked {
+		index, user := index, user
+
+		check.Go(func() error {
+			permitted[index], failed[index] = server.canReadRepository(
+				selection.Project, selection.Repository, user,
+			)
+
+			return nil
+		})
+	}
+
+	check.Wait()
+
+	for index, user := range unchecked {
+		if failed[index] != nil {
+			logger.Warnf(
+				"can't check permission of %s on %s/%s: %s",
+				user, selection.Project, selection.Repository, failed[index],
+			)
+			continue
+		}
+
+		selection.readers[normalizeUser(user)] = permitted[index]
+	}
+
+	denied := []string{}
+	for _, user := range users {
+		if readable, ok := selection.readers[normalizeUser(user)]; ok &&
+			!readable {
+			denied = append(denied, user)
+		}
+	}
+
+	return selection.skip(users, denied, SkipNoPermission)
+}
+
+// canReadRepository checks effective permission of the user, granted
+// directly, through group, project or public access.
+func (server *SnobServer) canReadRepository(
+	project string, repository string, user string,
+) (bool, error) {
+	request, err := server.api.Res("users", &ResponseUsers{}).Get(
+		map[string]string{
+			"filter":                    user,
+			"permission":                "REPO_READ",
+			"permission.projectKey":     project,
+			"permission.repositorySlug": repository,
+			"limit":                     "100",
+		},
+	)
+
+	err = checkStashResponse(request, err)
+	if err != nil {
+		return false, err
+	}
+
+	// filter matches display names and emails as well
+	for _, found := range request.Response.(*ResponseUsers).Users {
+		if strings.EqualFold(found.Name, user) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
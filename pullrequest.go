@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type PullRequestParticipant struct {
+	Name     string `json:"name"`
+	Role     string `json:"role,omitempty"`
+	Approved bool   `json:"approved"`
+	Status   string `json:"status,omitempty"`
+}
+
+// PullRequestStatus is current state of pull request as reported by Stash,
+// Approved is set when every reviewer approved it.
+type PullRequestStatus struct {
+	Success      bool                     `json:"success"`
+	Project      string                   `json:"project"`
+	Repository   string                   `json:"repository"`
+	PullRequest  string                   `json:"pull_request"`
+	URL          string                   `json:"url,omitempty"`
+	Title        string                   `json:"title"`
+	State        string                   `json:"state"`
+	Author       string                   `json:"author"`
+	Reviewers    []PullRequestParticipant `json:"reviewers"`
+	Participants []PullRequestParticipant `json:"participants"`
+	Approvals    int                      `json:"approvals"`
+	Approved     bool                     `json:"approved"`
+}
+
+// GetPullRequestStatus fetches current reviewers and participants of the
+// pull request, it's never cached.
+func (server *SnobServer) GetPullRequestStatus(
+	key *APIKey, pullRequestURL string,
+) (*PullRequestStatus, error) {
+	if instance := server.getInstance(pullRequestURL); instance != server {
+		return instance.GetPullRequestStatus(key, pullRequestURL)
+	}
+
+	project, repository, pullRequest, err := server.backend.ParsePullRequestURL(
+		pullRequestURL,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = server.checkRepositoryAccess(project, repository)
+	if err != nil {
+		return nil, err
+	}
+
+	if key != nil && !key.AllowsRepository(project, repository) {
+		return nil, NewError(
+			ErrorForbidden, "key %s is not allowed to read repository %s/%s",
+			key.Name, project, repository,
+		)
+	}
+
+	info, err := server.GetPullRequestInfo(project, repository, pullRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &PullRequestStatus{
+		Success:      true,
+		Project:      project,
+		Repository:   repository,
+		PullRequest:  pullRequest,
+		URL:          info.GetURL(),
+		Title:        info.Title,
+		State:        info.State,
+		Author:       info.Author.User.Name,
+		Reviewers:    getPullRequestParticipants(info.Reviewers),
+		Participants: getPullRequestParticipants(info.Participants),
+	}
+
+	for _, reviewer := range info.Reviewers {
+		if reviewer.Approved {
+			status.Approvals++
+		}
+	}
+
+	status.Approved = len(info.Reviewers) > 0 &&
+		status.Approvals == len(info.Reviewers)
+
+	return status, nil
+}
+
+func getPullRequestParticipants(
+	participants []ResponseParticipant,
+) []PullRequestParticipant {
+	result := []PullRequestParticipant{}
+	for _, participant := range participants {
+		result = append(result, PullRequestParticipant{
+			Name:     participant.User.Name,
+			Role:     participant.Role,
+			Approved: participant.Approved,
+			Status:   participant.Status,
+		})
+	}
+
+	return result
+}
+
+// handlePullRequest serves GET /pull-request?url=<pull request>.
+func (server *SnobServer) handlePullRequest(
+	response http.ResponseWriter, request *http.Request,
+) {
+	if request.Method != "GET" {
+		server.reportError(
+			response,
+			NewError(ErrorBadRequest, "method %s is not allowed", request.Method),
+			http.StatusMethodNotAllowed,
+		)
+		return
+	}
+
+	request, ok := server.authorize(response, request, OperationGroups)
+	if !ok {
+		return
+	}
+
+	pullRequestURL := request.URL.Query().Get("url")
+	if pullRequestURL == "" {
+		server.reportError(
+			response,
+			NewError(ErrorBadURL, "url is required"),
+			http.StatusBadRequest,
+		)
+		return
+	}
+
+	status, err := server.GetPullRequestStatus(
+		getRequestAPIKey(request), pullRequestURL,
+	)
+	if err != nil {
+		server.reportError(response, err, getErrorStatus(err))
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(response).Encode(status)
+}
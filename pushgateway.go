@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeSkipped = "skipped"
+	OutcomeQueued  = "queued"
+	OutcomeDryRun  = "dry_run"
+	OutcomeError   = "error"
+)
+
+// RunMetrics describes single one-shot run, which can't be scraped, so it
+// is pushed to Prometheus Pushgateway instead.
+type RunMetrics struct {
+	Finished  time.Time
+	Duration  time.Duration
+	Outcome   string
+	Reviewers int
+}
+
+type PushgatewayConfig struct {
+	URL      string   `toml:"url"`
+	Job      string   `toml:"job"`
+	Instance string   `toml:"instance"`
+	Timeout  Duration `toml:"timeout"`
+}
+
+// pushRunMetrics replaces metrics of the job group in Pushgateway, nothing
+// is pushed if pushgateway is not configured.
+func pushRunMetrics(
+	config PushgatewayConfig, metrics *Metrics, run RunMetrics,
+) error {
+	if config.URL == "" {
+		return nil
+	}
+
+	target := strings.TrimRight(config.URL, "/") +
+		"/metrics/job/" + url.PathEscape(config.Job)
+
+	if config.Instance != "" {
+		target += "/instance/" + url.PathEscape(config.Instance)
+	}
+
+	body := &bytes.Buffer{}
+
+	writeGauge(
+		body, "snobs_run_duration_seconds",
+		"Duration of the last run.", run.Duration.Seconds(),
+	)
+	writeGauge(
+		body, "snobs_run_last_timestamp_seconds",
+		"Time the last run finished.", float64(run.Finished.Unix()),
+	)
+	writeGauge(
+		body, "snobs_run_reviewers_added",
+		"Reviewers added by the last run.", float64(run.Reviewers),
+	)
+
+	fmt.Fprintf(body, "# HELP snobs_run_outcome Outcome of the last run.\n")
+	fmt.Fprintf(body, "# TYPE snobs_run_outcome gauge\n")
+	for _, outcome := range []string{
+		OutcomeSuccess, OutcomeSkipped, OutcomeQueued, OutcomeDryRun,
+		OutcomeError,
+	} {
+		value := 0
+		if outcome == run.Outcome {
+			value = 1
+		}
+
+		fmt.Fprintf(body, "snobs_run_outcome{outcome=%q} %d\n", outcome, value)
+	}
+
+	metrics.Expose(body)
+
+	request, err := http.NewRequest("PUT", target, body)
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	response, err := (&http.Client{Timeout: config.Timeout.Duration}).Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("pushgateway responded with %s", response.Status)
+	}
+
+	return nil
+}
+
+func writeGauge(body *bytes.Buffer, name string, help string, value float64) {
+	fmt.Fprintf(body, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(body, "# TYPE %s gauge\n", name)
+	fmt.Fprintf(body, "%s %g\n", name, value)
+}
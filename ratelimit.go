@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// tokenBucketScript implements token bucket in Redis, so all replicas share
+// the same budget. Bucket state is stored as hash with number of tokens and
+// time of last update; script returns number of milliseconds caller should
+// wait before next token is available or 0 if token was taken.
+const tokenBucketScript = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(state[1]) or burst
+local updated = tonumber(state[2]) or now
+
+tokens = math.min(burst, tokens + math.max(0, now - updated) * rate)
+
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = math.ceil((1 - tokens) / rate)
+end
+
+redis.call('HMSET', KEYS[1], 'tokens', tokens, 'updated', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate) + 1000)
+
+return wait
+`
+
+// localBucketsLimit is number of local buckets after which buckets which
+// are full again are dropped, so buckets of clients don't pile up.
+const localBucketsLimit = 10000
+
+type RateLimit struct {
+	// Rate is number of tokens added per millisecond.
+	Rate  float64
+	Burst float64
+}
+
+type TokenBuckets interface {
+	Take(key string, limit RateLimit) (time.Duration, error)
+}
+
+type RateLimiter struct {
+	buckets TokenBuckets
+	stash   *RateLimit
+	groups  *RateLimit
+	clients *RateLimit
+}
+
+type localBuckets struct {
+	mutex   sync.Mutex
+	buckets map[string]*localBucket
+}
+
+type localBucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time
+}
+
+type redisBuckets struct {
+	pool     *redis.Pool
+	prefix   string
+	script   *redis.Script
+	fallback *localBuckets
+}
+
+type throttledTransport struct {
+	limiter *RateLimiter
+	timeout time.Duration
+	next    http.RoundTripper
+}
+
+type RateLimitConfig struct {
+	Redis       string           `toml:"redis"`
+	RedisPrefix string           `toml:"redis_prefix"`
+	Stash       *RateLimitBucket `toml:"stash"`
+	Groups      *RateLimitBucket `toml:"groups"`
+
+	// Clients limits requests of every caller, identified by API key or,
+	// if request has no valid key, by address.
+	Clients *RateLimitBucket `toml:"clients"`
+}
+
+type RateLimitBucket struct {
+	Rate   int64    `toml:"rate"`
+	Period Duration `toml:"period"`
+	Burst  int64    `toml:"burst"`
+}
+
+func getRateLimiter(config RateLimitConfig) (*RateLimiter, error) {
+	limiter := &RateLimiter{
+		buckets: newLocalBuckets(),
+	}
+
+	var err error
+
+	limiter.stash, err = getRateLimit("ratelimit.stash", config.Stash)
+	if err != nil {
+		return nil, err
+	}
+
+	limiter.groups, err = getRateLimit("ratelimit.groups", config.Groups)
+	if err != nil {
+		return nil, err
+	}
+
+	limiter.clients, err = getRateLimit("ratelimit.clients", config.Clients)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Redis != "" {
+		limiter.buckets = newRedisBuckets(config.Redis, config.RedisPrefix)
+	}
+
+	return limiter, nil
+}
+
+// getRateLimit returns nil limit if bucket is not configured, period
+// defaults to second and burst defaults to rate. Rate is counted per
+// millisecond, so shorter periods are rejected.
+func getRateLimit(name string, bucket *RateLimitBucket) (*RateLimit, error) {
+	if bucket == nil {
+		return nil, nil
+	}
+
+	if bucket.Rate <= 0 {
+		return nil, fmt.Errorf("%s.rate should be positive", name)
+	}
+
+	period := bucket.Period.Duration
+	if period <= 0 {
+		period = time.Second
+	}
+
+	if period < time.Millisecond {
+		return nil, fmt.Errorf("%s.period should be at least 1ms", name)
+	}
+
+	burst := bucket.Burst
+	if burst <= 0 {
+		burst = bucket.Rate
+	}
+
+	return &RateLimit{
+		Rate:  float64(bucket.Rate) / float64(period/time.Millisecond),
+		Burst: float64(burst),
+	}, nil
+}
+
+// WaitStash blocks until outbound Stash request is allowed by the stash
+// rate limit or context is done.
+func (limiter *RateLimiter) WaitStash(ctx context.Context) error {
+	if limiter.stash == nil {
+		return nil
+	}
+
+	for {
+		wait, err := limiter.buckets.Take("stash", *limiter.stash)
+		if err != nil {
+			return err
+		}
+
+		if wait == 0 {
+			return nil
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return NewError(
+				ErrorRateLimited, "stash rate limit exceeded: %s", ctx.Err(),
+			)
+		}
+	}
+}
+
+// TakeGroup takes token from assignment bucket of given group and returns
+// time to wait before next assignment if bucket is empty.
+func (limiter *RateLimiter) TakeGroup(group string) (time.Duration, error) {
+	if limiter.groups == nil {
+		return 0, nil
+	}
+
+	return limiter.buckets.Take("group:"+group, *limiter.groups)
+}
+
+// TakeClient takes token from bucket of the caller and returns time to wait
+// before next request if bucket is empty.
+func (limiter *RateLimiter) TakeClient(client string) (time.Duration, error) {
+	if limiter.clients == nil {
+		return 0, nil
+	}
+
+	return limiter.buckets.Take("client:"+client, *limiter.clients)
+}
+
+// checkClientRate takes token of the caller, caller is identified by API key
+// if request has valid one and by address otherwise.
+func (server *SnobServer) checkClientRate(request *http.Request) error {
+	if server.limiter.clients == nil {
+		return nil
+	}
+
+	client := "ip:" + getClientIP(request, server.config.TrustProxy).String()
+	if len(server.keys) > 0 {
+		key, err := server.authenticate(request)
+		if err == nil {
+			client = "key:" + key.Name
+		}
+	}
+
+	wait, err := server.limiter.TakeClient(client)
+	if err != nil {
+		return err
+	}
+
+	if wait > 0 {
+		err := NewError(ErrorRateLimited, "rate limit of %s is exceeded", client)
+		err.RetryAfter = wait
+
+		return err
+	}
+
+	return nil
+}
+
+func newLocalBuckets() *localBuckets {
+	return &localBuckets{
+		buckets: map[string]*localBucket{},
+	}
+}
+
+func (buckets *localBuckets) Take(
+	key string, limit RateLimit,
+) (time.Duration, error) {
+	buckets.mutex.Lock()
+	defer buckets.mutex.Unlock()
+
+	now := time.Now()
+
+	if len(buckets.buckets) >= localBucketsLimit {
+		buckets.prune(now)
+	}
+
+	bucket, ok := buckets.buckets[key]
+	if !ok {
+		bucket = &localBucket{tokens: limit.Burst, updated: now}
+		buckets.buckets[key] = bucket
+	}
+
+	elapsed := float64(now.Sub(bucket.updated)) / float64(time.Millisecond)
+
+	bucket.tokens = math.Min(limit.Burst, bucket.tokens+elapsed*limit.Rate)
+	bucket.updated = now
+
+	taken := bucket.tokens >= 1
+	if taken {
+		bucket.tokens--
+	}
+
+	bucket.full = now.Add(time.Duration(
+		math.Ceil((limit.Burst-bucket.tokens)/limit.Rate),
+	) * time.Millisecond)
+
+	if taken {
+		return 0, nil
+	}
+
+	wait := math.Ceil((1 - bucket.tokens) / limit.Rate)
+
+	return time.Duration(wait) * time.Millisecond, nil
+}
+
+// prune drops buckets which are refilled, they are the same as new ones.
+func (buckets *localBuckets) prune(now time.Time) {
+	for key, bucket := range buckets.buckets {
+		if !bucket.full.After(now) {
+			delete(buckets.buckets, key)
+		}
+	}
+}
+
+func newRedisBuckets(address string, prefix string) *redisBuckets {
+	return &redisBuckets{
+		pool: &redis.Pool{
+			MaxIdle:     4,
+			IdleTimeout: time.Minute,
+			Dial: func() (redis.Conn, error) {
+				return redis.Dial(
+					"tcp", address,
+					redis.DialConnectTimeout(time.Second),
+					redis.DialReadTimeout(time.Second),
+					redis.DialWriteTimeout(time.Second),
+				)
+			},
+		},
+		prefix:   prefix,
+		script:   redis.NewScript(1, tokenBucketScript),
+		fallback: newLocalBuckets(),
+	}
+}
+
+// Take falls back to local bucket when Redis is not available, so an
+// outage of Redis degrades limits to per-replica instead of blocking all
+// requests.
+func (buckets *redisBuckets) Take(
+	key string, limit RateLimit,
+) (time.Duration, error) {
+	conn := buckets.pool.Get()
+	defer conn.Close()
+
+	wait, err := redis.Int64(buckets.script.Do(
+		conn,
+		buckets.prefix+":ratelimit:"+key,
+		limit.Rate,
+		limit.Burst,
+		time.Now().UnixNano()/int64(time.Millisecond),
+	))
+	if err != nil {
+		logger.Errorf("can't use redis rate limit, using local one: %s", err)
+
+		return buckets.fallback.Take(key, limit)
+	}
+
+	return time.Duration(wait) * time.Millisecond, nil
+}
+
+func (transport *throttledTransport) RoundTrip(
+	request *http.Request,
+) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(request.Context(), transport.timeout)
+	defer cancel()
+
+	err := transport.limiter.WaitStash(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return transport.next.RoundTrip(request)
+}
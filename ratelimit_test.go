@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLocalBucketsRefillBetweenMilliseconds(t *testing.T) {
+	buckets := newLocalBuckets()
+
+	// one token per millisecond, taken many times per millisecond
+	limit := RateLimit{Rate: 1, Burst: 1}
+
+	taken := 0
+	for started := time.Now(); time.Since(started) < 50*time.Millisecond; {
+		wait, err := buckets.Take("key", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if wait == 0 {
+			taken++
+		}
+	}
+
+	if taken < 10 {
+		t.Errorf("bucket is not refilled: %d tokens taken in 50ms", taken)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleReloads reloads configuration and TLS certificate, if server
+// serves HTTPS, on SIGHUP. Previous configuration and certificate are kept
+// if new ones can't be loaded.
+func (server *SnobServer) handleReloads(reloader *certificateReloader) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		if server.configPath != "" {
+			err := server.Reload()
+			if err != nil {
+				logger.Errorf("can't reload config: %s", err)
+			} else {
+				logger.Infof("config reloaded from %s", server.configPath)
+			}
+		}
+
+		if reloader != nil {
+			err := reloader.Reload()
+			if err != nil {
+				logger.Errorf("%s", err)
+			} else {
+				logger.Infof("tls certificate reloaded from %s", reloader.certPath)
+			}
+		}
+	}
+}
+
+// Reload re-reads configuration file, applies it and rebuilds Stash client,
+// cached groups are dropped because they might come from different Stash
+// or be resolved differently. Requests which are in flight finish with the
+// previous configuration. Listen address, storage files, rate limits and
+// integrations are not changed until restart.
+func (server *SnobServer) Reload() error {
+	config, err := getConfig(server.configPath)
+	if err != nil {
+		return err
+	}
+
+	err = server.reloadConfig(config)
+	if err != nil {
+		return err
+	}
+
+	configureLogging(config)
+
+	for name, tenant := range server.tenants {
+		tenantConfig, ok := config.tenants[name]
+		if !ok {
+			logger.Infof("tenant %s is removed, it's served until restart", name)
+			continue
+		}
+
+		err := tenant.reloadConfig(tenantConfig)
+		if err != nil {
+			logger.Errorf("can't reload config of tenant %s: %s", name, err)
+		}
+	}
+
+	for name := range config.tenants {
+		if _, ok := server.tenants[name]; !ok {
+			logger.Infof("tenant %s is added, it's served after restart", name)
+		}
+	}
+
+	return nil
+}
+
+// reloadConfig applies config of this server only, without tenants. New
+// config, keys and clients are built aside and swapped in at once, so
+// nothing is changed if any of them can't be built.
+func (server *SnobServer) reloadConfig(config *Config) error {
+	next := &SnobServer{
+		configPath:   server.configPath,
+		metrics:      server.metrics,
+		limiter:      server.limiter,
+		jira:         server.jira,
+		org:          server.org,
+		history:      server.history,
+		availability: server.availability,
+		auditLog:     server.auditLog,
+		oidc:         server.oidc,
+		statsd:       server.statsd,
+		events:       server.events,
+		outbox:       server.outbox,
+		maintenance:  server.maintenance,
+		rotation:     server.rotation,
+	}
+
+	err := next.SetConfig(config)
+	if err != nil {
+		return err
+	}
+
+	err = next.setStashClient()
+	if err != nil {
+		return err
+	}
+
+	backend, err := next.getBackend()
+	if err != nil {
+		return err
+	}
+
+	err = next.setInstances()
+	if err != nil {
+		return err
+	}
+
+	server.reload.Lock()
+	defer server.reload.Unlock()
+
+	server.config = next.config
+	server.keys = next.keys
+	server.experts = next.experts
+	server.routes = next.routes
+	server.allowlist = next.allowlist
+	server.staticGroups = next.staticGroups
+	server.stashURL = next.stashURL
+	server.httpClient = next.httpClient
+	server.api = next.api
+	server.settingsAPI = next.settingsAPI
+	server.instances = next.instances
+
+	// stash backend calls server it belongs to, not the one it was built on
+	if _, ok := backend.(*StashBackend); ok {
+		backend = &StashBackend{server: server}
+	}
+
+	server.backend = backend
+
+	server.availability.SetConfigured(config.Availability.Unavailable)
+
+	server.maintenance.SetWindows(config.Maintenance.Windows)
+
+	server.cache.SetTTL(config.CacheTTL.Duration)
+	server.cache.Clear()
+
+	return nil
+}
+
+// hold keeps current config and clients until returned function is
+// called, reload waits for every holder. It's taken once per request and
+// per round of background job, never recursively.
+func (server *SnobServer) hold() func() {
+	server.reload.RLock()
+
+	return server.reload.RUnlock
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/http"
+)
+
+// RemoveGroupReviewers removes reviewers who are members of the group from
+// the pull request, it's used when pull request is retargeted to another
+// team or was assigned to the group by mistake.
+func (server *SnobServer) RemoveGroupReviewers(
+	key *APIKey, usergroup string, pullRequestURL string, dryRun bool,
+) (*Assignment, error) {
+	if instance := server.getInstance(pullRequestURL); instance != server {
+		return instance.RemoveGroupReviewers(
+			key, usergroup, pullRequestURL, dryRun,
+		)
+	}
+
+	project, repository, pullRequest, err := server.backend.ParsePullRequestURL(
+		pullRequestURL,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return server.removeGroupReviewers(
+		key, usergroup, project, repository, pullRequest, dryRun,
+	)
+}
+
+func (server *SnobServer) removeGroupReviewers(
+	key *APIKey, usergroup string,
+	project string, repository string, pullRequest string, dryRun bool,
+) (*Assignment, error) {
+	err := server.checkMaintenance()
+	if err != nil {
+		return nil, err
+	}
+
+	err = server.checkPullRequestAccess(key, project, repository)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkGroupAccess(key, usergroup)
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := server.getCachedUsers(usergroup)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := server.GetPullRequestInfo(project, repository, pullRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	current := []string{}
+	for _, reviewer := range info.Reviewers {
+		current = append(current, reviewer.User.Name)
+	}
+
+	removed := getIntersection(current, members)
+
+	assignment := &Assignment{
+		Project:     project,
+		Repository:  repository,
+		PullRequest: pullRequest,
+		Group:       usergroup,
+		Info:        info,
+		Reviewers:   removed,
+	}
+
+	log := logger.WithPullRequest(project, repository, pullRequest)
+
+	if dryRun || server.config.DryRun {
+		log.Infof("dry run, would remove reviewers %v of %s", removed, usergroup)
+
+		assignment.DryRun = true
+		return assignment, nil
+	}
+
+	if len(removed) == 0 {
+		log.Infof("no reviewers of %s to remove", usergroup)
+
+		assignment.Skipped = true
+		return assignment, nil
+	}
+
+	err = server.backend.RemoveReviewers(
+		project, repository, pullRequest, info, removed,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("removed reviewers %v of %s", removed, usergroup)
+
+	server.events.Publish(Event{
+		Type:        EventRemove,
+		Project:     project,
+		Repository:  repository,
+		PullRequest: pullRequest,
+		URL:         info.GetURL(),
+		Author:      info.Author.User.Name,
+		Group:       usergroup,
+		Reviewers:   removed,
+	})
+
+	return assignment, nil
+}
+
+// handleRemoveReviewers serves DELETE /%group%/%pull-request%.
+func (server *SnobServer) handleRemoveReviewers(
+	response http.ResponseWriter, request *http.Request,
+	usergroup, pullRequestURL string,
+) {
+	assignment, err := server.RemoveGroupReviewers(
+		getRequestAPIKey(request), usergroup, pullRequestURL,
+		request.URL.Query().Get("dry_run") == "1",
+	)
+
+	server.writeRemoval(
+		response, request, AuditEntry{Group: usergroup}, pullRequestURL,
+		assignment, err,
+	)
+}
+
+// writeRemoval audits removal of reviewers, both successful and failed, and
+// responds with removed reviewers.
+func (server *SnobServer) writeRemoval(
+	response http.ResponseWriter, request *http.Request,
+	entry AuditEntry, pullRequestURL string,
+	assignment *Assignment, err error,
+) {
+	entry.Action = AuditRemove
+
+	if err != nil {
+		server.auditFailure(request, entry, pullRequestURL, err)
+		server.reportError(response, err, getErrorStatus(err))
+		return
+	}
+
+	if assignment.Changed() {
+		entry = assignment.AuditEntry()
+		entry.Action = AuditRemove
+
+		server.audit(request, entry)
+	}
+
+	writeResponse(response, http.StatusOK, APIResponse{
+		Success: true,
+		Removed: assignment.Reviewers,
+		DryRun:  assignment.DryRun,
+		Changed: assignment.Changed() && len(assignment.Reviewers) > 0,
+	})
+}
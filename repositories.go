@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+func (server *SnobServer) checkRepositoryAccess(
+	project string, repository string,
+) error {
+	var (
+		allowed = server.config.AllowRepositories
+		denied  = server.config.DenyRepositories
+		name    = project + "/" + repository
+	)
+
+	if pattern, ok := matchRepository(denied, name); ok {
+		return NewError(
+			ErrorForbidden,
+			"repository %s is not managed by snobs "+
+				"(denied by pattern %q)",
+			name, pattern,
+		)
+	}
+
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	if _, ok := matchRepository(allowed, name); !ok {
+		return NewError(
+			ErrorForbidden,
+			"repository %s is not managed by snobs "+
+				"(not listed in allow_repositories)",
+			name,
+		)
+	}
+
+	return nil
+}
+
+// matchRepository matches PROJECT/repo name against glob patterns,
+// case-insensitively, because Stash accepts project keys in any case.
+func matchRepository(patterns []string, name string) (string, bool) {
+	for _, pattern := range patterns {
+		matched, _ := path.Match(
+			strings.ToLower(pattern), strings.ToLower(name),
+		)
+		if matched {
+			return pattern, true
+		}
+	}
+
+	return "", false
+}
+
+func validateRepositoryPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if strings.Count(pattern, "/") != 1 {
+			return fmt.Errorf(
+				"repository pattern %q should look like PROJECT/repo",
+				pattern,
+			)
+		}
+
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return fmt.Errorf(
+				"invalid repository pattern %q: %s", pattern, err,
+			)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+
+	// requestIDMaxLength limits accepted request id, so callers can't
+	// flood logs through it.
+	requestIDMaxLength = 128
+)
+
+// withRequestID takes request id given by caller or generates new one,
+// echoes it in response header and keeps it in request context.
+func withRequestID(
+	response http.ResponseWriter, request *http.Request,
+) *http.Request {
+	id := request.Header.Get(requestIDHeader)
+	if !isValidRequestID(id) {
+		id = getRandomToken()
+	}
+
+	response.Header().Set(requestIDHeader, id)
+
+	return request.WithContext(
+		context.WithValue(request.Context(), contextKeyRequestID, id),
+	)
+}
+
+func getRequestID(request *http.Request) string {
+	id, _ := request.Context().Value(contextKeyRequestID).(string)
+	return id
+}
+
+// getRequestLogger returns logger which marks records with request id,
+// remote address of the request and client certificate subject, if any.
+func getRequestLogger(request *http.Request) *Logger {
+	fields := LogFields{"remote": request.RemoteAddr}
+	if id := getRequestID(request); id != "" {
+		fields["request_id"] = id
+	}
+
+	if request.TLS != nil && len(request.TLS.PeerCertificates) > 0 {
+		fields["client"] = request.TLS.PeerCertificates[0].Subject.CommonName
+	}
+
+	return logger.With(fields)
+}
+
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > requestIDMaxLength {
+		return false
+	}
+
+	for _, char := range id {
+		if char <= ' ' || char > '~' {
+			return false
+		}
+	}
+
+	return true
+}
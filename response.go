@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// APIResponse is the envelope of responses of every endpoint which changes
+// something, error code is one of error categories, so callers can tell
+// failures apart without parsing messages.
+type APIResponse struct {
+	Success bool      `json:"success"`
+	Error   *APIError `json:"error,omitempty"`
+	Added   []string  `json:"added,omitempty"`
+	Skipped bool      `json:"skipped,omitempty"`
+	Queued  bool      `json:"queued,omitempty"`
+	DryRun  bool      `json:"dry_run,omitempty"`
+	Changed bool      `json:"changed"`
+
+	// Job is id of queued asynchronous assignment.
+	Job string `json:"job,omitempty"`
+
+	// Reviewers would be added if it wasn't dry run.
+	Reviewers []string `json:"reviewers,omitempty"`
+
+	// SkippedUsers are candidates who were not added and why.
+	SkippedUsers []SkippedUser `json:"skipped_users,omitempty"`
+
+	// Removed reviewers by undo or removal of group reviewers.
+	Removed []string `json:"removed,omitempty"`
+
+	// Retried dead notifications and Dropped cached groups.
+	Retried int `json:"retried,omitempty"`
+	Dropped int `json:"dropped,omitempty"`
+
+	// Repositories are results of default reviewers sync.
+	Repositories []DefaultReviewersResult `json:"repositories,omitempty"`
+}
+
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func writeResponse(
+	response http.ResponseWriter, status int, body APIResponse,
+) {
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("X-Content-Type-Options", "nosniff")
+	response.WriteHeader(status)
+
+	err := json.NewEncoder(response).Encode(body)
+	if err != nil {
+		logger.Errorf("can't write response: %s", err)
+	}
+}
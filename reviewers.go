@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// ReviewersRequest is body of POST /v2/reviewers, pull request is given
+// either by URL or by its coordinates.
+type ReviewersRequest struct {
+	Group       string `json:"group"`
+	URL         string `json:"url"`
+	Project     string `json:"project"`
+	Repository  string `json:"repository"`
+	PullRequest int64  `json:"pull_request"`
+}
+
+// handleReviewers serves POST and DELETE /v2/reviewers, it does the same as
+// legacy /%group%/%pull-request% but doesn't need pull request URL to be
+// encoded in request path. Query parameters are the same as for legacy
+// scheme.
+func (server *SnobServer) handleReviewers(
+	response http.ResponseWriter, request *http.Request,
+) {
+	if request.Method != "POST" && request.Method != "DELETE" {
+		server.reportError(
+			response,
+			NewError(ErrorBadRequest, "method %s is not allowed", request.Method),
+			http.StatusMethodNotAllowed,
+		)
+		return
+	}
+
+	request, ok := server.authorize(response, request, OperationReviewers)
+	if !ok {
+		return
+	}
+
+	var body ReviewersRequest
+
+	err := json.NewDecoder(request.Body).Decode(&body)
+	if err != nil {
+		server.reportError(
+			response,
+			NewError(ErrorBadRequest, "invalid request body: %s", err),
+			http.StatusBadRequest,
+		)
+		return
+	}
+
+	err = body.validate()
+	if err != nil {
+		server.reportError(response, err, getErrorStatus(err))
+		return
+	}
+
+	if request.Method == "DELETE" {
+		server.removeReviewers(response, request, body)
+		return
+	}
+
+	options, err := parseAssignOptions(request)
+	if err != nil {
+		server.reportError(response, err, getErrorStatus(err))
+		return
+	}
+
+	if request.URL.Query().Get("async") == "1" {
+		job := Job{Group: body.Group, URL: body.URL, Options: options}
+		if body.URL == "" {
+			job.Project = body.Project
+			job.Repository = body.Repository
+			job.PullRequest = strconv.FormatInt(body.PullRequest, 10)
+		}
+
+		server.submitJob(response, request, job)
+		return
+	}
+
+	var assignment *Assignment
+
+	if body.URL != "" {
+		assignment, err = server.AssignReviewers(
+			getRequestAPIKey(request), body.Group, body.URL, options,
+		)
+	} else {
+		assignment, err = server.assignPullRequest(
+			getRequestAPIKey(request), body.Group,
+			body.Project, body.Repository,
+			strconv.FormatInt(body.PullRequest, 10), options,
+		)
+	}
+	if err != nil {
+		if !options.DryRun {
+			entry := AuditEntry{Action: AuditAddReviewers, Group: body.Group}
+			if body.URL == "" {
+				entry.Project = body.Project
+				entry.Repository = body.Repository
+				entry.PullRequest = strconv.FormatInt(body.PullRequest, 10)
+			}
+
+			server.auditFailure(request, entry, body.URL, err)
+		}
+
+		server.reportError(response, err, getErrorStatus(err))
+		return
+	}
+
+	server.writeAssignment(response, request, assignment)
+}
+
+func (server *SnobServer) removeReviewers(
+	response http.ResponseWriter, request *http.Request,
+	body ReviewersRequest,
+) {
+	var (
+		assignment *Assignment
+		err        error
+		dryRun     = request.URL.Query().Get("dry_run") == "1"
+		entry      = AuditEntry{Group: body.Group}
+	)
+
+	if body.URL != "" {
+		assignment, err = server.RemoveGroupReviewers(
+			getRequestAPIKey(request), body.Group, body.URL, dryRun,
+		)
+	} else {
+		entry.Project = body.Project
+		entry.Repository = body.Repository
+		entry.PullRequest = strconv.FormatInt(body.PullRequest, 10)
+
+		assignment, err = server.removeGroupReviewers(
+			getRequestAPIKey(request), body.Group,
+			entry.Project, entry.Repository, entry.PullRequest, dryRun,
+		)
+	}
+
+	server.writeRemoval(response, request, entry, body.URL, assignment, err)
+}
+
+func (body *ReviewersRequest) validate() error {
+	if body.Group == "" {
+		return NewError(ErrorBadRequest, "group is required")
+	}
+
+	if body.URL != "" {
+		if body.Project != "" || body.Repository != "" || body.PullRequest != 0 {
+			return NewError(
+				ErrorBadRequest,
+				"either url or project, repository and pull_request "+
+					"should be given, not both",
+			)
+		}
+
+		return nil
+	}
+
+	if body.Project == "" || body.Repository == "" || body.PullRequest <= 0 {
+		return NewError(
+			ErrorBadURL,
+			"url or project, repository and pull_request are required",
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+)
+
+// RoundRobinStrategy rotates through candidates in alphabetical order,
+// starting after the last reviewer picked from the same group, so every
+// member gets pull requests in turn.
+type RoundRobinStrategy struct{}
+
+// RotationStore keeps the last reviewer picked from each group, it's saved
+// to the JSON file as a whole on every change if path is given, so
+// restarts don't reset fairness.
+type RotationStore struct {
+	path   string
+	mutex  sync.RWMutex
+	groups map[string]string
+}
+
+func OpenRotationStore(path string) (*RotationStore, error) {
+	store := &RotationStore{
+		path:   path,
+		groups: map[string]string{},
+	}
+
+	if path == "" {
+		return store, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return store, nil
+		}
+
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &store.groups)
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Rotate picks count users following the last picked user of the group
+// and remembers the last one of them, unless it's dry run.
+func (store *RotationStore) Rotate(
+	group string, users []string, count int, required []string,
+	dryRun bool,
+) ([]string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	sorted := append([]string{}, users...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return normalizeUser(sorted[i]) < normalizeUser(sorted[j])
+	})
+
+	last := store.groups[group]
+
+	start := 0
+	for index, user := range sorted {
+		if normalizeUser(user) > last {
+			start = index
+			break
+		}
+	}
+
+	rotated := append(sorted[start:], sorted[:start]...)
+
+	selected := selectRankedUsers(rotated, count, required)
+
+	if dryRun {
+		return selected, nil
+	}
+
+	store.groups[group] = normalizeUser(selected[len(selected)-1])
+
+	return selected, store.save()
+}
+
+func (store *RotationStore) Get() map[string]string {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	groups := map[string]string{}
+	for group, last := range store.groups {
+		groups[group] = last
+	}
+
+	return groups
+}
+
+func (store *RotationStore) save() error {
+	if store.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(store.groups, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	temporary := store.path + ".tmp"
+
+	err = ioutil.WriteFile(temporary, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temporary, store.path)
+}
+
+func (RoundRobinStrategy) Select(
+	selection *Selection, users []string, count int,
+) ([]string, error) {
+	if count <= 0 || count >= len(users) {
+		return users, nil
+	}
+
+	return selection.server.rotation.Rotate(
+		selection.Group, users, count, selection.Required, selection.DryRun,
+	)
+}
+
+// handleRotation serves GET /v1/rotation with the last reviewer picked from
+// each group by round-robin strategy.
+func (server *SnobServer) handleRotation(
+	response http.ResponseWriter, request *http.Request,
+) {
+	request, ok := server.authorize(response, request, OperationGroups)
+	if !ok {
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(response).Encode(server.rotation.Get())
+	if err != nil {
+		server.reportError(response, err, http.StatusInternalServerError)
+	}
+}
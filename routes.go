@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RouteConfig sends pull requests touching paths to reviewers of group,
+// like CODEOWNERS does.
+type RouteConfig struct {
+	Paths []string `toml:"paths"`
+	Group string   `toml:"group"`
+}
+
+type PathRoute struct {
+	Paths    []string
+	Group    string
+	patterns []*regexp.Regexp
+}
+
+func getPathRoutes(config []RouteConfig) ([]PathRoute, error) {
+	routes := []PathRoute{}
+
+	for index, item := range config {
+		route := PathRoute{
+			Paths: item.Paths,
+			Group: item.Group,
+		}
+
+		if len(route.Paths) == 0 || route.Group == "" {
+			return nil, fmt.Errorf(
+				"routes[%d]: paths and group are required", index,
+			)
+		}
+
+		for _, path := range route.Paths {
+			pattern, err := compilePathGlob(path)
+			if err != nil {
+				return nil, fmt.Errorf(
+					"routes[%d]: invalid path %q: %s", index, path, err,
+				)
+			}
+
+			route.patterns = append(route.patterns, pattern)
+		}
+
+		routes = append(routes, route)
+	}
+
+	return routes, nil
+}
+
+func (route PathRoute) Matches(path string) bool {
+	for _, pattern := range route.patterns {
+		if pattern.MatchString(path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// applyRoutes replaces group of query by groups of routes matching changed
+// files, the last matching route wins for every file. Requested group is
+// kept if some files are not matched by any route.
+func (server *SnobServer) applyRoutes(
+	selection *Selection, query *GroupQuery,
+) error {
+	if len(server.routes) == 0 {
+		return nil
+	}
+
+	paths, err := selection.GetChanges()
+	if err != nil {
+		return err
+	}
+
+	var (
+		groups   = []string{}
+		unrouted = false
+	)
+
+	for _, path := range paths {
+		group := ""
+		for _, route := range server.routes {
+			if route.Matches(path) {
+				group = route.Group
+			}
+		}
+
+		if group == "" {
+			unrouted = true
+			continue
+		}
+
+		groups = Union(groups, []string{group})
+	}
+
+	if len(groups) == 0 {
+		return nil
+	}
+
+	if unrouted {
+		groups = Union([]string{query.Group}, groups)
+	}
+
+	logger.WithPullRequest(
+		selection.Project, selection.Repository, selection.PullRequest,
+	).Infof("routed by changed paths to %s", strings.Join(groups, ", "))
+
+	query.Group = groups[0]
+	query.Union = Union(groups[1:], query.Union)
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RuleConfig overrides policy for repositories matching PROJECT/repo glob
+// pattern it's keyed by in [rules] section, zero values keep defaults.
+type RuleConfig struct {
+	Group        string   `toml:"group"`
+	Intersect    []string `toml:"intersect"`
+	MaxReviewers int      `toml:"max_reviewers"`
+	Strategy     string   `toml:"strategy"`
+	Comment      string   `toml:"comment"`
+}
+
+// getRule returns the most specific rule matching the repository: exact
+// name wins over glob and longer pattern wins over shorter one.
+func (server *SnobServer) getRule(
+	project string, repository string,
+) (string, RuleConfig) {
+	rules := server.config.Rules
+	if len(rules) == 0 {
+		return "", RuleConfig{}
+	}
+
+	patterns := []string{}
+	for pattern := range rules {
+		patterns = append(patterns, pattern)
+	}
+
+	sort.Slice(patterns, func(i, j int) bool {
+		iGlob := strings.ContainsAny(patterns[i], "*?[")
+		jGlob := strings.ContainsAny(patterns[j], "*?[")
+		if iGlob != jGlob {
+			return !iGlob
+		}
+
+		if len(patterns[i]) != len(patterns[j]) {
+			return len(patterns[i]) > len(patterns[j])
+		}
+
+		return patterns[i] < patterns[j]
+	})
+
+	pattern, ok := matchRepository(patterns, project+"/"+repository)
+	if !ok {
+		return "", RuleConfig{}
+	}
+
+	return pattern, rules[pattern]
+}
+
+// getRuleStrategy returns name of strategy used for the selection.
+func (server *SnobServer) getRuleStrategy(selection *Selection) string {
+	if selection.Rule.Strategy != "" {
+		return selection.Rule.Strategy
+	}
+
+	return server.config.Strategy
+}
+
+// applyRuleGroup replaces requested group by group of matching rule.
+func (server *SnobServer) applyRuleGroup(assignment *Assignment) {
+	pattern, rule := server.getRule(assignment.Project, assignment.Repository)
+	if rule.Group == "" {
+		return
+	}
+
+	logger.WithPullRequest(
+		assignment.Project, assignment.Repository, assignment.PullRequest,
+	).Infof("using group %s from rule %s", rule.Group, pattern)
+
+	assignment.Group = rule.Group
+}
+
+func validateRules(config *Config) ConfigErrors {
+	errs := ConfigErrors{}
+
+	patterns := []string{}
+	for pattern := range config.Rules {
+		patterns = append(patterns, pattern)
+	}
+
+	sort.Strings(patterns)
+
+	for _, pattern := range patterns {
+		rule := config.Rules[pattern]
+
+		err := validateRepositoryPatterns([]string{pattern})
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("rules: %s", err))
+		}
+
+		if rule.MaxReviewers < 0 {
+			errs = append(errs, fmt.Sprintf(
+				"rules.%q: max_reviewers should not be negative", pattern,
+			))
+		}
+
+		if rule.Strategy == "" {
+			continue
+		}
+
+		_, err = getStrategy(config, rule.Strategy)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("rules.%q: %s", pattern, err))
+		}
+
+		if config.Backend != BackendStash && isStashStrategy(rule.Strategy) {
+			errs = append(errs, fmt.Sprintf(
+				"rules.%q: %s strategy is supported only by stash backend",
+				pattern, rule.Strategy,
+			))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	SignalActivity    = "activity"
+	SignalLoad        = "load"
+	SignalAssignments = "assignments"
+	SignalOwnership   = "ownership"
+	SignalOverlap     = "overlap"
+
+	// scoringMaxFiles limits number of changed files inspected for
+	// ownership signal, one Stash request is made per file.
+	scoringMaxFiles = 20
+)
+
+// ScoreStrategy ranks candidates by weighted sum of signals, each signal is
+// normalized by its maximum among candidates, so weights are comparable.
+type ScoreStrategy struct {
+	Weights map[string]float64
+	Window  time.Duration
+}
+
+type ResponseCommits struct {
+	Values []struct {
+		Author struct {
+			Name string `json:"name"`
+		} `json:"author"`
+		AuthorTimestamp int64 `json:"authorTimestamp"`
+	} `json:"values"`
+}
+
+type ResponsePullRequests struct {
+	Values        []ResponsePullRequest `json:"values"`
+	IsLastPage    bool                  `json:"isLastPage"`
+	NextPageStart int                   `json:"nextPageStart"`
+}
+
+type ScoringConfig struct {
+	Activity    float64  `toml:"activity"`
+	Load        float64  `toml:"load"`
+	Assignments float64  `toml:"assignments"`
+	Ownership   float64  `toml:"ownership"`
+	Overlap     float64  `toml:"overlap"`
+	Window      Duration `toml:"window"`
+}
+
+func getScoreStrategy(config ScoringConfig) (*ScoreStrategy, error) {
+	if config.Window.Duration <= 0 {
+		return nil, fmt.Errorf("scoring.window should be positive")
+	}
+
+	return &ScoreStrategy{
+		Weights: map[string]float64{
+			SignalActivity:    config.Activity,
+			SignalLoad:        config.Load,
+			SignalAssignments: config.Assignments,
+			SignalOwnership:   config.Ownership,
+			SignalOverlap:     config.Overlap,
+		},
+		Window: config.Window.Duration,
+	}, nil
+}
+
+func (strategy *ScoreStrategy) Select(
+	selection *Selection, users []string, count int,
+) ([]string, error) {
+	signals, err := strategy.getSignals(selection, users)
+	if err != nil {
+		return nil, err
+	}
+
+	maximums := map[string]float64{}
+	for _, userSignals := range signals {
+		for signal, value := range userSignals {
+			if value > maximums[signal] {
+				maximums[signal] = value
+			}
+		}
+	}
+
+	scores := map[string]float64{}
+	for _, user := range users {
+		score := 0.0
+		for signal, weight := range strategy.Weights {
+			if maximums[signal] > 0 {
+				score += weight * signals[user][signal] / maximums[signal]
+			}
+		}
+
+		scores[user] = score
+	}
+
+	ranked := append([]string{}, users...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return scores[ranked[i]] > scores[ranked[j]]
+	})
+
+	for _, user := range ranked {
+		selection.Scores[user] = scores[user]
+		selection.Signals[user] = signals[user]
+
+		logger.Debugf("[score] %s: %.3f %v", user, scores[user], signals[user])
+	}
+
+	return selectRankedUsers(ranked, count, selection.Required), nil
+}
+
+func (strategy *ScoreStrategy) getSignals(
+	selection *Selection, users []string,
+) (map[string]map[string]float64, error) {
+	var (
+		server  = selection.server
+		since   = time.Now().Add(-strategy.Window)
+		signals = map[string]map[string]float64{}
+	)
+
+	for _, user := range users {
+		signals[user] = map[string]float64{}
+	}
+
+	if strategy.Weights[SignalActivity] != 0 {
+		authors, err := server.GetCommitAuthors(
+			selection.Project, selection.Repository, "", since,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, user := range users {
+			signals[user][SignalActivity] = float64(authors[normalizeUser(user)])
+		}
+	}
+
+	if strategy.Weights[SignalOwnership] != 0 {
+		changes, err := selection.GetChanges()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(changes) > scoringMaxFiles {
+			changes = changes[:scoringMaxFiles]
+		}
+
+		for _, path := range changes {
+			authors, err := server.GetCommitAuthors(
+				selection.Project, selection.Repository, path, since,
+			)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, user := range users {
+				signals[user][SignalOwnership] += float64(
+					authors[normalizeUser(user)],
+				)
+			}
+		}
+	}
+
+	if strategy.Weights[SignalAssignments] != 0 {
+		assignments := server.history.CountAssignments(since)
+		for _, user := range users {
+			signals[user][SignalAssignments] = float64(
+				assignments[normalizeUser(user)],
+			)
+		}
+	}
+
+	// reviewers with unknown time zone get no overlap, as well as everybody
+	// if time zone of author is unknown
+	if strategy.Weights[SignalOverlap] != 0 {
+		var (
+			config = server.config.Timezones
+			author = server.getUserLocation(selection.Info.Author.User.Name)
+			now    = time.Now()
+		)
+
+		for _, user := range users {
+			if author == nil {
+				break
+			}
+
+			reviewer := server.getUserLocation(user)
+			if reviewer == nil {
+				continue
+			}
+
+			signals[user][SignalOverlap] = getWorkingOverlap(
+				author, reviewer, config.WorkStart, config.WorkEnd, now,
+			)
+		}
+	}
+
+	if strategy.Weights[SignalLoad] != 0 {
+		for _, user := range users {
+			load, err := server.GetOpenReviewsCount(
+				selection.Project, selection.Repository, user,
+			)
+			if err != nil {
+				return nil, err
+			}
+
+			signals[user][SignalLoad] = float64(load)
+		}
+	}
+
+	return signals, nil
+}
+
+// GetCommitAuthors returns number of commits per normalized author name
+// since given time, optionally only commits touching given path.
+func (server *SnobServer) GetCommitAuthors(
+	project string, repository string, path string, since time.Time,
+) (map[string]int, error) {
+	query := map[string]string{"limit": "500"}
+	if path != "" {
+		query["path"] = path
+	}
+
+	request, err := server.repositoryResource(project, repository).
+		Res("commits", &ResponseCommits{}).
+		Get(query)
+
+	err = checkStashResponse(request, err)
+	if err != nil {
+		return nil, err
+	}
+
+	authors := map[string]int{}
+	for _, commit := range request.Response.(*ResponseCommits).Values {
+		if time.Unix(0, commit.AuthorTimestamp*int64(time.Millisecond)).
+			Before(since) {
+			continue
+		}
+
+		authors[normalizeUser(commit.Author.Name)]++
+	}
+
+	return authors, nil
+}
+
+// GetOpenReviewsCount returns number of open pull requests in the
+// repository where user is a reviewer.
+func (server *SnobServer) GetOpenReviewsCount(
+	project string, repository string, user string,
+) (int, error) {
+	count := 0
+	start := 0
+
+	for {
+		request, err := server.repositoryResource(project, repository).
+			Res("pull-requests", &ResponsePullRequests{}).
+			Get(map[string]string{
+				"state":      "OPEN",
+				"role.1":     "REVIEWER",
+				"username.1": user,
+				"start":      strconv.Itoa(start),
+				"limit":      "100",
+			})
+
+		err = checkStashResponse(request, err)
+		if err != nil {
+			return 0, err
+		}
+
+		pullRequests := request.Response.(*ResponsePullRequests)
+		count += len(pullRequests.Values)
+
+		if pullRequests.IsLastPage || len(pullRequests.Values) == 0 {
+			return count, nil
+		}
+
+		start = pullRequests.NextPageStart
+	}
+}
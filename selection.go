@@ -0,0 +1,547 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+const (
+	StrategyRandom     = "random"
+	StrategyScore      = "score"
+	StrategyExternal   = "external"
+	StrategyRoundRobin = "round-robin"
+	StrategyWorkload   = "workload"
+	StrategyBlame      = "blame"
+	StrategyTiered     = "tiered"
+	StrategyFair       = "fair"
+)
+
+const (
+	EmptyError        = "error"
+	EmptySkip         = "skip"
+	EmptyRawGroup     = "raw_group"
+	EmptyDefaultGroup = "default_group"
+)
+
+// Reasons candidates are skipped for, reported to caller of assignment.
+const (
+	SkipAuthor          = "author"
+	SkipServiceAccount  = "service_account"
+	SkipAlreadyReviewer = "already_reviewer"
+	SkipExcluded        = "excluded"
+	SkipBowedOut        = "bowed_out"
+	SkipUnavailable     = "unavailable"
+	SkipCapacity        = "capacity"
+)
+
+// SkippedUser is candidate who was not added to the pull request.
+type SkippedUser struct {
+	User   string `json:"user"`
+	Reason string `json:"reason"`
+}
+
+type Strategy interface {
+	Select(selection *Selection, users []string, count int) ([]string, error)
+}
+
+// Selection holds everything known about the pull request reviewers are
+// selected for, expensive data is fetched lazily and shared between
+// selection steps.
+type Selection struct {
+	server *SnobServer
+
+	Project     string
+	Repository  string
+	PullRequest string
+	Info        *ResponsePullRequest
+
+	// Group is the group reviewers are selected from.
+	Group string
+
+	// Rule is policy override matching the repository.
+	Rule RuleConfig
+
+	// Union and ExcludeGroups override union and exclude_groups from
+	// config if set.
+	Union         []string
+	ExcludeGroups []string
+
+	// Required users, at least one of them should be selected.
+	Required []string
+
+	// Scores are filled by scoring strategy, signals are kept per user.
+	Scores  map[string]float64
+	Signals map[string]map[string]float64
+
+	// SkippedUsers are candidates removed before strategy, with reasons,
+	// each user is listed once with the first reason.
+	SkippedUsers []SkippedUser
+
+	Trace *Trace
+
+	// DryRun selection doesn't change state kept by strategies.
+	DryRun bool
+
+	skipped  UserSet
+	excluded []string
+	changes  []string
+	bowedOut []string
+	readers  map[string]bool
+}
+
+type RandomStrategy struct{}
+
+func (server *SnobServer) NewSelection(
+	project string, repository string, pullRequest string,
+	info *ResponsePullRequest,
+) *Selection {
+	excluded := append(
+		[]string{info.Author.User.Name, server.config.User},
+		server.config.ExcludeUsers...,
+	)
+
+	_, rule := server.getRule(project, repository)
+
+	return &Selection{
+		Rule:        rule,
+		excluded:    excluded,
+		server:      server,
+		Project:     project,
+		Repository:  repository,
+		PullRequest: pullRequest,
+		Info:        info,
+		Scores:      map[string]float64{},
+		Signals:     map[string]map[string]float64{},
+		Trace:       NewTrace(),
+		skipped:     NewUserSet(),
+	}
+}
+
+// Exclude makes sure that given users are not selected.
+func (selection *Selection) Exclude(users []string) {
+	selection.excluded = mergeUsers(selection.excluded, users)
+}
+
+// skip removes given users from candidates and records reason for them.
+func (selection *Selection) skip(
+	users []string, skipped []string, reason string,
+) []string {
+	for _, user := range NewUserSet(users...).Intersect(skipped) {
+		if selection.skipped.Contains(user) {
+			continue
+		}
+
+		selection.skipped.Add(user)
+		selection.SkippedUsers = append(
+			selection.SkippedUsers, SkippedUser{User: user, Reason: reason},
+		)
+	}
+
+	return excludeUsers(users, skipped)
+}
+
+// skipRemoved records users which filter removed from candidates as skipped
+// and returns users which are kept.
+func (selection *Selection) skipRemoved(
+	users []string, kept []string, reason string,
+) []string {
+	return selection.skip(users, NewUserSet(kept...).Difference(users), reason)
+}
+
+// getReviewers returns users who are reviewers of the pull request already.
+func (selection *Selection) getReviewers() []string {
+	reviewers := []string{}
+	for _, reviewer := range selection.Info.Reviewers {
+		reviewers = append(reviewers, reviewer.User.Name)
+	}
+
+	return reviewers
+}
+
+func (selection *Selection) GetChanges() ([]string, error) {
+	if selection.changes != nil {
+		return selection.changes, nil
+	}
+
+	changes, err := selection.server.GetPullRequestChanges(
+		selection.Project, selection.Repository, selection.PullRequest,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	selection.changes = changes
+
+	return changes, nil
+}
+
+// SelectReviewers resolves candidates of the group, filters out everybody
+// who can't review the pull request and picks count of them using
+// configured strategy; experts of changed files are added on top.
+func (server *SnobServer) SelectReviewers(
+	selection *Selection, group string, count int,
+) ([]string, error) {
+	selection.Group = group
+
+	query := server.getGroupQuery(selection, group)
+
+	err := server.applyRoutes(selection, &query)
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := server.GetCandidateUsers(query, selection.Trace)
+	if err != nil {
+		return nil, err
+	}
+
+	selection.Trace.Step("intersection", query.String(), nil, users)
+
+	info := selection.Info
+
+	if server.jira != nil {
+		leads := server.jira.GetComponentLeads(
+			getJiraIssues(info.Title, info.Description, info.FromRef.DisplayID),
+		)
+
+		before := users
+		users = mergeUsers(users, leads)
+
+		selection.Trace.Step(
+			"jira", "component leads of mentioned issues", before, users,
+		)
+	}
+
+	users = server.filterCandidates(selection, users)
+	if len(users) == 0 {
+		users, err = server.getFallbackCandidates(selection, group)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var required []string
+
+	candidates := users
+
+	before := users
+	users, required = server.applyOrgRules(info.Author.User.Name, users)
+	selection.Required = required
+
+	if server.org != nil {
+		selection.Trace.Step(
+			"org",
+			fmt.Sprintf(
+				"manager of author excluded, required outside of team: %v",
+				required,
+			),
+			before, users,
+		)
+	}
+
+	if len(users) > 0 {
+		name := server.getRuleStrategy(selection)
+
+		strategy, err := getStrategy(server.config, name)
+		if err != nil {
+			return nil, err
+		}
+
+		before := users
+
+		users, err = strategy.Select(selection, users, count)
+		if err != nil {
+			return nil, err
+		}
+
+		selection.Trace.Step(
+			"strategy",
+			fmt.Sprintf(
+				"%d picked by %s strategy", count, name,
+			),
+			before, users,
+		)
+	}
+
+	experts, err := server.GetExperts(selection)
+	if err != nil {
+		return nil, err
+	}
+
+	before = users
+	users = mergeUsers(users, server.filterCandidates(selection, experts))
+
+	selection.Trace.Step("experts", "experts of changed paths", before, users)
+
+	before = users
+	users = server.applyNeverTogether(selection, users, candidates)
+
+	selection.Trace.Step(
+		"never_together", "reviewers who can't review together", before, users,
+	)
+
+	before = users
+
+	users, err = server.applyReviewersLimit(users)
+	if err != nil {
+		return nil, err
+	}
+
+	selection.Trace.Step("limit", "reviewers_limit", before, users)
+
+	return users, nil
+}
+
+func (server *SnobServer) getGroupQuery(
+	selection *Selection, group string,
+) GroupQuery {
+	query := GroupQuery{
+		Group:     group,
+		Union:     server.config.Union,
+		Intersect: server.config.Intersect,
+		Exclude:   server.config.ExcludeGroups,
+	}
+
+	if len(selection.Rule.Intersect) > 0 {
+		query.Intersect = selection.Rule.Intersect
+	}
+
+	if selection.Union != nil {
+		query.Union = selection.Union
+	}
+
+	if selection.ExcludeGroups != nil {
+		query.Exclude = selection.ExcludeGroups
+	}
+
+	return query
+}
+
+// filterCandidates removes author, service account, current reviewers,
+// users who stepped back from the pull request and users who are not
+// available now, so repeated assignment brings fresh reviewers.
+func (server *SnobServer) filterCandidates(
+	selection *Selection, users []string,
+) []string {
+	var (
+		before  = users
+		author  = []string{selection.Info.Author.User.Name}
+		service = []string{server.config.User}
+	)
+
+	users = selection.skip(users, author, SkipAuthor)
+	users = selection.skip(users, service, SkipServiceAccount)
+	users = selection.skip(users, selection.excluded, SkipExcluded)
+	selection.Trace.Step(
+		"exclude",
+		"author, service account, exclude_users and excluded users",
+		before, users,
+	)
+
+	before = users
+	users = selection.skip(users, selection.getReviewers(), SkipAlreadyReviewer)
+	selection.Trace.Step(
+		"existing", "already reviewers of the pull request", before, users,
+	)
+
+	if selection.bowedOut == nil {
+		selection.bowedOut = server.getBowedOutUsers(selection)
+	}
+
+	before = users
+	users = selection.skip(users, selection.bowedOut, SkipBowedOut)
+	selection.Trace.Step(
+		"bowed_out",
+		"needs work, participant in other role or removed themselves",
+		before, users,
+	)
+
+	before = users
+	users = selection.skipRemoved(
+		users, server.availability.Filter(users), SkipUnavailable,
+	)
+	selection.Trace.Step("availability", "paused assignments", before, users)
+
+	before = users
+	users = selection.skipRemoved(
+		users,
+		server.availability.FilterCapacity(
+			users,
+			server.history.CountAssignments(time.Now().AddDate(0, 0, -7)),
+		),
+		SkipCapacity,
+	)
+	selection.Trace.Step("capacity", "weekly capacity reached", before, users)
+
+	before = users
+	users = server.filterPermitted(selection, users)
+	selection.Trace.Step(
+		"permission", "no read permission on repository", before, users,
+	)
+
+	return users
+}
+
+func (server *SnobServer) getFallbackCandidates(
+	selection *Selection, group string,
+) ([]string, error) {
+	action := server.config.OnEmpty
+
+	logger.Infof("no candidates in %s, on_empty action: %s", group, action)
+
+	selection.Trace.Step(
+		"on_empty", fmt.Sprintf("no candidates left, action: %s", action),
+		nil, nil,
+	)
+
+	switch action {
+	case EmptySkip:
+		return []string{}, nil
+
+	case EmptyRawGroup:
+
+	case EmptyDefaultGroup:
+		group = server.config.DefaultGroup
+
+	default:
+		return nil, NewError(
+			ErrorNoCandidates, "no candidates found in group %s", group,
+		)
+	}
+
+	users, err := server.getCachedUsers(group)
+	if err != nil {
+		return nil, err
+	}
+
+	users = server.filterCandidates(selection, users)
+	if len(users) == 0 {
+		return nil, NewError(
+			ErrorNoCandidates, "no candidates found in group %s", group,
+		)
+	}
+
+	return users, nil
+}
+
+func getStrategy(config *Config, name string) (Strategy, error) {
+	switch name {
+	case StrategyRandom:
+		return RandomStrategy{}, nil
+
+	case StrategyScore:
+		return getScoreStrategy(config.Scoring)
+
+	case StrategyExternal:
+		return getExternalStrategy(config)
+
+	case StrategyRoundRobin:
+		return RoundRobinStrategy{}, nil
+
+	case StrategyTiered:
+		return TieredStrategy{}, nil
+
+	case StrategyFair:
+		if config.Fairness.Recent <= 0 {
+			return nil, fmt.Errorf("fairness.recent should be positive")
+		}
+
+		return FairStrategy{Recent: config.Fairness.Recent}, nil
+
+	case StrategyWorkload:
+		return WorkloadStrategy{Window: config.Workload.Window.Duration}, nil
+
+	case StrategyBlame:
+		if config.Blame.Window.Duration <= 0 || config.Blame.MaxFiles <= 0 {
+			return nil, fmt.Errorf(
+				"blame.window and blame.max_files should be positive",
+			)
+		}
+
+		return BlameStrategy{
+			Window:   config.Blame.Window.Duration,
+			MaxFiles: config.Blame.MaxFiles,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown strategy %q", name)
+}
+
+func (RandomStrategy) Select(
+	selection *Selection, users []string, count int,
+) ([]string, error) {
+	return selectRandomUsers(users, count, selection.Required), nil
+}
+
+// applyReviewersLimit enforces hard limit of reviewers added to single
+// pull request, so a mistyped umbrella group doesn't add the whole company.
+func (server *SnobServer) applyReviewersLimit(users []string) ([]string, error) {
+	limit := server.config.ReviewersLimit
+	if limit <= 0 || len(users) <= limit {
+		return users, nil
+	}
+
+	if server.config.ReviewersLimitAction == "truncate" {
+		logger.Warnf(
+			"%d reviewers exceed reviewers_limit, using first %d",
+			len(users), limit,
+		)
+
+		return users[:limit], nil
+	}
+
+	return nil, NewError(
+		ErrorTooManyReviewers,
+		"%d reviewers exceed reviewers_limit of %d", len(users), limit,
+	)
+}
+
+// selectRandomUsers picks count random users, if required users are given,
+// at least one of them is always picked.
+func selectRandomUsers(users []string, count int, required []string) []string {
+	if count <= 0 || count >= len(users) {
+		return users
+	}
+
+	selected := []string{}
+	if len(required) > 0 {
+		selected = append(selected, required[rand.Intn(len(required))])
+	}
+
+	for _, index := range rand.Perm(len(users)) {
+		if len(selected) >= count {
+			break
+		}
+
+		selected = mergeUsers(selected, []string{users[index]})
+	}
+
+	return selected
+}
+
+// selectRankedUsers picks first count users in order of ranking, if
+// required users are given, the best ranked of them is always picked.
+func selectRankedUsers(ranked []string, count int, required []string) []string {
+	if count <= 0 || count >= len(ranked) {
+		return ranked
+	}
+
+	selected := []string{}
+	for _, user := range ranked {
+		if containsUser(required, user) {
+			selected = append(selected, user)
+			break
+		}
+	}
+
+	for _, user := range ranked {
+		if len(selected) >= count {
+			break
+		}
+
+		selected = mergeUsers(selected, []string{user})
+	}
+
+	return selected
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math/rand"
+)
+
+const SignalSenior = "senior"
+
+// TieredStrategy picks at least one senior and one non-senior reviewer, so
+// every pull request is reviewed by a mentor and a mentee. Seniors are
+// listed per group in [seniors] section, remaining reviewers are random.
+type TieredStrategy struct{}
+
+func (TieredStrategy) Select(
+	selection *Selection, users []string, count int,
+) ([]string, error) {
+	if count <= 0 || count >= len(users) {
+		return users, nil
+	}
+
+	if count < 2 {
+		count = 2
+	}
+
+	seniors, juniors := selection.server.splitSeniors(selection.Group, users)
+	if len(seniors) == 0 || len(juniors) == 0 {
+		logger.Warnf(
+			"group %s has %d senior and %d non-senior candidates, "+
+				"picking randomly",
+			selection.Group, len(seniors), len(juniors),
+		)
+	}
+
+	selected := []string{}
+	if len(selection.Required) > 0 {
+		selected = append(
+			selected,
+			selection.Required[rand.Intn(len(selection.Required))],
+		)
+	}
+
+	for _, tier := range [][]string{seniors, juniors} {
+		if len(tier) == 0 || len(getIntersection(selected, tier)) > 0 {
+			continue
+		}
+
+		selected = mergeUsers(selected, []string{tier[rand.Intn(len(tier))]})
+	}
+
+	for _, index := range rand.Perm(len(users)) {
+		if len(selected) >= count {
+			break
+		}
+
+		selected = mergeUsers(selected, []string{users[index]})
+	}
+
+	for _, user := range users {
+		tier := 0.0
+		if containsUser(seniors, user) {
+			tier = 1
+		}
+
+		selection.Signals[user] = map[string]float64{SignalSenior: tier}
+	}
+
+	return selected, nil
+}
+
+// splitSeniors splits candidates into seniors of the group and everybody
+// else.
+func (server *SnobServer) splitSeniors(
+	group string, users []string,
+) ([]string, []string) {
+	seniors := NewUserSet(server.config.Seniors[group]...).Intersect(users)
+
+	return seniors, excludeUsers(users, seniors)
+}
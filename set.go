@@ -0,0 +1,67 @@
+package main
+
+// UserSet is a set of usernames, names are compared case-insensitively
+// like in containsUser. Operations take and return slices, so order of
+// users, which matters for ranked selection, is kept.
+type UserSet map[string]struct{}
+
+func NewUserSet(users ...string) UserSet {
+	set := make(UserSet, len(users))
+	set.Add(users...)
+
+	return set
+}
+
+func (set UserSet) Add(users ...string) {
+	for _, user := range users {
+		set[normalizeUser(user)] = struct{}{}
+	}
+}
+
+func (set UserSet) Contains(user string) bool {
+	_, ok := set[normalizeUser(user)]
+	return ok
+}
+
+// Intersect returns users which are in the set, without duplicates.
+func (set UserSet) Intersect(users []string) []string {
+	result := []string{}
+	seen := NewUserSet()
+
+	for _, user := range users {
+		if set.Contains(user) && !seen.Contains(user) {
+			seen.Add(user)
+			result = append(result, user)
+		}
+	}
+
+	return result
+}
+
+// Difference returns users which are not in the set.
+func (set UserSet) Difference(users []string) []string {
+	result := []string{}
+
+	for _, user := range users {
+		if !set.Contains(user) {
+			result = append(result, user)
+		}
+	}
+
+	return result
+}
+
+// Union returns users followed by other users which are not among them.
+func Union(users []string, other []string) []string {
+	result := append([]string{}, users...)
+	seen := NewUserSet(users...)
+
+	for _, user := range other {
+		if !seen.Contains(user) {
+			seen.Add(user)
+			result = append(result, user)
+		}
+	}
+
+	return result
+}
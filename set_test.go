@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestUserSetIntersect(t *testing.T) {
+	tests := []struct {
+		name  string
+		set   []string
+		users []string
+		want  []string
+	}{
+		{"empty set", nil, []string{"alice"}, []string{}},
+		{"empty users", []string{"alice"}, nil, []string{}},
+		{
+			"keeps order of users",
+			[]string{"alice", "bob", "carol"},
+			[]string{"carol", "dave", "alice"},
+			[]string{"carol", "alice"},
+		},
+		{
+			"ignores case",
+			[]string{"Alice"},
+			[]string{"alice", "BOB"},
+			[]string{"alice"},
+		},
+		{
+			"drops duplicates",
+			[]string{"alice"},
+			[]string{"alice", "ALICE", "alice"},
+			[]string{"alice"},
+		},
+	}
+
+	for _, test := range tests {
+		got := NewUserSet(test.set...).Intersect(test.users)
+		if !equalUsers(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestUserSetDifference(t *testing.T) {
+	tests := []struct {
+		name  string
+		set   []string
+		users []string
+		want  []string
+	}{
+		{"empty set", nil, []string{"alice"}, []string{"alice"}},
+		{"empty users", []string{"alice"}, nil, []string{}},
+		{
+			"keeps order of users",
+			[]string{"bob"},
+			[]string{"carol", "bob", "alice"},
+			[]string{"carol", "alice"},
+		},
+		{
+			"ignores case",
+			[]string{"BOB"},
+			[]string{"alice", "bob"},
+			[]string{"alice"},
+		},
+	}
+
+	for _, test := range tests {
+		got := NewUserSet(test.set...).Difference(test.users)
+		if !equalUsers(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestUnion(t *testing.T) {
+	tests := []struct {
+		name  string
+		users []string
+		other []string
+		want  []string
+	}{
+		{"both empty", nil, nil, []string{}},
+		{"empty other", []string{"alice"}, nil, []string{"alice"}},
+		{
+			"appends other after users",
+			[]string{"bob", "alice"},
+			[]string{"carol", "alice"},
+			[]string{"bob", "alice", "carol"},
+		},
+		{
+			"ignores case",
+			[]string{"alice"},
+			[]string{"ALICE", "bob"},
+			[]string{"alice", "bob"},
+		},
+	}
+
+	for _, test := range tests {
+		got := Union(test.users, test.other)
+		if !equalUsers(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// equalUsers treats nil and empty lists as equal, set operations return
+// either of them.
+func equalUsers(got, want []string) bool {
+	if len(got) == 0 && len(want) == 0 {
+		return true
+	}
+
+	return reflect.DeepEqual(got, want)
+}
+
+// getBenchmarkGroups returns two groups of 5000 users which share half of
+// their members, like large LDAP groups do.
+func getBenchmarkGroups() ([]string, []string) {
+	const size = 5000
+
+	first := make([]string, 0, size)
+	second := make([]string, 0, size)
+	for i := 0; i < size; i++ {
+		first = append(first, fmt.Sprintf("user%d", i))
+		second = append(second, fmt.Sprintf("user%d", i+size/2))
+	}
+
+	return first, second
+}
+
+func BenchmarkIntersect(b *testing.B) {
+	first, second := getBenchmarkGroups()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NewUserSet(first...).Intersect(second)
+	}
+}
+
+func BenchmarkDifference(b *testing.B) {
+	first, second := getBenchmarkGroups()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NewUserSet(first...).Difference(second)
+	}
+}
+
+func BenchmarkUnion(b *testing.B) {
+	first, second := getBenchmarkGroups()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Union(first, second)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// gracefulShutdown stops HTTP server once, either for upgrade or on
+// termination signal, letting in-flight requests finish so pull requests
+// are not left half-updated.
+type gracefulShutdown struct {
+	server  *http.Server
+	once    sync.Once
+	stopped chan struct{}
+}
+
+func newGracefulShutdown(server *http.Server) *gracefulShutdown {
+	return &gracefulShutdown{
+		server:  server,
+		stopped: make(chan struct{}),
+	}
+}
+
+// Drain stops accepting connections and waits for in-flight requests up
+// to timeout, Stopped is closed after that.
+func (shutdown *gracefulShutdown) Drain(timeout time.Duration) {
+	shutdown.once.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		err := shutdown.server.Shutdown(ctx)
+		if err != nil {
+			logger.Errorf("can't drain connections: %s", err)
+		}
+
+		close(shutdown.stopped)
+	})
+}
+
+func (shutdown *gracefulShutdown) Stopped() <-chan struct{} {
+	return shutdown.stopped
+}
+
+// handleTermination drains server on SIGTERM or SIGINT.
+func (server *SnobServer) handleTermination(shutdown *gracefulShutdown) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	received := <-signals
+
+	signal.Stop(signals)
+
+	timeout := server.config.ShutdownTimeout.Duration
+
+	logger.Infof(
+		"%s received, draining connections for up to %s", received, timeout,
+	)
+
+	shutdown.Drain(timeout)
+}
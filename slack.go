@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+func postSlackMessage(url string, channel string, text string) error {
+	// @name in text mentions the user
+	payload := map[string]string{
+		"text":       text,
+		"link_names": "1",
+	}
+
+	if channel != "" {
+		payload["channel"] = channel
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	response, err := client.Post(
+		url, "application/json", bytes.NewReader(body),
+	)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode >= 400 {
+		return fmt.Errorf("slack responded with %s", response.Status)
+	}
+
+	return nil
+}
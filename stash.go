@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// participantsAPIVersion is the first major version of Bitbucket Server,
+// which prefers participants endpoint over updating whole pull request.
+const participantsAPIVersion = 7
+
+// stashVersion is major version of Stash, detected once on first use.
+type stashVersion struct {
+	mutex    sync.Mutex
+	major    int
+	detected bool
+}
+
+// getStashURL returns base URL of Stash, stash can be set either to host,
+// which is accessed over plain HTTP, or to full URL with scheme.
+func getStashURL(stash string) (string, error) {
+	if !strings.Contains(stash, "://") {
+		return "http://" + stash, nil
+	}
+
+	parsed, err := url.Parse(stash)
+	if err != nil {
+		return "", fmt.Errorf("stash: %s", err)
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return "", fmt.Errorf(
+			"stash: scheme should be http or https, got %q", parsed.Scheme,
+		)
+	}
+
+	return strings.TrimRight(stash, "/"), nil
+}
+
+// getStashTransport returns transport which trusts stash_ca_file in
+// addition to system roots and skips verification if
+// stash_insecure_skip_verify is set.
+func getStashTransport(config *Config) (http.RoundTripper, error) {
+	if config.StashCAFile == "" && !config.StashInsecure {
+		return http.DefaultTransport, nil
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config.StashInsecure,
+	}
+
+	if config.StashCAFile != "" {
+		pem, err := ioutil.ReadFile(config.StashCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("stash_ca_file: %s", err)
+		}
+
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf(
+				"stash_ca_file: no certificates found in %s",
+				config.StashCAFile,
+			)
+		}
+
+		tlsConfig.RootCAs = roots
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return transport, nil
+}
+
+// GetStashVersion returns major version of Stash reported by
+// application-properties, it's queried only once.
+func (server *SnobServer) GetStashVersion() (int, error) {
+	server.version.mutex.Lock()
+	defer server.version.mutex.Unlock()
+
+	if server.version.detected {
+		return server.version.major, nil
+	}
+
+	var properties struct {
+		Version string `json:"version"`
+	}
+
+	request, err := server.api.Res("application-properties", &properties).Get()
+
+	err = checkStashResponse(request, err)
+	if err != nil {
+		return 0, err
+	}
+
+	major, err := strconv.Atoi(strings.SplitN(properties.Version, ".", 2)[0])
+	if err != nil {
+		return 0, fmt.Errorf("unexpected stash version %q", properties.Version)
+	}
+
+	logger.Infof("detected stash version %s", properties.Version)
+
+	server.version.major = major
+	server.version.detected = true
+
+	return major, nil
+}
+
+// usesParticipantsAPI reports whether reviewers should be changed through
+// participants endpoint, legacy update of whole pull request is used if
+// version can't be detected.
+func (server *SnobServer) usesParticipantsAPI() bool {
+	major, err := server.GetStashVersion()
+	if err != nil {
+		logger.Errorf("can't detect stash version, using legacy api: %s", err)
+		return false
+	}
+
+	return major >= participantsAPIVersion
+}
+
+// headerTransport authenticates requests by token in header, which
+// gopencils can't do by itself. Requests which already carry the header,
+// like ones made on behalf of user, are passed as is.
+type headerTransport struct {
+	header string
+	value  string
+	next   http.RoundTripper
+}
+
+func (transport *headerTransport) RoundTrip(
+	request *http.Request,
+) (*http.Response, error) {
+	if request.Header.Get(transport.header) == "" {
+		request.Header.Set(transport.header, transport.value)
+	}
+
+	return transport.next.RoundTrip(request)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+)
+
+type StaticGroup struct {
+	Users []string `toml:"users"`
+
+	// MergeStash makes group a union of listed users and members of Stash
+	// group with the same name, otherwise Stash is not queried at all.
+	MergeStash bool `toml:"merge_stash"`
+}
+
+// loadStaticGroups reads groups file, which looks like:
+//
+//	[groups.backend-oncall]
+//	users = ["alice", "bob"]
+//
+//	[groups.developers]
+//	users = ["contractor"]
+//	merge_stash = true
+func loadStaticGroups(path string) (map[string]StaticGroup, error) {
+	var file struct {
+		Groups map[string]StaticGroup `toml:"groups"`
+	}
+
+	_, err := toml.DecodeFile(path, &file)
+	if err != nil {
+		return nil, fmt.Errorf("can't load groups file %s: %s", path, err)
+	}
+
+	if file.Groups == nil {
+		file.Groups = map[string]StaticGroup{}
+	}
+
+	return file.Groups, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StatsConfig configures GET /stats, which counts assignments recorded to
+// history_file, it's not served if the file is not set.
+type StatsConfig struct {
+	// Windows are periods reviewers are counted over by default.
+	Windows []Duration `toml:"windows"`
+}
+
+type StatsWindow struct {
+	Window    string         `json:"window"`
+	Since     time.Time      `json:"since"`
+	Total     int            `json:"total"`
+	Reviewers map[string]int `json:"reviewers"`
+}
+
+type StatsResponse struct {
+	Success bool          `json:"success"`
+	Windows []StatsWindow `json:"windows"`
+}
+
+// handleStats serves GET /stats with number of assignments per reviewer
+// over configured windows or over ?window=168h given any number of times,
+// records can be filtered by ?group=, ?author= and ?repository=PROJ/repo.
+func (server *SnobServer) handleStats(
+	response http.ResponseWriter, request *http.Request,
+) {
+	if request.Method != "GET" {
+		server.reportError(
+			response,
+			NewError(ErrorBadRequest, "method %s is not allowed", request.Method),
+			http.StatusMethodNotAllowed,
+		)
+		return
+	}
+
+	request, ok := server.authorize(response, request, OperationGroups)
+	if !ok {
+		return
+	}
+
+	if server.config.HistoryFile == "" {
+		server.reportError(
+			response,
+			NewError(ErrorBadRequest, "history_file is not configured"),
+			http.StatusBadRequest,
+		)
+		return
+	}
+
+	var (
+		query      = request.URL.Query()
+		group      = query.Get("group")
+		author     = query.Get("author")
+		repository = query.Get("repository")
+		key        = getRequestAPIKey(request)
+		now        = time.Now()
+		windows    = []time.Duration{}
+	)
+
+	for _, raw := range query["window"] {
+		window, err := time.ParseDuration(raw)
+		if err != nil || window <= 0 {
+			server.reportError(
+				response,
+				NewError(
+					ErrorBadRequest,
+					"invalid window %q, expected duration like 168h", raw,
+				),
+				http.StatusBadRequest,
+			)
+			return
+		}
+
+		windows = append(windows, window)
+	}
+
+	if len(windows) == 0 {
+		for _, window := range server.config.Stats.Windows {
+			windows = append(windows, window.Duration)
+		}
+	}
+
+	sort.Slice(windows, func(i, j int) bool {
+		return windows[i] < windows[j]
+	})
+
+	result := StatsResponse{Success: true, Windows: []StatsWindow{}}
+	for _, window := range windows {
+		result.Windows = append(result.Windows, StatsWindow{
+			Window:    window.String(),
+			Since:     now.Add(-window),
+			Reviewers: map[string]int{},
+		})
+	}
+
+	if len(windows) > 0 {
+		since := now.Add(-windows[len(windows)-1])
+
+		// file is read on every request, so assignments recorded by other
+		// replicas and by snobs add are counted
+		history, err := server.history.ReadSince(since)
+		if err != nil {
+			server.reportError(response, err, http.StatusInternalServerError)
+			return
+		}
+
+		for _, entry := range history.Since(since) {
+			if !matchStatsEntry(entry, group, author, repository) {
+				continue
+			}
+
+			if key != nil &&
+				!key.AllowsRepository(entry.Project, entry.Repository) {
+				continue
+			}
+
+			for index := range result.Windows {
+				stats := &result.Windows[index]
+				if entry.Time.Before(stats.Since) {
+					continue
+				}
+
+				for _, reviewer := range entry.Reviewers {
+					stats.Total++
+					stats.Reviewers[normalizeUser(reviewer)]++
+				}
+			}
+		}
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(response).Encode(result)
+}
+
+func matchStatsEntry(
+	entry HistoryEntry, group string, author string, repository string,
+) bool {
+	if group != "" && entry.Group != group {
+		return false
+	}
+
+	if author != "" && !strings.EqualFold(entry.Author, author) {
+		return false
+	}
+
+	if repository != "" && !strings.EqualFold(
+		entry.Project+"/"+entry.Repository, repository,
+	) {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type StatsDConfig struct {
+	Address string   `toml:"address"`
+	Prefix  string   `toml:"prefix"`
+	Tags    []string `toml:"tags"`
+
+	// DogStatsD enables tags extension, plain StatsD gets tag values
+	// appended to metric name instead.
+	DogStatsD bool `toml:"dogstatsd"`
+}
+
+// StatsD sends metrics over UDP, sending is best-effort and never blocks
+// request handling. All methods are no-op on nil StatsD.
+type StatsD struct {
+	conn      net.Conn
+	prefix    string
+	tags      []string
+	dogStatsD bool
+}
+
+// NewStatsD returns nil if statsd is not configured.
+func NewStatsD(config StatsDConfig) (*StatsD, error) {
+	if config.Address == "" {
+		return nil, nil
+	}
+
+	conn, err := net.Dial("udp", config.Address)
+	if err != nil {
+		return nil, fmt.Errorf("can't connect to statsd: %s", err)
+	}
+
+	return &StatsD{
+		conn:      conn,
+		prefix:    config.Prefix,
+		tags:      config.Tags,
+		dogStatsD: config.DogStatsD,
+	}, nil
+}
+
+func (statsd *StatsD) Count(name string, value int64, tags ...string) {
+	statsd.send(name, fmt.Sprintf("%d|c", value), tags)
+}
+
+func (statsd *StatsD) Timing(
+	name string, duration time.Duration, tags ...string,
+) {
+	statsd.send(
+		name, fmt.Sprintf("%d|ms", duration/time.Millisecond), tags,
+	)
+}
+
+func (statsd *StatsD) send(name string, value string, tags []string) {
+	if statsd == nil {
+		return
+	}
+
+	var line string
+	if statsd.dogStatsD {
+		line = statsd.prefix + name + ":" + value
+
+		tags = append(append([]string{}, statsd.tags...), tags...)
+		if len(tags) > 0 {
+			line += "|#" + strings.Join(tags, ",")
+		}
+	} else {
+		for _, tag := range tags {
+			name += "." + tag[strings.Index(tag, ":")+1:]
+		}
+
+		line = statsd.prefix + name + ":" + value
+	}
+
+	_, err := statsd.conn.Write([]byte(line))
+	if err != nil {
+		logger.Errorf("can't send metric to statsd: %s", err)
+	}
+}
+
+// timedTransport reports latency of Stash requests.
+type timedTransport struct {
+	statsd *StatsD
+	next   http.RoundTripper
+}
+
+func (transport *timedTransport) RoundTrip(
+	request *http.Request,
+) (*http.Response, error) {
+	started := time.Now()
+
+	response, err := transport.next.RoundTrip(request)
+
+	status := "error"
+	if err == nil {
+		status = fmt.Sprint(response.StatusCode)
+	}
+
+	transport.statsd.Timing(
+		"stash.request", time.Since(started),
+		"method:"+strings.ToLower(request.Method), "status:"+status,
+	)
+
+	return response, err
+}
+
+// statusRecorder remembers status code written by handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func getOutcome(assignment *Assignment, err error) string {
+	switch {
+	case err != nil:
+		return OutcomeError
+
+	case assignment.Skipped:
+		return OutcomeSkipped
+
+	case assignment.Queued:
+		return OutcomeQueued
+
+	case assignment.DryRun:
+		return OutcomeDryRun
+	}
+
+	return OutcomeSuccess
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// handleSuggest serves GET /suggest/%group%/%pull-request%, it selects
+// reviewers the same way as assignment does, but doesn't modify the pull
+// request, so selection can be used by other tools.
+func (server *SnobServer) handleSuggest(
+	response http.ResponseWriter, request *http.Request,
+) {
+	if request.Method != "GET" {
+		server.reportError(
+			response,
+			NewError(ErrorBadRequest, "method %s is not allowed", request.Method),
+			http.StatusMethodNotAllowed,
+		)
+		return
+	}
+
+	request, ok := server.authorize(response, request, OperationGroups)
+	if !ok {
+		return
+	}
+
+	parts, err := splitEscapedPath(
+		strings.TrimPrefix(request.URL.EscapedPath(), "/suggest"),
+	)
+	if err != nil {
+		server.reportError(response, err, getErrorStatus(err))
+		return
+	}
+
+	if len(parts) != 2 {
+		server.reportError(
+			response,
+			NewError(ErrorBadRequest, "/suggest/%%group%%/%%pull-request%%"),
+			http.StatusBadRequest,
+		)
+		return
+	}
+
+	options, err := parseAssignOptions(request)
+	if err != nil {
+		server.reportError(response, err, getErrorStatus(err))
+		return
+	}
+
+	options.DryRun = true
+
+	assignment, err := server.AssignReviewers(
+		getRequestAPIKey(request), parts[0], parts[1], options,
+	)
+	if err != nil {
+		server.reportError(response, err, getErrorStatus(err))
+		return
+	}
+
+	writeResponse(response, http.StatusOK, APIResponse{
+		Success:      true,
+		Skipped:      assignment.Skipped,
+		Reviewers:    assignment.Reviewers,
+		SkippedUsers: assignment.SkippedUsers,
+	})
+}
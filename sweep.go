@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SweepConfig enables periodic assignment to open pull requests which have
+// no reviewers, so pull requests created while snobs or webhook was down
+// are not left behind.
+type SweepConfig struct {
+	Interval     Duration `toml:"interval"`
+	Repositories []string `toml:"repositories"`
+
+	// Group reviewers are selected from, default_group if not set.
+	Group string `toml:"group"`
+}
+
+func validateSweep(config SweepConfig, backend string, defaultGroup string) error {
+	if config.Interval.Duration < 0 {
+		return fmt.Errorf("sweep.interval should not be negative")
+	}
+
+	if config.Interval.Duration == 0 {
+		return nil
+	}
+
+	if backend != BackendStash {
+		return fmt.Errorf("sweep is supported only by stash backend")
+	}
+
+	if len(config.Repositories) == 0 {
+		return fmt.Errorf("sweep.repositories is required")
+	}
+
+	for _, name := range config.Repositories {
+		if strings.Count(name, "/") != 1 || strings.ContainsAny(name, "*?[") {
+			return fmt.Errorf(
+				"sweep repository %q should look like PROJECT/repo", name,
+			)
+		}
+	}
+
+	if config.Group == "" && defaultGroup == "" {
+		return fmt.Errorf("sweep.group or default_group is required")
+	}
+
+	return nil
+}
+
+// RunSweep sweeps configured repositories on start and then every
+// interval until process exits, nothing is done if interval is not set.
+func (server *SnobServer) RunSweep() {
+	release := server.hold()
+	interval := server.config.Sweep.Interval.Duration
+	release()
+
+	if interval <= 0 {
+		return
+	}
+
+	for {
+		release := server.hold()
+		server.Sweep()
+		release()
+
+		time.Sleep(interval)
+	}
+}
+
+// Sweep assigns reviewers to every open pull request of configured
+// repositories which has no reviewers yet, failures are logged and left
+// for the next sweep.
+func (server *SnobServer) Sweep() {
+	err := server.checkMaintenance()
+	if err != nil {
+		logger.Infof("sweep is skipped: %s", err)
+		return
+	}
+
+	group := server.config.Sweep.Group
+	if group == "" {
+		group = server.config.DefaultGroup
+	}
+
+	assigned := 0
+
+	for _, name := range server.config.Sweep.Repositories {
+		project, repository := splitRepositoryName(name)
+
+		pullRequests, err := server.listPullRequests(project, repository, "OPEN")
+		if err != nil {
+			logger.Errorf("can't list pull requests of %s for sweep: %s", name, err)
+			continue
+		}
+
+		for _, pullRequest := range pullRequests {
+			if len(pullRequest.Reviewers) > 0 {
+				continue
+			}
+
+			id := strconv.FormatInt(pullRequest.ID, 10)
+
+			assignment, err := server.assignPullRequest(
+				nil, group, project, repository, id, AssignOptions{},
+			)
+			if err != nil {
+				logger.WithPullRequest(project, repository, id).Errorf(
+					"can't assign reviewers by sweep: %s", err,
+				)
+				continue
+			}
+
+			if !assignment.Changed() {
+				continue
+			}
+
+			assigned++
+
+			entry := assignment.AuditEntry()
+			entry.Caller = "sweep"
+
+			server.audit(nil, entry)
+		}
+	}
+
+	logger.Infof("sweep done, reviewers assigned to %d pull requests", assigned)
+}
+
+// splitRepositoryName splits PROJECT/repo into project and repository.
+func splitRepositoryName(name string) (string, string) {
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+
+	return parts[0], parts[1]
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// tenantPrefix is path prefix which addresses tenant explicitly, like
+// /t/<tenant>/<group>/<pull request>.
+const tenantPrefix = "/t/"
+
+// getTenantConfigs loads configuration file of every tenant. Tenant config
+// has the same format as the main one, but nothing is inherited from it
+// except listen address, so tenants are fully isolated from each other.
+func getTenantConfigs(config *Config) (map[string]*Config, error) {
+	names := []string{}
+	for name := range config.Tenants {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	configs := map[string]*Config{}
+	errs := ConfigErrors{}
+
+	for _, name := range names {
+		if name == "" || strings.Contains(name, "/") {
+			errs = append(errs, fmt.Sprintf("tenants: invalid name %q", name))
+			continue
+		}
+
+		tenant, err := getTenantConfig(config, config.Tenants[name])
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("tenants.%s: %s", name, err))
+			continue
+		}
+
+		configs[name] = tenant
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return configs, nil
+}
+
+func getTenantConfig(root *Config, path string) (*Config, error) {
+	config := getDefaultConfig()
+
+	metadata, err := toml.DecodeFile(path, config)
+	if err != nil {
+		return nil, err
+	}
+
+	errs := ConfigErrors{}
+	for _, key := range metadata.Undecoded() {
+		errs = append(errs, fmt.Sprintf("unknown key %s", key))
+	}
+
+	if config.Listen != "" {
+		errs = append(errs, "listen can't be set for tenant")
+	}
+
+	if len(config.Tenants) > 0 {
+		errs = append(errs, "tenants can't be nested")
+	}
+
+	config.Listen = root.Listen
+
+	errs = append(errs, config.Validate()...)
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return config, nil
+}
+
+// getTenant returns tenant server which should serve the request together
+// with the request rewritten for that server. Tenant is addressed by path
+// prefix or, if there is no prefix, by API key which belongs to it. Nil
+// server means that request is served by the main server.
+func (server *SnobServer) getTenant(
+	request *http.Request,
+) (*SnobServer, *http.Request, error) {
+	if len(server.tenants) == 0 {
+		return nil, request, nil
+	}
+
+	if strings.HasPrefix(request.URL.Path, tenantPrefix) {
+		path := strings.TrimPrefix(request.URL.Path, tenantPrefix)
+
+		name := path
+		rest := "/"
+		if index := strings.Index(path, "/"); index >= 0 {
+			name = path[:index]
+			rest = path[index:]
+		}
+
+		tenant, ok := server.tenants[name]
+		if !ok {
+			return nil, request, NewError(
+				ErrorTenantNotFound, "tenant %q is not found", name,
+			)
+		}
+
+		rewritten := request.WithContext(request.Context())
+		rewritten.URL = cloneURL(request)
+		rewritten.URL.Path = rest
+		rewritten.URL.RawPath = ""
+
+		return tenant, rewritten, nil
+	}
+
+	authorization := request.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return nil, request, nil
+	}
+
+	secret := []byte(strings.TrimPrefix(authorization, "Bearer "))
+
+	for _, key := range server.keys {
+		if subtle.ConstantTimeCompare([]byte(key.Key), secret) == 1 {
+			return nil, request, nil
+		}
+	}
+
+	for _, tenant := range server.tenants {
+		for _, key := range tenant.keys {
+			if subtle.ConstantTimeCompare([]byte(key.Key), secret) == 1 {
+				return tenant, request, nil
+			}
+		}
+	}
+
+	return nil, request, nil
+}
+
+func cloneURL(request *http.Request) *url.URL {
+	clone := *request.URL
+	return &clone
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// TimezoneConfig annotates users and groups with IANA time zones, like
+// Europe/Berlin, zone of user wins over zones of groups user is member of.
+// Working hours are the same for everybody in their local time.
+type TimezoneConfig struct {
+	Users     map[string]string `toml:"users"`
+	Groups    map[string]string `toml:"groups"`
+	WorkStart int               `toml:"work_start"`
+	WorkEnd   int               `toml:"work_end"`
+}
+
+func validateTimezones(config TimezoneConfig) ConfigErrors {
+	errs := ConfigErrors{}
+
+	for _, kind := range []struct {
+		name  string
+		zones map[string]string
+	}{
+		{"users", config.Users},
+		{"groups", config.Groups},
+	} {
+		names := []string{}
+		for name := range kind.zones {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			_, err := time.LoadLocation(kind.zones[name])
+			if err != nil {
+				errs = append(errs, fmt.Sprintf(
+					"timezones.%s.%s: %s", kind.name, name, err,
+				))
+			}
+		}
+	}
+
+	if config.WorkStart < 0 || config.WorkEnd > 24 ||
+		config.WorkStart >= config.WorkEnd {
+		errs = append(errs, "timezones.work_start and timezones.work_end "+
+			"should be hours of day, start before end")
+	}
+
+	return errs
+}
+
+// getUserLocation returns time zone of the user, nil if it's not known.
+// Groups are checked in order of their names, so result is stable if user
+// is member of several annotated groups.
+func (server *SnobServer) getUserLocation(user string) *time.Location {
+	config := server.config.Timezones
+
+	for name, zone := range config.Users {
+		if normalizeUser(name) == normalizeUser(user) {
+			location, _ := time.LoadLocation(zone)
+			return location
+		}
+	}
+
+	groups := []string{}
+	for group := range config.Groups {
+		groups = append(groups, group)
+	}
+
+	sort.Strings(groups)
+
+	for _, group := range groups {
+		members, err := server.getCachedUsers(group)
+		if err != nil {
+			logger.Warnf("can't get members of %s for time zone: %s", group, err)
+			continue
+		}
+
+		if containsUser(members, user) {
+			location, _ := time.LoadLocation(config.Groups[group])
+			return location
+		}
+	}
+
+	return nil
+}
+
+// getWorkingOverlap returns number of hours working day of reviewer
+// overlaps working day of author which includes given time.
+func getWorkingOverlap(
+	author *time.Location, reviewer *time.Location,
+	start int, end int, now time.Time,
+) float64 {
+	authorStart, authorEnd := getWorkingDay(now, author, start, end)
+
+	overlap := time.Duration(0)
+
+	// working day of reviewer in far zone may overlap with the end of
+	// previous or the start of next day of author
+	for _, offset := range []int{-1, 0, 1} {
+		reviewerStart, reviewerEnd := getWorkingDay(
+			authorStart.AddDate(0, 0, offset), reviewer, start, end,
+		)
+
+		if reviewerStart.Before(authorStart) {
+			reviewerStart = authorStart
+		}
+
+		if reviewerEnd.After(authorEnd) {
+			reviewerEnd = authorEnd
+		}
+
+		if reviewerEnd.After(reviewerStart) {
+			overlap += reviewerEnd.Sub(reviewerStart)
+		}
+	}
+
+	return overlap.Hours()
+}
+
+// getWorkingDay returns start and end of working hours of the day, which
+// includes given time in given location.
+func getWorkingDay(
+	day time.Time, location *time.Location, start int, end int,
+) (time.Time, time.Time) {
+	day = day.In(location)
+
+	year, month, date := day.Date()
+
+	return time.Date(year, month, date, start, 0, 0, 0, location),
+		time.Date(year, month, date, end, 0, 0, 0, location)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+// certificateReloader serves TLS certificate which can be replaced while
+// server is running, so renewed certificate is picked up on SIGHUP
+// without restart.
+type certificateReloader struct {
+	certPath string
+	keyPath  string
+
+	mutex       sync.RWMutex
+	certificate *tls.Certificate
+}
+
+func newCertificateReloader(
+	certPath string, keyPath string,
+) (*certificateReloader, error) {
+	reloader := &certificateReloader{
+		certPath: certPath,
+		keyPath:  keyPath,
+	}
+
+	err := reloader.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+func (reloader *certificateReloader) Reload() error {
+	certificate, err := tls.LoadX509KeyPair(reloader.certPath, reloader.keyPath)
+	if err != nil {
+		return fmt.Errorf("can't load tls certificate: %s", err)
+	}
+
+	reloader.mutex.Lock()
+	reloader.certificate = &certificate
+	reloader.mutex.Unlock()
+
+	return nil
+}
+
+func (reloader *certificateReloader) GetCertificate(
+	*tls.ClientHelloInfo,
+) (*tls.Certificate, error) {
+	reloader.mutex.RLock()
+	defer reloader.mutex.RUnlock()
+
+	return reloader.certificate, nil
+}
+
+// loadClientCA reads PEM bundle of CA certificates client certificates
+// are verified against.
+func loadClientCA(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read client_ca: %s", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("client_ca: no certificates found in %s", path)
+	}
+
+	return pool, nil
+}
+
+func validateTLS(config *Config) error {
+	if config.TLSCert == "" && config.TLSKey == "" {
+		if config.ClientCA != "" {
+			return fmt.Errorf("client_ca requires tls_cert and tls_key")
+		}
+
+		return nil
+	}
+
+	if config.TLSCert == "" || config.TLSKey == "" {
+		return fmt.Errorf("tls_cert and tls_key should be set together")
+	}
+
+	_, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+	if err != nil {
+		return fmt.Errorf("can't load tls certificate: %s", err)
+	}
+
+	if config.ClientCA != "" {
+		_, err := loadClientCA(config.ClientCA)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
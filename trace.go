@@ -0,0 +1,47 @@
+package main
+
+// Trace records how candidates were narrowed down during selection, so
+// anybody can find out why they were or were not picked.
+type Trace struct {
+	Groups map[string][]string `json:"groups"`
+	Steps  []TraceStep         `json:"steps"`
+}
+
+type TraceStep struct {
+	Step    string   `json:"step"`
+	Reason  string   `json:"reason,omitempty"`
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Result  []string `json:"result"`
+}
+
+func NewTrace() *Trace {
+	return &Trace{
+		Groups: map[string][]string{},
+		Steps:  []TraceStep{},
+	}
+}
+
+// Group records members of fetched group, trace may be nil.
+func (trace *Trace) Group(group string, users []string) {
+	if trace == nil {
+		return
+	}
+
+	trace.Groups[group] = users
+}
+
+// Step records candidates before and after the step, trace may be nil.
+func (trace *Trace) Step(step, reason string, before, after []string) {
+	if trace == nil {
+		return
+	}
+
+	trace.Steps = append(trace.Steps, TraceStep{
+		Step:    step,
+		Reason:  reason,
+		Added:   excludeUsers(after, before),
+		Removed: excludeUsers(before, after),
+		Result:  after,
+	})
+}
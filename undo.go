@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// UndoAssignment removes reviewers added by the most recent assignment to
+// the pull request, reviewers added by anybody else are kept, as well as
+// reviewers who are no longer on the pull request.
+func (server *SnobServer) UndoAssignment(
+	key *APIKey, pullRequestURL string,
+) (*Assignment, error) {
+	if instance := server.getInstance(pullRequestURL); instance != server {
+		return instance.UndoAssignment(key, pullRequestURL)
+	}
+
+	project, repository, pullRequest, err := server.backend.ParsePullRequestURL(
+		pullRequestURL,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return server.undoAssignment(key, project, repository, pullRequest)
+}
+
+func (server *SnobServer) undoAssignment(
+	key *APIKey, project string, repository string, pullRequest string,
+) (*Assignment, error) {
+	assignment, last, err := server.prepareUndo(
+		key, project, repository, pullRequest,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if assignment.DryRun {
+		return assignment, nil
+	}
+
+	err = server.applyUndo(assignment, last)
+	if err != nil {
+		return nil, err
+	}
+
+	return assignment, nil
+}
+
+// prepareUndo finds the last assignment to the pull request and returns
+// undo assignment with its reviewers which are still on the pull request,
+// nothing is changed yet.
+func (server *SnobServer) prepareUndo(
+	key *APIKey, project string, repository string, pullRequest string,
+) (*Assignment, HistoryEntry, error) {
+	err := server.checkMaintenance()
+	if err != nil {
+		return nil, HistoryEntry{}, err
+	}
+
+	err = server.checkPullRequestAccess(key, project, repository)
+	if err != nil {
+		return nil, HistoryEntry{}, err
+	}
+
+	last, ok := server.history.LastAssignment(project, repository, pullRequest)
+	if !ok {
+		return nil, HistoryEntry{}, NewError(
+			ErrorBadRequest, "no assignment to undo on %s/%s#%s",
+			project, repository, pullRequest,
+		)
+	}
+
+	info, err := server.GetPullRequestInfo(project, repository, pullRequest)
+	if err != nil {
+		return nil, HistoryEntry{}, err
+	}
+
+	current := []string{}
+	for _, reviewer := range info.Reviewers {
+		current = append(current, reviewer.User.Name)
+	}
+
+	removed := getIntersection(current, last.Reviewers)
+
+	assignment := &Assignment{
+		Project:     project,
+		Repository:  repository,
+		PullRequest: pullRequest,
+		Group:       last.Group,
+		Info:        info,
+		Reviewers:   removed,
+	}
+
+	if server.config.DryRun {
+		logger.WithPullRequest(project, repository, pullRequest).Infof(
+			"dry run, would undo assignment of %v", removed,
+		)
+
+		assignment.DryRun = true
+	}
+
+	return assignment, last, nil
+}
+
+// applyUndo removes reviewers of undo assignment from the pull request and
+// records undo of the last assignment.
+func (server *SnobServer) applyUndo(
+	assignment *Assignment, last HistoryEntry,
+) error {
+	project, repository, pullRequest := assignment.Project,
+		assignment.Repository, assignment.PullRequest
+
+	info := assignment.Info
+	removed := assignment.Reviewers
+
+	if len(removed) > 0 {
+		err := server.backend.RemoveReviewers(
+			project, repository, pullRequest, info, removed,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.WithPullRequest(project, repository, pullRequest).Infof(
+		"undone assignment of %v", removed,
+	)
+
+	err := server.history.Add(HistoryEntry{
+		Time:        time.Now(),
+		Project:     project,
+		Repository:  repository,
+		PullRequest: pullRequest,
+		Author:      info.Author.User.Name,
+		Group:       last.Group,
+		Reviewers:   removed,
+		Undo:        true,
+		Target:      &last.Time,
+	})
+	if err != nil {
+		logger.Errorf("can't record undo to history: %s", err)
+	}
+
+	server.events.Publish(Event{
+		Type:        EventUndo,
+		Project:     project,
+		Repository:  repository,
+		PullRequest: pullRequest,
+		Author:      info.Author.User.Name,
+		Group:       last.Group,
+		Reviewers:   removed,
+	})
+
+	return nil
+}
+
+// handleUndo serves POST /v1/undo?url=<pull request>.
+func (server *SnobServer) handleUndo(
+	response http.ResponseWriter, request *http.Request,
+) {
+	if request.Method != "POST" {
+		server.reportError(
+			response,
+			NewError(ErrorBadRequest, "method %s is not allowed", request.Method),
+			http.StatusMethodNotAllowed,
+		)
+		return
+	}
+
+	request, ok := server.authorize(response, request, OperationReviewers)
+	if !ok {
+		return
+	}
+
+	pullRequestURL := request.URL.Query().Get("url")
+
+	assignment, err := server.UndoAssignment(
+		getRequestAPIKey(request), pullRequestURL,
+	)
+	if err != nil {
+		server.auditFailure(
+			request, AuditEntry{Action: AuditUndo}, pullRequestURL, err,
+		)
+
+		server.reportError(response, err, getErrorStatus(err))
+		return
+	}
+
+	if !assignment.DryRun {
+		entry := assignment.AuditEntry()
+		entry.Action = AuditUndo
+
+		server.audit(request, entry)
+	}
+
+	writeResponse(response, http.StatusOK, APIResponse{
+		Success: true,
+		Removed: assignment.Reviewers,
+		DryRun:  assignment.DryRun,
+		Changed: assignment.Changed() && len(assignment.Reviewers) > 0,
+	})
+}
+
+// removeStashReviewers removes reviewers one by one through participants
+// endpoint or, on old Stash, updates pull request with remaining reviewers.
+func (server *SnobServer) removeStashReviewers(
+	project string, repository string, pullRequest string,
+	info *ResponsePullRequest, users []string,
+) error {
+	if server.usesParticipantsAPI() {
+		for _, user := range users {
+			err := server.RemoveParticipant(
+				project, repository, pullRequest, user,
+			)
+			if err != nil {
+				return fmt.Errorf("can't remove reviewer %s: %s", user, err)
+			}
+		}
+
+		return nil
+	}
+
+	return server.updateStashReviewers(
+		project, repository, pullRequest, info,
+		func(info *ResponsePullRequest) []map[string]interface{} {
+			current := []string{}
+			for _, reviewer := range info.Reviewers {
+				current = append(current, reviewer.User.Name)
+			}
+
+			return getReviewers(excludeUsers(current, users), nil)
+		},
+	)
+}
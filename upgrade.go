@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Binary upgrade works by passing listening socket to the child process
+// as inherited file descriptor. Parent keeps accepting connections until
+// child reports readiness through pipe, then stops accepting and drains
+// in-flight requests, so the socket is never closed during deploy.
+const (
+	envListenerFD = "SNOBS_LISTENER_FD"
+	envReadyFD    = "SNOBS_READY_FD"
+
+	upgradeReadyTimeout = 30 * time.Second
+	upgradeDrainTimeout = time.Minute
+)
+
+type fileListener interface {
+	File() (*os.File, error)
+}
+
+func listen(address string) (net.Listener, error) {
+	fd := os.Getenv(envListenerFD)
+	if fd == "" {
+		return net.Listen("tcp", address)
+	}
+
+	os.Unsetenv(envListenerFD)
+
+	number, err := strconv.Atoi(fd)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", envListenerFD, err)
+	}
+
+	file := os.NewFile(uintptr(number), "listener")
+	defer file.Close()
+
+	logger.Infof("using inherited listener (fd %d)", number)
+
+	return net.FileListener(file)
+}
+
+func notifyParentReady() {
+	fd := os.Getenv(envReadyFD)
+	if fd == "" {
+		return
+	}
+
+	os.Unsetenv(envReadyFD)
+
+	number, err := strconv.Atoi(fd)
+	if err != nil {
+		logger.Warnf("invalid %s: %s", envReadyFD, err)
+		return
+	}
+
+	file := os.NewFile(uintptr(number), "ready")
+	defer file.Close()
+
+	_, err = file.Write([]byte{1})
+	if err != nil {
+		logger.Errorf("can't notify parent process: %s", err)
+	}
+}
+
+func (server *SnobServer) handleUpgrades(
+	shutdown *gracefulShutdown, listener net.Listener,
+) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR2)
+
+	for range signals {
+		logger.Infof("upgrade requested, starting new process")
+
+		// new process can't open databases while they are locked here,
+		// running jobs are finished and saved before they are closed
+		server.CloseStores()
+
+		err := startChild(listener)
+		if err != nil {
+			logger.Errorf("can't upgrade: %s", err)
+
+			err = server.OpenStores()
+			if err != nil {
+				logger.Errorf("can't reopen stores after failed upgrade: %s", err)
+			}
+
+			continue
+		}
+
+		signal.Stop(signals)
+
+		logger.Infof("new process is ready, draining connections")
+
+		shutdown.Drain(upgradeDrainTimeout)
+
+		return
+	}
+}
+
+func startChild(listener net.Listener) error {
+	inheritable, ok := listener.(fileListener)
+	if !ok {
+		return errors.New("listener can't be passed to a child process")
+	}
+
+	listenerFile, err := inheritable.File()
+	if err != nil {
+		return err
+	}
+
+	defer listenerFile.Close()
+
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+
+	defer readyReader.Close()
+
+	executable, err := os.Executable()
+	if err != nil {
+		readyWriter.Close()
+		return err
+	}
+
+	command := exec.Command(executable, os.Args[1:]...)
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+	command.ExtraFiles = []*os.File{listenerFile, readyWriter}
+	command.Env = append(
+		os.Environ(),
+		envListenerFD+"=3",
+		envReadyFD+"=4",
+	)
+
+	err = command.Start()
+	readyWriter.Close()
+	if err != nil {
+		return err
+	}
+
+	ready := make(chan error, 1)
+	go func() {
+		_, err := readyReader.Read(make([]byte, 1))
+		ready <- err
+	}()
+
+	select {
+	case err = <-ready:
+		if err != nil {
+			command.Wait()
+			return fmt.Errorf("new process exited before becoming ready")
+		}
+
+	case <-time.After(upgradeReadyTimeout):
+		command.Process.Kill()
+		command.Wait()
+		return fmt.Errorf(
+			"new process is not ready after %s", upgradeReadyTimeout,
+		)
+	}
+
+	return command.Process.Release()
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const (
+	EventPullRequestOpened = "pr:opened"
+	EventCommentAdded      = "pr:comment:added"
+	EventPing              = "diagnostics:ping"
+)
+
+// webhookSignatureHeader carries HMAC-SHA256 of the payload signed with
+// webhook secret, as sent by Bitbucket Server.
+const webhookSignatureHeader = "X-Hub-Signature"
+
+type WebhookConfig struct {
+	// Secret is used to verify webhook signature, webhooks with valid
+	// signature don't need API key.
+	Secret string `toml:"secret"`
+
+	// Group reviewers are assigned from for opened pull requests,
+	// default_group is used if it's not set.
+	Group string `toml:"group"`
+}
+
+var (
+	reRerollCommand = regexp.MustCompile(
+		`(?im)^\s*!snobs\s+reroll(?:\s+(\S+))?\s*$`,
+	)
+)
+
+// WebhookEvent is the part of Bitbucket Server webhook payload which is
+// used by snobs.
+type WebhookEvent struct {
+	EventKey string `json:"eventKey"`
+	Actor    struct {
+		Name string `json:"name"`
+	} `json:"actor"`
+	PullRequest struct {
+		ID     int64 `json:"id"`
+		Author struct {
+			User struct {
+				Name string `json:"name"`
+			} `json:"user"`
+		} `json:"author"`
+		ToRef struct {
+			Repository struct {
+				Slug    string `json:"slug"`
+				Project struct {
+					Key string `json:"key"`
+				} `json:"project"`
+			} `json:"repository"`
+		} `json:"toRef"`
+	} `json:"pullRequest"`
+	Comment struct {
+		Text string `json:"text"`
+	} `json:"comment"`
+}
+
+func (event *WebhookEvent) coordinates() (string, string, string) {
+	repository := event.PullRequest.ToRef.Repository
+
+	return repository.Project.Key, repository.Slug,
+		fmt.Sprint(event.PullRequest.ID)
+}
+
+// handleWebhook serves POST /webhook for Bitbucket Server webhooks, events
+// which snobs doesn't handle are acknowledged and ignored. If webhook
+// secret is configured, webhook is authenticated by its signature instead
+// of API key.
+func (server *SnobServer) handleWebhook(
+	response http.ResponseWriter, request *http.Request,
+) {
+	payload, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		server.reportError(response, err, http.StatusBadRequest)
+		return
+	}
+
+	secret := server.config.Webhook.Secret
+	if secret != "" {
+		err := verifyWebhookSignature(
+			secret, payload, request.Header.Get(webhookSignatureHeader),
+		)
+		if err != nil {
+			server.reportError(response, err, getErrorStatus(err))
+			return
+		}
+	} else {
+		var ok bool
+
+		request, ok = server.authorize(response, request, OperationReviewers)
+		if !ok {
+			return
+		}
+	}
+
+	var event WebhookEvent
+
+	err = json.Unmarshal(payload, &event)
+	if err != nil {
+		server.reportError(
+			response,
+			NewError(ErrorBadRequest, "invalid webhook payload: %s", err),
+			http.StatusBadRequest,
+		)
+		return
+	}
+
+	switch event.EventKey {
+	case EventPing:
+		writeResponse(response, http.StatusOK, APIResponse{Success: true})
+
+	case EventPullRequestOpened:
+		server.handlePullRequestOpened(response, request, &event)
+
+	case EventCommentAdded:
+		server.handleCommentAdded(response, request, &event)
+
+	default:
+		writeResponse(
+			response, http.StatusOK, APIResponse{Success: true, Skipped: true},
+		)
+	}
+}
+
+func verifyWebhookSignature(secret string, payload []byte, signature string) error {
+	if signature == "" {
+		return NewError(ErrorUnauthorized, "webhook signature is required")
+	}
+
+	expected := hmac.New(sha256.New, []byte(secret))
+	expected.Write(payload)
+
+	digest, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || !hmac.Equal(digest, expected.Sum(nil)) {
+		return NewError(ErrorUnauthorized, "invalid webhook signature")
+	}
+
+	return nil
+}
+
+// handlePullRequestOpened assigns reviewers to just opened pull request.
+func (server *SnobServer) handlePullRequestOpened(
+	response http.ResponseWriter, request *http.Request, event *WebhookEvent,
+) {
+	project, repository, pullRequest := event.coordinates()
+
+	usergroup := server.config.Webhook.Group
+	if usergroup == "" {
+		usergroup = server.config.DefaultGroup
+	}
+
+	if usergroup == "" {
+		logger.WithPullRequest(project, repository, pullRequest).Warnf(
+			"ignoring opened pull request, " +
+				"neither webhook.group nor default_group is configured",
+		)
+
+		writeResponse(
+			response, http.StatusOK, APIResponse{Success: true, Skipped: true},
+		)
+		return
+	}
+
+	assignment, err := server.assignPullRequest(
+		getRequestAPIKey(request), usergroup,
+		project, repository, pullRequest, AssignOptions{},
+	)
+	if err != nil {
+		server.auditFailure(request, AuditEntry{
+			Action:      AuditAddReviewers,
+			Project:     project,
+			Repository:  repository,
+			PullRequest: pullRequest,
+			Group:       usergroup,
+			Details:     "pull request opened by " + event.Actor.Name,
+		}, "", err)
+
+		server.reportError(response, err, getErrorStatus(err))
+		return
+	}
+
+	if assignment.Changed() {
+		entry := assignment.AuditEntry()
+		entry.Details = "pull request opened by " + event.Actor.Name
+
+		server.audit(request, entry)
+	}
+
+	status, body := getAssignmentResponse(assignment)
+
+	writeResponse(response, status, body)
+}
+
+// handleCommentAdded re-rolls reviewers if author of the pull request
+// comments with "!snobs reroll [group]".
+func (server *SnobServer) handleCommentAdded(
+	response http.ResponseWriter, request *http.Request, event *WebhookEvent,
+) {
+	matches := reRerollCommand.FindStringSubmatch(event.Comment.Text)
+	if matches == nil {
+		writeResponse(
+			response, http.StatusOK, APIResponse{Success: true, Skipped: true},
+		)
+		return
+	}
+
+	project, repository, pullRequest := event.coordinates()
+
+	author := event.PullRequest.Author.User.Name
+	if !strings.EqualFold(event.Actor.Name, author) {
+		logger.WithPullRequest(project, repository, pullRequest).Warnf(
+			"ignoring reroll by %s, only author %s can reroll",
+			event.Actor.Name, author,
+		)
+
+		writeResponse(
+			response, http.StatusOK, APIResponse{Success: true, Skipped: true},
+		)
+		return
+	}
+
+	assignment, err := server.RerollReviewers(
+		getRequestAPIKey(request), matches[1],
+		project, repository, pullRequest,
+	)
+	if err != nil {
+		server.auditFailure(request, AuditEntry{
+			Action:      AuditReroll,
+			Project:     project,
+			Repository:  repository,
+			PullRequest: pullRequest,
+			Group:       matches[1],
+			Details:     "requested by " + event.Actor.Name,
+		}, "", err)
+
+		server.reportError(response, err, getErrorStatus(err))
+		return
+	}
+
+	if assignment.Changed() {
+		entry := assignment.AuditEntry()
+		entry.Action = AuditReroll
+		entry.Details = "requested by " + event.Actor.Name
+
+		server.audit(request, entry)
+	}
+
+	status, body := getAssignmentResponse(assignment)
+
+	writeResponse(response, status, body)
+}
+
+// RerollReviewers picks fresh reviewers, which are different from ones
+// added by the last assignment, from the given group or the group of the
+// last assignment, and then removes reviewers of the last assignment.
+// Previous reviewers are kept if no new ones are added, so pull request is
+// never left without reviewers.
+func (server *SnobServer) RerollReviewers(
+	key *APIKey, usergroup string,
+	project string, repository string, pullRequest string,
+) (*Assignment, error) {
+	undone, last, err := server.prepareUndo(
+		key, project, repository, pullRequest,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if usergroup == "" {
+		usergroup = undone.Group
+	}
+
+	log := logger.WithPullRequest(project, repository, pullRequest)
+
+	log.Infof("rerolling reviewers from %s", usergroup)
+
+	assignment, err := server.assignPullRequest(
+		key, usergroup, project, repository, pullRequest,
+		AssignOptions{Excluded: undone.Reviewers},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if assignment.Skipped || assignment.Queued || assignment.DryRun ||
+		undone.DryRun {
+		log.Infof("no reviewers added, keeping reviewers %v", undone.Reviewers)
+
+		return assignment, nil
+	}
+
+	// reviewers are removed from the pull request as it is after the new
+	// ones are added
+	undone.Info, err = server.GetPullRequestInfo(
+		project, repository, pullRequest,
+	)
+	if err == nil {
+		err = server.applyUndo(undone, last)
+	}
+	if err != nil {
+		return nil, NewError(
+			getErrorCategory(err),
+			"reviewers %s are added, but previous reviewers %s "+
+				"can't be removed: %s",
+			strings.Join(assignment.Reviewers, ", "),
+			strings.Join(undone.Reviewers, ", "), err,
+		)
+	}
+
+	return assignment, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const SignalOpenReviews = "open_reviews"
+
+type WorkloadConfig struct {
+	// Window limits how old assignments are used to find repositories
+	// where candidates review pull requests.
+	Window Duration `toml:"window"`
+}
+
+// WorkloadStrategy picks candidates with the least number of open pull
+// requests they review. Stash reports reviewers only per repository, so
+// reviews are counted in the pull request repository and in every
+// repository where candidate was assigned by snobs during the window.
+type WorkloadStrategy struct {
+	Window time.Duration
+}
+
+func (strategy WorkloadStrategy) Select(
+	selection *Selection, users []string, count int,
+) ([]string, error) {
+	var (
+		server       = selection.server
+		repositories = strategy.getRepositories(selection, users)
+		loads        = map[string]int{}
+	)
+
+	for _, user := range users {
+		for _, repository := range repositories[normalizeUser(user)] {
+			load, err := server.GetOpenReviewsCount(
+				repository.Project, repository.Repository, user,
+			)
+			if err != nil {
+				return nil, err
+			}
+
+			loads[user] += load
+		}
+	}
+
+	// Candidates are shuffled first, so ties are not always resolved in
+	// favor of the same users.
+	ranked := append([]string{}, users...)
+	rand.Shuffle(len(ranked), func(i, j int) {
+		ranked[i], ranked[j] = ranked[j], ranked[i]
+	})
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return loads[ranked[i]] < loads[ranked[j]]
+	})
+
+	for _, user := range ranked {
+		selection.Scores[user] = -float64(loads[user])
+		selection.Signals[user] = map[string]float64{
+			SignalOpenReviews: float64(loads[user]),
+		}
+
+		logger.Debugf("[workload] %s: %d open reviews", user, loads[user])
+	}
+
+	return selectRankedUsers(ranked, count, selection.Required), nil
+}
+
+type workloadRepository struct {
+	Project    string
+	Repository string
+}
+
+// getRepositories returns repositories to count reviews in per normalized
+// user name.
+func (strategy WorkloadStrategy) getRepositories(
+	selection *Selection, users []string,
+) map[string][]workloadRepository {
+	current := workloadRepository{
+		Project:    selection.Project,
+		Repository: selection.Repository,
+	}
+
+	repositories := map[string][]workloadRepository{}
+	seen := map[string]map[workloadRepository]bool{}
+
+	for _, user := range users {
+		user = normalizeUser(user)
+
+		repositories[user] = []workloadRepository{current}
+		seen[user] = map[workloadRepository]bool{current: true}
+	}
+
+	since := time.Now().Add(-strategy.Window)
+
+	for _, entry := range selection.server.history.Since(since) {
+		repository := workloadRepository{
+			Project:    entry.Project,
+			Repository: entry.Repository,
+		}
+
+		for _, reviewer := range entry.Reviewers {
+			reviewer = normalizeUser(reviewer)
+
+			if seen[reviewer] == nil || seen[reviewer][repository] {
+				continue
+			}
+
+			seen[reviewer][repository] = true
+			repositories[reviewer] = append(repositories[reviewer], repository)
+		}
+	}
+
+	return repositories
+}